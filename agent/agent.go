@@ -3,6 +3,8 @@ package agent
 import (
 	"context"
 	"errors"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/mackerelio/mackerel-agent/checks"
@@ -19,12 +21,20 @@ type Agent struct {
 	PluginGenerators   []metrics.PluginGenerator
 	Checkers           []*checks.Checker
 	MetadataGenerators []*metadata.Generator
+
+	graphDefsMu     sync.Mutex
+	postedGraphDefs map[string]*mkr.GraphDefsParam // keyed by the graph names
+
+	pluginMetricNamesMu sync.Mutex
+	pluginMetricNames   map[string]time.Time // the last times the metrics of the plugins are seen
 }
 
 // MetricsResult XXX
 type MetricsResult struct {
 	Created time.Time
 	Values  []*metrics.ValuesCustomIdentifier
+
+	pluginMetricNames []string
 }
 
 // CollectMetrics collects metrics with generators.
@@ -33,8 +43,8 @@ func (agent *Agent) CollectMetrics(collectedTime time.Time) *MetricsResult {
 	for _, g := range agent.PluginGenerators {
 		generators = append(generators, g)
 	}
-	values := generateValues(generators)
-	return &MetricsResult{Created: collectedTime, Values: values}
+	values, pluginMetricNames := generateValues(generators)
+	return &MetricsResult{Created: collectedTime, Values: values, pluginMetricNames: pluginMetricNames}
 }
 
 // Watch XXX
@@ -94,10 +104,49 @@ func (agent *Agent) Watch(ctx context.Context) chan *MetricsResult {
 }
 
 // CollectGraphDefsOfPlugins collects GraphDefs of Plugins
+// and of the built-in generators which define their own graphs.
+// The graphs of the service metrics are collected by CollectServiceGraphDefsOfPlugins.
 func (agent *Agent) CollectGraphDefsOfPlugins() []*mkr.GraphDefsParam {
-	payloads := []*mkr.GraphDefsParam{}
+	var generators []metrics.GraphDefsGenerator
+	for _, g := range agent.MetricsGenerators {
+		if g, ok := g.(metrics.GraphDefsGenerator); ok {
+			generators = append(generators, g)
+		}
+	}
+	for _, g := range agent.PluginGenerators {
+		if pluginService(g) == nil {
+			generators = append(generators, g)
+		}
+	}
+	return collectGraphDefs(generators)
+}
 
+// CollectServiceGraphDefsOfPlugins collects GraphDefs of the plugins posting service metrics,
+// keyed by the service names.
+func (agent *Agent) CollectServiceGraphDefsOfPlugins() map[string][]*mkr.GraphDefsParam {
+	generators := make(map[string][]metrics.GraphDefsGenerator)
 	for _, g := range agent.PluginGenerators {
+		if service := pluginService(g); service != nil {
+			generators[*service] = append(generators[*service], g)
+		}
+	}
+	payloads := make(map[string][]*mkr.GraphDefsParam, len(generators))
+	for service, gs := range generators {
+		payloads[service] = collectGraphDefs(gs)
+	}
+	return payloads
+}
+
+func pluginService(g metrics.PluginGenerator) *string {
+	if g, ok := g.(metrics.ServicePluginGenerator); ok {
+		return g.Service()
+	}
+	return nil
+}
+
+func collectGraphDefs(generators []metrics.GraphDefsGenerator) []*mkr.GraphDefsParam {
+	payloads := []*mkr.GraphDefsParam{}
+	for _, g := range generators {
 		p, err := g.PrepareGraphDefs()
 
 		var faultError *metrics.PluginFaultError
@@ -116,12 +165,88 @@ func (agent *Agent) CollectGraphDefsOfPlugins() []*mkr.GraphDefsParam {
 
 // InitPluginGenerators XXX
 func (agent *Agent) InitPluginGenerators(api *mackerel.API) {
-	payloads := agent.CollectGraphDefsOfPlugins()
+	if err := agent.RefreshGraphDefs(api); err != nil {
+		logger.Errorf("Failed to create graphdefs: %s", err)
+	}
+}
+
+// RefreshGraphDefs collects GraphDefs of Plugins again and posts only the ones
+// which are new or changed since they were posted last time.
+// The graphs of each service are posted separately from the ones of the host
+// not to fail all of them by the invalid ones.
+func (agent *Agent) RefreshGraphDefs(api *mackerel.API) error {
+	agent.graphDefsMu.Lock()
+	defer agent.graphDefsMu.Unlock()
+
+	// The graphs which are not defined anymore are forgotten to be posted again when they are back.
+	posted := make(map[string]*mkr.GraphDefsParam)
+	err := agent.postChangedGraphDefs(api, "", agent.CollectGraphDefsOfPlugins(), posted)
+	for service, payloads := range agent.CollectServiceGraphDefsOfPlugins() {
+		if e := agent.postChangedGraphDefs(api, service+":", payloads, posted); e != nil {
+			logger.Errorf("Failed to create graphdefs of the service %s: %s", service, e)
+		}
+	}
+	agent.postedGraphDefs = posted
+	return err
+}
 
-	if len(payloads) > 0 {
-		err := api.CreateGraphDefs(payloads)
-		if err != nil {
-			logger.Errorf("Failed to create graphdefs: %s", err)
+// postChangedGraphDefs posts the payloads which are changed since they were posted last time,
+// and records the posted ones to posted keyed by the graph names prefixed with keyPrefix.
+func (agent *Agent) postChangedGraphDefs(api *mackerel.API, keyPrefix string, payloads []*mkr.GraphDefsParam, posted map[string]*mkr.GraphDefsParam) error {
+	var changed []*mkr.GraphDefsParam
+	for _, p := range payloads {
+		if last, ok := agent.postedGraphDefs[keyPrefix+p.Name]; !ok || !reflect.DeepEqual(last, p) {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) > 0 {
+		logger.Debugf("Posting %d graphdefs out of %d", len(changed), len(payloads))
+		if err := api.CreateGraphDefs(changed); err != nil {
+			// Keep the ones posted before to post only the changed ones again.
+			for _, p := range payloads {
+				if last, ok := agent.postedGraphDefs[keyPrefix+p.Name]; ok {
+					posted[keyPrefix+p.Name] = last
+				}
+			}
+			return err
+		}
+	}
+	for _, p := range payloads {
+		posted[keyPrefix+p.Name] = p
+	}
+	return nil
+}
+
+// pluginMetricNamesExpiration is the duration after which the metrics of the plugins not seen are forgotten
+// not to keep the names of the metrics which are gone, e.g. of the removed containers, forever.
+// The graphs of the metrics seen again after that are refreshed periodically anyway.
+const pluginMetricNamesExpiration = 1 * time.Hour
+
+// HasNewPluginMetrics tells whether the result contains the metrics of the plugins
+// which are not seen before, and the graphs of them may be newly defined.
+// The metrics of the first result are not regarded as new.
+func (agent *Agent) HasNewPluginMetrics(result *MetricsResult) bool {
+	agent.pluginMetricNamesMu.Lock()
+	defer agent.pluginMetricNamesMu.Unlock()
+
+	first := agent.pluginMetricNames == nil
+	if first {
+		agent.pluginMetricNames = make(map[string]time.Time)
+	}
+	found := false
+	for _, name := range result.pluginMetricNames {
+		seen, ok := agent.pluginMetricNames[name]
+		if !ok {
+			found = true
+		}
+		if !ok || seen.Before(result.Created) {
+			agent.pluginMetricNames[name] = result.Created
+		}
+	}
+	for name, seen := range agent.pluginMetricNames {
+		if result.Created.Sub(seen) > pluginMetricNamesExpiration {
+			delete(agent.pluginMetricNames, name)
 		}
 	}
+	return found && !first
 }
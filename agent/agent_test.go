@@ -2,10 +2,14 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/mackerel"
 	"github.com/mackerelio/mackerel-agent/metrics"
 	mkr "github.com/mackerelio/mackerel-client-go"
 )
@@ -23,6 +27,7 @@ type fakePluginGenerator struct {
 	metrics.PluginGenerator
 	FakeGenerate         func() (metrics.Values, error)
 	FakeCustomIdentifier *string
+	FakeGraphDefs        []*mkr.GraphDefsParam
 }
 
 func (f *fakePluginGenerator) Generate() (metrics.Values, error) {
@@ -30,7 +35,7 @@ func (f *fakePluginGenerator) Generate() (metrics.Values, error) {
 }
 
 func (f *fakePluginGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
-	return nil, nil
+	return f.FakeGraphDefs, nil
 }
 
 func (f *fakePluginGenerator) CustomIdentifier() *string {
@@ -101,3 +106,156 @@ func TestAgent_Watch(t *testing.T) {
 		}
 	}
 }
+
+func TestAgent_RefreshGraphDefs(t *testing.T) {
+	var posted [][]*mkr.GraphDefsParam
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v0/graph-defs/create" {
+			t.Errorf("unexpected request: %s", req.URL.Path)
+		}
+		var payloads []*mkr.GraphDefsParam
+		if err := json.NewDecoder(req.Body).Decode(&payloads); err != nil {
+			t.Fatal(err)
+		}
+		posted = append(posted, payloads)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success":true}`))
+	}))
+	defer ts.Close()
+	api, err := mackerel.NewAPI(ts.URL, "dummy", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &fakePluginGenerator{FakeGraphDefs: []*mkr.GraphDefsParam{
+		{Name: "custom.foo", Unit: "float", Metrics: []*mkr.GraphDefsMetric{{Name: "custom.foo.a"}}},
+		{Name: "custom.bar", Unit: "float", Metrics: []*mkr.GraphDefsMetric{{Name: "custom.bar.a"}}},
+	}}
+	ag := &Agent{PluginGenerators: []metrics.PluginGenerator{g}}
+
+	ag.InitPluginGenerators(api)
+	if len(posted) != 1 || len(posted[0]) != 2 {
+		t.Fatalf("all the graphdefs should be posted at first but %v", posted)
+	}
+
+	if err := ag.RefreshGraphDefs(api); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 {
+		t.Errorf("nothing should be posted unless the graphdefs changed but %v", posted)
+	}
+
+	g.FakeGraphDefs = []*mkr.GraphDefsParam{
+		{Name: "custom.foo", Unit: "percentage", Metrics: []*mkr.GraphDefsMetric{{Name: "custom.foo.a"}}},
+		{Name: "custom.bar", Unit: "float", Metrics: []*mkr.GraphDefsMetric{{Name: "custom.bar.a"}}},
+		{Name: "custom.baz", Unit: "float", Metrics: []*mkr.GraphDefsMetric{{Name: "custom.baz.a"}}},
+	}
+	if err := ag.RefreshGraphDefs(api); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 2 || len(posted[1]) != 2 || posted[1][0].Name != "custom.foo" || posted[1][1].Name != "custom.baz" {
+		t.Errorf("only the changed graphdefs should be posted but %v", posted)
+	}
+}
+
+type fakeServicePluginGenerator struct {
+	fakePluginGenerator
+	service string
+}
+
+func (f *fakeServicePluginGenerator) Service() *string {
+	return &f.service
+}
+
+func TestAgent_RefreshGraphDefs_Service(t *testing.T) {
+	var posted [][]*mkr.GraphDefsParam
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payloads []*mkr.GraphDefsParam
+		if err := json.NewDecoder(req.Body).Decode(&payloads); err != nil {
+			t.Fatal(err)
+		}
+		posted = append(posted, payloads)
+		w.Header().Set("Content-Type", "application/json")
+		if payloads[0].Name == "sales" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"invalid graph name"}}`))
+			return
+		}
+		w.Write([]byte(`{"success":true}`))
+	}))
+	defer ts.Close()
+	api, err := mackerel.NewAPI(ts.URL, "dummy", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ag := &Agent{PluginGenerators: []metrics.PluginGenerator{
+		&fakePluginGenerator{FakeGraphDefs: []*mkr.GraphDefsParam{
+			{Name: "custom.foo", Unit: "float", Metrics: []*mkr.GraphDefsMetric{{Name: "custom.foo.a"}}},
+		}},
+		&fakeServicePluginGenerator{service: "shop", fakePluginGenerator: fakePluginGenerator{FakeGraphDefs: []*mkr.GraphDefsParam{
+			{Name: "sales", Unit: "float", Metrics: []*mkr.GraphDefsMetric{{Name: "sales.a"}}},
+		}}},
+	}}
+	if graphDefs := ag.CollectGraphDefsOfPlugins(); len(graphDefs) != 1 || graphDefs[0].Name != "custom.foo" {
+		t.Errorf("only the graphdefs of the host should be collected but %v", graphDefs)
+	}
+	if err := ag.RefreshGraphDefs(api); err != nil {
+		t.Fatalf("the failure of the service graphdefs should not fail the host ones: %s", err)
+	}
+	if len(posted) != 2 {
+		t.Fatalf("the graphdefs of the service should be posted separately but %v", posted)
+	}
+
+	if err := ag.RefreshGraphDefs(api); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 3 || posted[2][0].Name != "sales" {
+		t.Errorf("only the failed graphdefs of the service should be posted again but %v", posted)
+	}
+}
+
+func TestAgent_HasNewPluginMetrics(t *testing.T) {
+	var builtinNames, pluginNames []string
+	values := func(names []string) metrics.Values {
+		values := metrics.Values{}
+		for _, name := range names {
+			values[name] = metrics.NewValueAttribute(1)
+		}
+		return values
+	}
+	ag := &Agent{
+		MetricsGenerators: []metrics.Generator{&fakeGenerator{
+			FakeGenerate: func() (metrics.Values, error) { return values(builtinNames), nil },
+		}},
+		PluginGenerators: []metrics.PluginGenerator{&fakePluginGenerator{
+			FakeGenerate: func() (metrics.Values, error) { return values(pluginNames), nil },
+		}},
+	}
+	now := time.Now()
+	tests := []struct {
+		builtinNames []string
+		pluginNames  []string
+		elapsed      time.Duration
+		expect       bool
+	}{
+		{[]string{"custom.k8s.pod.a"}, []string{"custom.foo.a"}, 0, false}, // first result
+		{[]string{"custom.k8s.pod.a"}, []string{"custom.foo.a"}, time.Minute, false},
+		// the metrics of the built-in generators are not of the plugins even if prefixed with "custom."
+		{[]string{"custom.k8s.pod.b"}, []string{"custom.foo.a"}, 2 * time.Minute, false},
+		{[]string{"custom.k8s.pod.b"}, []string{"custom.foo.a", "custom.foo.b"}, 3 * time.Minute, true},
+		{nil, []string{"custom.foo.b"}, 4 * time.Minute, false},
+		// the metrics not seen for a long time are forgotten
+		{nil, []string{"custom.foo.b"}, 2 * time.Hour, false},
+		{nil, []string{"custom.foo.a", "custom.foo.b"}, 2*time.Hour + time.Minute, true},
+	}
+	for i, tt := range tests {
+		builtinNames, pluginNames = tt.builtinNames, tt.pluginNames
+		if got := ag.HasNewPluginMetrics(ag.CollectMetrics(now.Add(tt.elapsed))); got != tt.expect {
+			t.Errorf("HasNewPluginMetrics(%v) at %d should be %t but %t", tt.pluginNames, i, tt.expect, got)
+		}
+	}
+	if len(ag.pluginMetricNames) != 2 {
+		t.Errorf("only the metrics of the plugins should be kept but %v", ag.pluginMetricNames)
+	}
+}
@@ -10,17 +10,33 @@ import (
 
 var logger = logging.GetLogger("agent")
 
-func generateValues(generators []metrics.Generator) []*metrics.ValuesCustomIdentifier {
-	processed := make(chan *metrics.ValuesCustomIdentifier)
+type generatedValues struct {
+	values *metrics.ValuesCustomIdentifier
+	plugin bool
+}
+
+// generateValues runs the generators and returns the values, and the names of the metrics of the plugins.
+// The names of the service metrics are prefixed with the service names and ":".
+func generateValues(generators []metrics.Generator) ([]*metrics.ValuesCustomIdentifier, []string) {
+	processed := make(chan *generatedValues)
 	finish := make(chan struct{})
 	result := make(chan []*metrics.ValuesCustomIdentifier)
+	var pluginMetricNames []string
 
 	go func() {
 		allValues := []*metrics.ValuesCustomIdentifier{}
 		for {
 			select {
-			case values := <-processed:
-				allValues = metrics.MergeValuesCustomIdentifiers(allValues, values)
+			case generated := <-processed:
+				allValues = metrics.MergeValuesCustomIdentifiers(allValues, generated.values)
+				if generated.plugin {
+					for name := range generated.values.Values {
+						if service := generated.values.Service; service != nil {
+							name = *service + ":" + name
+						}
+						pluginMetricNames = append(pluginMetricNames, name)
+					}
+				}
 			case <-finish:
 				result <- allValues
 				return
@@ -49,13 +65,21 @@ func generateValues(generators []metrics.Generator) []*metrics.ValuesCustomIdent
 					logger.Errorf("Failed to generate value in %T (skip this metric): %s", g, err.Error())
 					return
 				}
-				var customIdentifier *string
-				if pluginGenerator, ok := g.(metrics.PluginGenerator); ok {
+				var customIdentifier, service *string
+				pluginGenerator, plugin := g.(metrics.PluginGenerator)
+				if plugin {
 					customIdentifier = pluginGenerator.CustomIdentifier()
 				}
-				processed <- &metrics.ValuesCustomIdentifier{
-					Values:           values,
-					CustomIdentifier: customIdentifier,
+				if pluginGenerator, ok := g.(metrics.ServicePluginGenerator); ok {
+					service = pluginGenerator.Service()
+				}
+				processed <- &generatedValues{
+					values: &metrics.ValuesCustomIdentifier{
+						Values:           values,
+						CustomIdentifier: customIdentifier,
+						Service:          service,
+					},
+					plugin: plugin,
 				}
 			}(g)
 		}
@@ -63,5 +87,6 @@ func generateValues(generators []metrics.Generator) []*metrics.ValuesCustomIdent
 		finish <- struct{}{} // processed all jobs
 	}()
 
-	return <-result
+	values := <-result
+	return values, pluginMetricNames
 }
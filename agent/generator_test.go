@@ -24,7 +24,7 @@ func TestGenerateValues(t *testing.T) {
 	tg := &testGenerator{}
 	tpg := &testPanicGenerator{}
 	generators := []metrics.Generator{tg, tpg}
-	values := generateValues(generators)
+	values, _ := generateValues(generators)
 
 	if len(values) != 1 {
 		t.Errorf("Num of results should be 1, but %d", len(values))
@@ -0,0 +1,25 @@
+package checks
+
+import (
+	"fmt"
+	"time"
+)
+
+// builtinCheck performs a check inside the agent instead of invoking a command.
+// The targets are given from the `targets` of the check configuration, i.e. c.Config.Targets.
+type builtinCheck func(c *Checker) (Status, string)
+
+// builtinChecks maps the `builtin` names of check configurations to the checks.
+// Each check registers itself in the file for the platforms it supports.
+var builtinChecks = map[string]builtinCheck{}
+
+func (c *Checker) checkBuiltin() *Report {
+	now := time.Now()
+	check, ok := builtinChecks[c.Config.Builtin]
+	if !ok {
+		return c.newReport(StatusUnknown, fmt.Sprintf("builtin check %q is not supported on this platform", c.Config.Builtin), now)
+	}
+	status, message := check(c)
+	logger.Debugf("Checker %q status=%s message=%q", c.Name, status, message)
+	return c.newReport(status, message, now)
+}
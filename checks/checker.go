@@ -2,9 +2,11 @@ package checks
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/cmdutil"
 	"github.com/mackerelio/mackerel-agent/config"
 )
 
@@ -39,6 +41,13 @@ var exitCodeToStatus = map[int]Status{
 type Checker struct {
 	Name   string
 	Config *config.CheckPlugin
+	// ProcessGroups are the process groups configured by [process_group.{name}], used by the "process_count" builtin check.
+	ProcessGroups map[string]*config.ProcessGroup
+
+	mu         sync.Mutex
+	lastReport *Report
+	nextTime   time.Time
+	lastRun    cmdutil.RunRecorder
 }
 
 // Report is what Checker produces by invoking its command.
@@ -53,13 +62,55 @@ type Report struct {
 }
 
 func (c *Checker) String() string {
-	return fmt.Sprintf("checker %q command=[%s]", c.Name, c.Config.Command)
+	if c.Config.Builtin != "" {
+		return fmt.Sprintf("checker %q builtin=%s targets=%v", c.Name, c.Config.Builtin, c.Config.Targets)
+	}
+	return fmt.Sprintf("checker %q command=[%s]", c.Name, c.Config.Command.CommandString())
 }
 
 // Check invokes the command and transforms its result to a Report.
 func (c *Checker) Check() *Report {
+	report := c.check()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastReport = report
+	return report
+}
+
+// LastReport returns the Report of the last check, or nil if not checked yet.
+func (c *Checker) LastReport() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastReport
+}
+
+// LastRun returns the result of the last run of the command, or nil if not run yet.
+func (c *Checker) LastRun() *cmdutil.RunResult {
+	return c.lastRun.Last()
+}
+
+// NextTime returns the time when the check is scheduled next.
+func (c *Checker) NextTime() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nextTime
+}
+
+// SetNextTime records the time when the check is scheduled next.
+func (c *Checker) SetNextTime(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextTime = t
+}
+
+func (c *Checker) check() *Report {
+	if c.Config.Builtin != "" {
+		return c.checkBuiltin()
+	}
+
 	now := time.Now()
 	message, stderr, exitCode, err := c.Config.Command.Run()
+	c.lastRun.Record(now, stderr, exitCode, err)
 	if stderr != "" {
 		logger.Warningf("Checker %q output stderr: %s", c.Name, stderr)
 	}
@@ -75,11 +126,15 @@ func (c *Checker) Check() *Report {
 		logger.Debugf("Checker %q status=%s message=%q", c.Name, status, message)
 	}
 
+	return c.newReport(status, message, now)
+}
+
+func (c *Checker) newReport(status Status, message string, occurredAt time.Time) *Report {
 	return &Report{
 		Name:                 c.Name,
 		Status:               status,
 		Message:              message,
-		OccurredAt:           now,
+		OccurredAt:           occurredAt,
 		NotificationInterval: c.Config.NotificationInterval,
 		MaxCheckAttempts:     c.Config.MaxCheckAttempts,
 		CustomIdentfier:      c.Config.CustomIdentifier,
@@ -1,6 +1,7 @@
 package checks
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/mackerelio/mackerel-agent/config"
@@ -39,3 +40,31 @@ func TestChecker_Check(t *testing.T) {
 		}
 	}
 }
+
+func TestChecker_CheckBuiltin(t *testing.T) {
+	builtinChecks["test_builtin"] = func(c *Checker) (Status, string) {
+		return StatusWarning, fmt.Sprintf("targets=%v", c.Config.Targets)
+	}
+	t.Cleanup(func() { delete(builtinChecks, "test_builtin") })
+
+	checker := Checker{
+		Name: "builtin",
+		Config: &config.CheckPlugin{
+			Builtin: "test_builtin",
+			Targets: []string{"a", "b"},
+		},
+	}
+	report := checker.Check()
+	if report.Status != StatusWarning {
+		t.Errorf("status should be WARNING: %v", report.Status)
+	}
+	if report.Message != "targets=[a b]" {
+		t.Errorf("wrong message: %q", report.Message)
+	}
+
+	checker.Config.Builtin = "unknown_builtin"
+	report = checker.Check()
+	if report.Status != StatusUnknown {
+		t.Errorf("status should be UNKNOWN: %v", report.Status)
+	}
+}
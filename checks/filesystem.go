@@ -0,0 +1,63 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package checks
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent/util"
+)
+
+func init() {
+	builtinChecks["filesystem_readonly"] = checkFilesystemReadOnly
+}
+
+// Filesystems of these types are always read-only.
+var readOnlyFstypes = []string{"squashfs", "iso9660", "udf", "erofs"}
+
+// checkFilesystemReadOnly reports CRITICAL when any of the targets is mounted read-only.
+// The targets are mountpoints. All local block devices are checked when the targets are empty.
+func checkFilesystemReadOnly(c *Checker) (Status, string) {
+	mounts, err := util.CollectMounts()
+	if err != nil {
+		return StatusUnknown, fmt.Sprintf("failed to collect mounts: %s", err)
+	}
+	return checkReadOnlyMounts(mounts, c.Config.Targets)
+}
+
+func checkReadOnlyMounts(mounts []*util.MountStat, targets []string) (Status, string) {
+	var readOnly, missing []string
+	if len(targets) == 0 {
+		for _, m := range mounts {
+			if !strings.HasPrefix(m.Device, "/dev/") || slices.Contains(readOnlyFstypes, m.Fstype) {
+				continue
+			}
+			if m.ReadOnly() {
+				readOnly = append(readOnly, m.Mountpoint)
+			}
+		}
+	} else {
+		for _, target := range targets {
+			i := slices.IndexFunc(mounts, func(m *util.MountStat) bool {
+				return m.Mountpoint == target
+			})
+			if i < 0 {
+				missing = append(missing, target)
+				continue
+			}
+			if mounts[i].ReadOnly() {
+				readOnly = append(readOnly, target)
+			}
+		}
+	}
+
+	if len(readOnly) > 0 {
+		return StatusCritical, fmt.Sprintf("read-only filesystems: %s", strings.Join(readOnly, ", "))
+	}
+	if len(missing) > 0 {
+		return StatusUnknown, fmt.Sprintf("not mounted: %s", strings.Join(missing, ", "))
+	}
+	return StatusOK, "all filesystems are writable"
+}
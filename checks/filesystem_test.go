@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package checks
+
+import (
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent/util"
+)
+
+func TestCheckReadOnlyMounts(t *testing.T) {
+	mounts := []*util.MountStat{
+		{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4", Options: []string{"rw", "relatime"}},
+		{Device: "/dev/sdb1", Mountpoint: "/data", Fstype: "xfs", Options: []string{"ro", "relatime"}},
+		{Device: "/dev/loop0", Mountpoint: "/snap/core/1", Fstype: "squashfs", Options: []string{"ro"}},
+		{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs", Options: []string{"ro"}},
+	}
+
+	tests := []struct {
+		name    string
+		targets []string
+		status  Status
+		message string
+	}{
+		{"all", nil, StatusCritical, "read-only filesystems: /data"},
+		{"writable", []string{"/"}, StatusOK, "all filesystems are writable"},
+		{"read-only", []string{"/", "/data"}, StatusCritical, "read-only filesystems: /data"},
+		{"missing", []string{"/", "/var"}, StatusUnknown, "not mounted: /var"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, message := checkReadOnlyMounts(mounts, tt.targets)
+			if status != tt.status {
+				t.Errorf("status should be %s but %s", tt.status, status)
+			}
+			if message != tt.message {
+				t.Errorf("message should be %q but %q", tt.message, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package checks
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/util"
+)
+
+func init() {
+	builtinChecks["process_count"] = checkProcessCount
+}
+
+var procPath = "/proc"
+
+// checkProcessCount reports CRITICAL when the number of the processes of any target process group
+// is out of the range of min_instances (1 by default) and max_instances.
+// The targets are the names of [process_group.{name}]. All process groups are checked when the targets are empty.
+func checkProcessCount(c *Checker) (Status, string) {
+	procs, err := util.ListProcesses(procPath)
+	if err != nil {
+		return StatusUnknown, fmt.Sprintf("failed to list the processes: %s", err)
+	}
+	return checkProcessGroupCounts(c.ProcessGroups, countProcessGroups(c.ProcessGroups, procs), c.Config.Targets)
+}
+
+// countProcessGroups counts the processes matching each process group.
+func countProcessGroups(groups map[string]*config.ProcessGroup, procs []*util.Process) map[string]int {
+	counts := make(map[string]int, len(groups))
+	for name, group := range groups {
+		m := &util.ProcessMatcher{
+			Name:        group.Name,
+			Cmdline:     group.Cmdline.Regexp,
+			Pidfile:     group.Pidfile,
+			SystemdUnit: group.SystemdUnit,
+		}
+		for _, p := range procs {
+			if m.Match(p) {
+				counts[name]++
+			}
+		}
+	}
+	return counts
+}
+
+func checkProcessGroupCounts(groups map[string]*config.ProcessGroup, counts map[string]int, targets []string) (Status, string) {
+	if len(targets) == 0 {
+		targets = slices.Sorted(maps.Keys(groups))
+		if len(targets) == 0 {
+			return StatusUnknown, "no process groups are configured"
+		}
+	}
+
+	var errors, unknowns, oks []string
+	for _, name := range targets {
+		group, ok := groups[name]
+		if !ok {
+			unknowns = append(unknowns, fmt.Sprintf("%s: not configured", name))
+			continue
+		}
+		count := counts[name]
+		minInstances := 1
+		if group.MinInstances != nil {
+			minInstances = *group.MinInstances
+		}
+		if count < minInstances {
+			errors = append(errors, fmt.Sprintf("%s: %d < %d processes", name, count, minInstances))
+			continue
+		}
+		if group.MaxInstances != nil && count > *group.MaxInstances {
+			errors = append(errors, fmt.Sprintf("%s: %d > %d processes", name, count, *group.MaxInstances))
+			continue
+		}
+		oks = append(oks, fmt.Sprintf("%s: %d processes", name, count))
+	}
+
+	if len(errors) > 0 {
+		return StatusCritical, strings.Join(append(errors, unknowns...), ", ")
+	}
+	if len(unknowns) > 0 {
+		return StatusUnknown, strings.Join(unknowns, ", ")
+	}
+	return StatusOK, strings.Join(oks, ", ")
+}
@@ -0,0 +1,79 @@
+package checks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+func TestCheckProcessGroupCounts(t *testing.T) {
+	two := 2
+	groups := map[string]*config.ProcessGroup{
+		"nginx":  {Name: "nginx"},
+		"worker": {Name: "worker", MaxInstances: &two},
+		"cron":   {Name: "cron"},
+	}
+	counts := map[string]int{"nginx": 3, "worker": 3}
+
+	tests := []struct {
+		name    string
+		targets []string
+		status  Status
+		message string
+	}{
+		{"ok", []string{"nginx"}, StatusOK, "nginx: 3 processes"},
+		{"too many", []string{"nginx", "worker"}, StatusCritical, "worker: 3 > 2 processes"},
+		{"too few", []string{"cron"}, StatusCritical, "cron: 0 < 1 processes"},
+		{"missing", []string{"nginx", "unknown"}, StatusUnknown, "unknown: not configured"},
+		{"all", nil, StatusCritical, "cron: 0 < 1 processes, worker: 3 > 2 processes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, message := checkProcessGroupCounts(groups, counts, tt.targets)
+			if status != tt.status {
+				t.Errorf("status should be %s but %s", tt.status, status)
+			}
+			if message != tt.message {
+				t.Errorf("message should be %q but %q", tt.message, message)
+			}
+		})
+	}
+
+	if status, _ := checkProcessGroupCounts(nil, nil, nil); status != StatusUnknown {
+		t.Errorf("status should be UNKNOWN without process groups but %s", status)
+	}
+}
+
+func TestChecker_CheckProcessCount(t *testing.T) {
+	root := t.TempDir()
+	defer func(p string) { procPath = p }(procPath)
+	procPath = root
+	for _, pid := range []string{"100", "101", "200"} {
+		comm := "nginx\n"
+		if pid == "200" {
+			comm = "ruby\n"
+		}
+		if err := os.Mkdir(filepath.Join(root, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, pid, "comm"), []byte(comm), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	one := 1
+	checker := &Checker{
+		Name:   "processes",
+		Config: &config.CheckPlugin{Builtin: "process_count"},
+		ProcessGroups: map[string]*config.ProcessGroup{
+			"nginx": {Name: "nginx"},
+			"ruby":  {Name: "ruby", MaxInstances: &one},
+		},
+	}
+	report := checker.Check()
+	if report.Status != StatusOK || report.Message != "nginx: 2 processes, ruby: 1 processes" {
+		t.Errorf("the processes should be counted without the metrics: %s %q", report.Status, report.Message)
+	}
+}
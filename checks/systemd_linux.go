@@ -0,0 +1,62 @@
+package checks
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent/util"
+)
+
+func init() {
+	builtinChecks["systemd_failed"] = checkSystemdFailed
+}
+
+// checkSystemdFailed reports CRITICAL when any of the target units is in the failed state.
+// The targets are unit names (".service" is assumed without suffix). Any unit is checked when the targets are empty.
+func checkSystemdFailed(c *Checker) (Status, string) {
+	targets := c.Config.Targets
+	if len(targets) == 0 {
+		failed, err := util.ListFailedSystemdUnits()
+		if err != nil {
+			return StatusUnknown, fmt.Sprintf("failed to list units: %s", err)
+		}
+		return checkFailedUnits(failed)
+	}
+	units, err := util.ShowSystemdUnits(targets)
+	if err != nil {
+		return StatusUnknown, fmt.Sprintf("failed to show units: %s", err)
+	}
+	return checkSystemdUnits(units)
+}
+
+func checkFailedUnits(failed []string) (Status, string) {
+	if len(failed) > 0 {
+		return StatusCritical, fmt.Sprintf("failed units: %s", strings.Join(failed, ", "))
+	}
+	return StatusOK, "no units are failed"
+}
+
+func checkSystemdUnits(units []*util.SystemdUnit) (Status, string) {
+	var failed, notFound []string
+	for _, unit := range units {
+		switch {
+		case unit.LoadState == "not-found":
+			notFound = append(notFound, unit.ID)
+		case unit.ActiveState == "failed":
+			failed = append(failed, unit.ID)
+		}
+	}
+	if len(failed) > 0 {
+		return checkFailedUnits(failed)
+	}
+	if len(notFound) > 0 {
+		return StatusUnknown, fmt.Sprintf("units not found: %s", strings.Join(notFound, ", "))
+	}
+	ids := make([]string, 0, len(units))
+	for _, unit := range units {
+		ids = append(ids, fmt.Sprintf("%s is %s", unit.ID, unit.ActiveState))
+	}
+	slices.Sort(ids)
+	return StatusOK, strings.Join(ids, ", ")
+}
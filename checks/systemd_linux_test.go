@@ -0,0 +1,45 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent/util"
+)
+
+func TestCheckSystemdUnits(t *testing.T) {
+	nginx := &util.SystemdUnit{ID: "nginx.service", LoadState: "loaded", ActiveState: "active", SubState: "running"}
+	cron := &util.SystemdUnit{ID: "cron.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed"}
+	missing := &util.SystemdUnit{ID: "missing.service", LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}
+
+	tests := []struct {
+		name    string
+		units   []*util.SystemdUnit
+		status  Status
+		message string
+	}{
+		{"active", []*util.SystemdUnit{nginx}, StatusOK, "nginx.service is active"},
+		{"failed", []*util.SystemdUnit{nginx, cron, missing}, StatusCritical, "failed units: cron.service"},
+		{"not found", []*util.SystemdUnit{nginx, missing}, StatusUnknown, "units not found: missing.service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, message := checkSystemdUnits(tt.units)
+			if status != tt.status {
+				t.Errorf("status should be %s but %s", tt.status, status)
+			}
+			if message != tt.message {
+				t.Errorf("message should be %q but %q", tt.message, message)
+			}
+		})
+	}
+}
+
+func TestCheckFailedUnits(t *testing.T) {
+	if status, _ := checkFailedUnits(nil); status != StatusOK {
+		t.Errorf("status should be OK but %s", status)
+	}
+	status, message := checkFailedUnits([]string{"cron.service", "mnt-data.mount"})
+	if status != StatusCritical || message != "failed units: cron.service, mnt-data.mount" {
+		t.Errorf("unexpected result: %s %q", status, message)
+	}
+}
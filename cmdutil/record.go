@@ -0,0 +1,42 @@
+package cmdutil
+
+import (
+	"sync"
+	"time"
+)
+
+// RunResult is the result of a run of a command.
+type RunResult struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	ExitCode  int
+	Stderr    string
+	Err       error
+}
+
+// RunRecorder keeps the result of the last run of a command.
+// The zero value is ready to use.
+type RunRecorder struct {
+	mu   sync.Mutex
+	last *RunResult
+}
+
+// Record records the result of the run started at startedAt.
+func (r *RunRecorder) Record(startedAt time.Time, stderr string, exitCode int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = &RunResult{
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+		ExitCode:  exitCode,
+		Stderr:    stderr,
+		Err:       err,
+	}
+}
+
+// Last returns the result of the last run, or nil if the command has not run yet.
+func (r *RunRecorder) Last() *RunResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
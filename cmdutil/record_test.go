@@ -0,0 +1,24 @@
+package cmdutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunRecorder(t *testing.T) {
+	var r RunRecorder
+	if r.Last() != nil {
+		t.Errorf("Last should be nil before the run but %+v", r.Last())
+	}
+
+	startedAt := time.Now()
+	r.Record(startedAt, "oops", 2, errors.New("failed"))
+	last := r.Last()
+	if last == nil {
+		t.Fatal("Last should be recorded")
+	}
+	if !last.StartedAt.Equal(startedAt) || last.ExitCode != 2 || last.Stderr != "oops" || last.Err == nil || last.Duration < 0 {
+		t.Errorf("unexpected result: %+v", last)
+	}
+}
@@ -0,0 +1,320 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/cmdutil"
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/metrics"
+)
+
+// Status is the status of the running agent served on the admin socket.
+type Status struct {
+	HostID          string          `json:"hostId"`
+	Version         string          `json:"version"`
+	StartedAt       time.Time       `json:"startedAt"`
+	UptimeSeconds   int64           `json:"uptimeSeconds"`
+	LastPostedAt    *time.Time      `json:"lastPostedAt,omitempty"`
+	PostQueueLength int             `json:"postQueueLength"`
+	Retry           RetryStatus     `json:"retry"`
+	Plugins         []PluginStatus  `json:"plugins"`
+	Checkers        []CheckerStatus `json:"checkers"`
+}
+
+// RetryStatus tells whether posting the metrics is failing.
+type RetryStatus struct {
+	Retrying     bool   `json:"retrying"`
+	FailureCount int    `json:"failureCount"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+// PluginStatus is the result of the last run of the plugin.
+type PluginStatus struct {
+	Kind            string     `json:"kind"` // "metrics", "checks" or "metadata"
+	Name            string     `json:"name"`
+	LastRunAt       *time.Time `json:"lastRunAt,omitempty"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	ExitCode        int        `json:"exitCode"`
+	Stderr          string     `json:"stderr,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// CheckerStatus is the last status of the checker.
+type CheckerStatus struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Message       string     `json:"message,omitempty"`
+	LastCheckedAt *time.Time `json:"lastCheckedAt,omitempty"`
+	NextCheckAt   *time.Time `json:"nextCheckAt,omitempty"`
+}
+
+// Status returns the current status of the agent.
+func (app *App) Status() *Status {
+	app.statusMu.Lock()
+	status := &Status{
+		HostID:          app.Host.ID,
+		StartedAt:       app.startedAt,
+		UptimeSeconds:   int64(time.Since(app.startedAt).Seconds()),
+		PostQueueLength: len(app.postQueue),
+		Retry: RetryStatus{
+			Retrying:     app.postFailureCount > 0,
+			FailureCount: app.postFailureCount,
+		},
+		Plugins:  []PluginStatus{},
+		Checkers: []CheckerStatus{},
+	}
+	if !app.lastPostedAt.IsZero() {
+		t := app.lastPostedAt
+		status.LastPostedAt = &t
+	}
+	if app.lastPostError != nil {
+		status.Retry.LastError = app.lastPostError.Error()
+	}
+	app.statusMu.Unlock()
+	if app.AgentMeta != nil {
+		status.Version = app.AgentMeta.Version
+	}
+
+	conf := app.Config
+	runs := app.pluginRuns()
+	for _, name := range slices.Sorted(maps.Keys(conf.MetricPlugins)) {
+		status.Plugins = append(status.Plugins, pluginStatus("metrics", name, runs["metrics."+name]))
+	}
+	for _, name := range slices.Sorted(maps.Keys(conf.CheckPlugins)) {
+		status.Plugins = append(status.Plugins, pluginStatus("checks", name, runs["checks."+name]))
+	}
+	for _, name := range slices.Sorted(maps.Keys(conf.MetadataPlugins)) {
+		status.Plugins = append(status.Plugins, pluginStatus("metadata", name, runs["metadata."+name]))
+	}
+
+	if app.Agent != nil {
+		for _, checker := range app.Agent.Checkers {
+			cs := CheckerStatus{Name: checker.Name}
+			if report := checker.LastReport(); report != nil {
+				cs.Status = string(report.Status)
+				cs.Message = report.Message
+				cs.LastCheckedAt = &report.OccurredAt
+			}
+			if next := checker.NextTime(); !next.IsZero() {
+				cs.NextCheckAt = &next
+			}
+			status.Checkers = append(status.Checkers, cs)
+		}
+		slices.SortFunc(status.Checkers, func(a, b CheckerStatus) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	return status
+}
+
+// pluginRuns returns the results of the last runs of the plugins keyed by the names like "metrics.foo".
+func (app *App) pluginRuns() map[string]*cmdutil.RunResult {
+	runs := make(map[string]*cmdutil.RunResult)
+	if app.Agent == nil {
+		return runs
+	}
+	metricPluginNames := make(map[*config.MetricPlugin]string)
+	for name, plugin := range app.Config.MetricPlugins {
+		metricPluginNames[plugin] = name
+	}
+	for _, g := range app.Agent.PluginGenerators {
+		if g, ok := g.(metrics.CommandPluginGenerator); ok {
+			if name, ok := metricPluginNames[g.PluginConfig()]; ok {
+				runs["metrics."+name] = g.LastRun()
+			}
+		}
+	}
+	for _, checker := range app.Agent.Checkers {
+		if checker.Config.Builtin == "" {
+			runs["checks."+checker.Name] = checker.LastRun()
+		}
+	}
+	for _, g := range app.Agent.MetadataGenerators {
+		if g.Source == nil {
+			runs["metadata."+g.Name] = g.LastRun()
+		}
+	}
+	return runs
+}
+
+func pluginStatus(kind, name string, run *cmdutil.RunResult) PluginStatus {
+	ps := PluginStatus{Kind: kind, Name: name}
+	if run == nil {
+		return ps
+	}
+	ps.LastRunAt = &run.StartedAt
+	ps.DurationSeconds = run.Duration.Seconds()
+	ps.ExitCode = run.ExitCode
+	ps.Stderr = run.Stderr
+	if run.Err != nil {
+		ps.Error = run.Err.Error()
+	}
+	return ps
+}
+
+func (app *App) setPostQueue(postQueue chan *postValue) {
+	app.statusMu.Lock()
+	defer app.statusMu.Unlock()
+	app.postQueue = postQueue
+}
+
+// recordPost records the result of posting the metrics for the status.
+func (app *App) recordPost(err error) {
+	app.statusMu.Lock()
+	defer app.statusMu.Unlock()
+	if err != nil {
+		app.postFailureCount++
+		app.lastPostError = err
+		return
+	}
+	app.lastPostedAt = time.Now()
+	app.postFailureCount = 0
+	app.lastPostError = nil
+}
+
+const adminStatusURL = "http://mackerel-agent/status"
+
+// serveAdmin serves the status on the admin socket until ctx is canceled.
+func serveAdmin(ctx context.Context, app *App) {
+	if app.Config.Admin.Disabled {
+		return
+	}
+	path := app.Config.AdminSocketPath()
+	if err := removeStaleSocket(path); err != nil {
+		logger.Warningf("Failed to remove the admin socket %s: %s", path, err)
+		return
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		logger.Warningf("Failed to listen the admin socket %s: %s", path, err)
+		return
+	}
+	// The status contains the stderr of the plugins.
+	if err := os.Chmod(path, 0600); err != nil {
+		logger.Warningf("Failed to change the mode of the admin socket %s: %s", path, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(app.Status()); err != nil {
+			logger.Warningf("Failed to write the status: %s", err)
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Warningf("Failed to serve the admin socket: %s", err)
+	}
+}
+
+// removeStaleSocket removes the socket file which the agent stopped ungracefully left.
+// It refuses to remove the files other than sockets and the socket another agent is listening on.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("not a socket: %s", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another process is listening on the socket")
+	}
+	return os.Remove(path)
+}
+
+// FetchStatus fetches the status of the running agent from the admin socket.
+func FetchStatus(conf *config.Config) (*Status, error) {
+	path := conf.AdminSocketPath()
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	resp, err := client.Get(adminStatusURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the agent. Is mackerel-agent running? %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from the agent: %s", resp.Status)
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to parse the status: %w", err)
+	}
+	return &status, nil
+}
+
+// WriteStatus writes the status in the human readable format.
+func WriteStatus(w io.Writer, status *Status) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Host ID:\t%s\n", status.HostID)
+	fmt.Fprintf(tw, "Version:\t%s\n", status.Version)
+	fmt.Fprintf(tw, "Started at:\t%s (uptime %s)\n", formatStatusTime(&status.StartedAt), time.Duration(status.UptimeSeconds)*time.Second)
+	fmt.Fprintf(tw, "Last posted at:\t%s\n", formatStatusTime(status.LastPostedAt))
+	fmt.Fprintf(tw, "Post queue length:\t%d\n", status.PostQueueLength)
+	if status.Retry.Retrying {
+		fmt.Fprintf(tw, "Retry:\tretrying (%d failures, last error: %s)\n", status.Retry.FailureCount, status.Retry.LastError)
+	} else {
+		fmt.Fprintf(tw, "Retry:\tnone\n")
+	}
+
+	if len(status.Plugins) > 0 {
+		fmt.Fprintf(tw, "\nPLUGIN\tLAST RUN\tDURATION\tEXIT CODE\tSTDERR\n")
+		for _, p := range status.Plugins {
+			stderr := p.Stderr
+			if p.Error != "" {
+				stderr = p.Error
+			}
+			fmt.Fprintf(tw, "%s.%s\t%s\t%.3fs\t%d\t%s\n", p.Kind, p.Name, formatStatusTime(p.LastRunAt), p.DurationSeconds, p.ExitCode, oneLine(stderr))
+		}
+	}
+	if len(status.Checkers) > 0 {
+		fmt.Fprintf(tw, "\nCHECKER\tSTATUS\tLAST CHECKED\tNEXT CHECK\tMESSAGE\n")
+		for _, c := range status.Checkers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Name, c.Status, formatStatusTime(c.LastCheckedAt), formatStatusTime(c.NextCheckAt), oneLine(c.Message))
+		}
+	}
+	return tw.Flush()
+}
+
+func formatStatusTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// oneLine shortens the multi-line output of the plugins to fit in the table.
+func oneLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i] + " ..."
+	}
+	return s
+}
@@ -0,0 +1,175 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/agent"
+	"github.com/mackerelio/mackerel-agent/checks"
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+func TestServeAdmin(t *testing.T) {
+	root := t.TempDir()
+	confFile := filepath.Join(root, "mackerel-agent.conf")
+	err := os.WriteFile(confFile, []byte(`
+apikey = "abcde"
+
+[plugin.metrics.foo]
+command = "echo 'foo.a\t1\t1700000000'; echo oops >&2"
+
+[plugin.checks.bar]
+command = "echo critical; exit 2"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.LoadConfig(confFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Root = root
+
+	generator := metrics.NewPluginGenerator(conf.MetricPlugins["foo"])
+	if _, err := generator.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	checker := &checks.Checker{Name: "bar", Config: conf.CheckPlugins["bar"]}
+	checker.Check()
+	nextTime := time.Now().Add(time.Minute)
+	checker.SetNextTime(nextTime)
+
+	app := &App{
+		Agent: &agent.Agent{
+			PluginGenerators: []metrics.PluginGenerator{generator},
+			Checkers:         []*checks.Checker{checker},
+		},
+		Config:    conf,
+		Host:      &mkr.Host{ID: "xyzabc12345"},
+		AgentMeta: &AgentMeta{Version: "1.0.0"},
+		startedAt: time.Now().Add(-time.Hour),
+		postQueue: make(chan *postValue, 3),
+	}
+	app.postQueue <- newPostValue(nil)
+	app.recordPost(errors.New("503 Service Unavailable"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveAdmin(ctx, app)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var status *Status
+	for range 50 {
+		if status, err = FetchStatus(conf); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("should fetch the status: %s", err)
+	}
+
+	if status.HostID != "xyzabc12345" || status.Version != "1.0.0" || status.UptimeSeconds < 3600 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if status.LastPostedAt != nil || status.PostQueueLength != 1 {
+		t.Errorf("unexpected posts: lastPostedAt = %v, postQueueLength = %d", status.LastPostedAt, status.PostQueueLength)
+	}
+	if !status.Retry.Retrying || status.Retry.FailureCount != 1 || status.Retry.LastError != "503 Service Unavailable" {
+		t.Errorf("unexpected retry: %+v", status.Retry)
+	}
+
+	if len(status.Plugins) != 2 {
+		t.Fatalf("the status of 2 plugins should be returned but %+v", status.Plugins)
+	}
+	foo, bar := status.Plugins[0], status.Plugins[1]
+	if foo.Kind != "metrics" || foo.Name != "foo" || foo.LastRunAt == nil || foo.ExitCode != 0 || foo.Stderr != "oops\n" {
+		t.Errorf("unexpected plugin status: %+v", foo)
+	}
+	if bar.Kind != "checks" || bar.Name != "bar" || bar.LastRunAt == nil || bar.ExitCode != 2 {
+		t.Errorf("unexpected plugin status: %+v", bar)
+	}
+
+	if len(status.Checkers) != 1 {
+		t.Fatalf("the status of the checker should be returned but %+v", status.Checkers)
+	}
+	c := status.Checkers[0]
+	if c.Name != "bar" || c.Status != "CRITICAL" || c.LastCheckedAt == nil || c.NextCheckAt == nil || !c.NextCheckAt.Equal(nextTime) {
+		t.Errorf("unexpected checker status: %+v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteStatus(&buf, status); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"xyzabc12345", "retrying (1 failures", "metrics.foo", "oops", "CRITICAL"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("the output should contain %q but:\n%s", s, buf.String())
+		}
+	}
+
+	app.recordPost(nil)
+	if status := app.Status(); status.LastPostedAt == nil || status.Retry.Retrying {
+		t.Errorf("the retry should be reset after the successful post: %+v", status)
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := removeStaleSocket(filepath.Join(dir, "none.sock")); err != nil {
+		t.Errorf("the missing socket should be ignored but %s", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(file); err == nil {
+		t.Errorf("the regular file should not be removed")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("the regular file should remain but %s", err)
+	}
+
+	listening := filepath.Join(dir, "listening.sock")
+	l, err := net.Listen("unix", listening)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(listening); err == nil {
+		t.Errorf("the socket being listened on should not be removed")
+	}
+	l.Close()
+
+	stale := filepath.Join(dir, "stale.sock")
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: stale, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ul.SetUnlinkOnClose(false)
+	ul.Close()
+	if err := removeStaleSocket(stale); err != nil {
+		t.Errorf("the stale socket should be removed but %s", err)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Errorf("the stale socket should not exist but %v", err)
+	}
+}
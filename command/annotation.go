@@ -0,0 +1,278 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/mackerel"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+// annotationStateFile keeps the plugins and the host specs annotated last time
+// to detect the changes across the restarts of the agent.
+const annotationStateFile = "annotation_state.json"
+
+type annotationState struct {
+	Plugins   []string          `json:"plugins"`
+	HostSpecs map[string]string `json:"hostSpecs,omitempty"`
+}
+
+// annotationScopes returns the roles to which the annotations are scoped, keyed by the service names.
+// The annotation is scoped to the whole service when the roles are empty.
+func annotationScopes(conf *config.Annotations, host *mkr.Host) map[string][]string {
+	if conf.Service != "" {
+		return map[string][]string{conf.Service: conf.Roles}
+	}
+	scopes := make(map[string][]string)
+	if host == nil {
+		return scopes
+	}
+	for service, roles := range host.Roles {
+		if len(roles) == 0 {
+			continue
+		}
+		scopes[service] = slices.Sorted(slices.Values(roles))
+	}
+	return scopes
+}
+
+// postAnnotation posts the graph annotation at the time to each of the scopes.
+func postAnnotation(api *mackerel.API, scopes map[string][]string, title, description string, from, to time.Time) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("no service to annotate. Specify the service of the annotation or the roles of the host")
+	}
+	for _, service := range slices.Sorted(maps.Keys(scopes)) {
+		_, err := api.CreateGraphAnnotation(&mkr.GraphAnnotation{
+			Title:       title,
+			Description: description,
+			From:        from.Unix(),
+			To:          to.Unix(),
+			Service:     service,
+			Roles:       scopes[service],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to post the annotation to the service %s: %w", service, err)
+		}
+	}
+	return nil
+}
+
+// annotate posts the annotation of the event of the agent if enabled.
+func (app *App) annotate(title, description string) {
+	if !app.Config.Annotations.Enabled {
+		return
+	}
+	now := time.Now()
+	title = fmt.Sprintf("%s (%s)", title, app.Host.Name)
+	description = strings.TrimSpace(fmt.Sprintf("hostID: %s\nversion: %s\n%s", app.Host.ID, app.AgentMeta.Version, description))
+	err := postAnnotation(app.API, annotationScopes(&app.Config.Annotations, app.Host), title, description, now, now)
+	if err != nil {
+		logger.Warningf("Failed to post the annotation %q: %s", title, err)
+	}
+}
+
+// annotateStart posts the annotations of the start of the agent and the changes of the plugins since the last run.
+func (app *App) annotateStart() {
+	if !app.Config.Annotations.Enabled {
+		return
+	}
+	if app.Reloaded {
+		app.annotate("mackerel-agent reloaded the configuration", "")
+	} else {
+		app.annotate("mackerel-agent started", "")
+	}
+
+	app.annotationMu.Lock()
+	defer app.annotationMu.Unlock()
+	state := loadAnnotationState(app.Config)
+	plugins := pluginNames(app.Config)
+	if state.Plugins != nil {
+		added, removed := diffStrings(state.Plugins, plugins)
+		if len(added) > 0 || len(removed) > 0 {
+			var description strings.Builder
+			for _, name := range added {
+				fmt.Fprintf(&description, "added: %s\n", name)
+			}
+			for _, name := range removed {
+				fmt.Fprintf(&description, "removed: %s\n", name)
+			}
+			app.annotate("mackerel-agent plugins changed", description.String())
+		}
+	}
+	state.Plugins = plugins
+	saveAnnotationState(app.Config, state)
+}
+
+// annotateHostSpecs posts the annotation when the host specs changed since they were annotated last time.
+func (app *App) annotateHostSpecs(hostParam *mkr.CreateHostParam) {
+	if !app.Config.Annotations.Enabled {
+		return
+	}
+	app.annotationMu.Lock()
+	defer app.annotationMu.Unlock()
+	state := loadAnnotationState(app.Config)
+	specs := hostSpecsSummary(hostParam)
+	if state.HostSpecs != nil {
+		var description strings.Builder
+		for _, key := range slices.Sorted(maps.Keys(specs)) {
+			if old := state.HostSpecs[key]; old != specs[key] {
+				fmt.Fprintf(&description, "%s: %s -> %s\n", key, old, specs[key])
+			}
+		}
+		if description.Len() == 0 {
+			return
+		}
+		app.annotate("Host specs changed", description.String())
+	}
+	state.HostSpecs = specs
+	saveAnnotationState(app.Config, state)
+}
+
+func loadAnnotationState(conf *config.Config) *annotationState {
+	var state annotationState
+	content, err := os.ReadFile(filepath.Join(conf.Root, annotationStateFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warningf("Failed to load the annotation state: %s", err)
+		}
+		return &state
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		logger.Warningf("Failed to parse the annotation state: %s", err)
+	}
+	return &state
+}
+
+func saveAnnotationState(conf *config.Config, state *annotationState) {
+	content, err := json.Marshal(state)
+	if err != nil {
+		logger.Warningf("Failed to save the annotation state: %s", err)
+		return
+	}
+	if err := os.MkdirAll(conf.Root, 0755); err != nil {
+		logger.Warningf("Failed to save the annotation state: %s", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(conf.Root, annotationStateFile), content, 0644); err != nil {
+		logger.Warningf("Failed to save the annotation state: %s", err)
+	}
+}
+
+// pluginNames returns the sorted names of the plugins like "metrics.foo", "checks.bar" and "metadata.baz".
+func pluginNames(conf *config.Config) []string {
+	names := []string{}
+	for name := range conf.MetricPlugins {
+		names = append(names, "metrics."+name)
+	}
+	for name := range conf.CheckPlugins {
+		names = append(names, "checks."+name)
+	}
+	for name := range conf.MetadataPlugins {
+		names = append(names, "metadata."+name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// diffStrings returns the elements only in new and only in old. Both of them must be sorted.
+func diffStrings(old, new []string) (added, removed []string) {
+	for _, s := range new {
+		if _, found := slices.BinarySearch(old, s); !found {
+			added = append(added, s)
+		}
+	}
+	for _, s := range old {
+		if _, found := slices.BinarySearch(new, s); !found {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// hostSpecsSummary picks up the host specs which rarely change,
+// excluding the volatile ones like the free memory and the usage of the filesystems.
+func hostSpecsSummary(hostParam *mkr.CreateHostParam) map[string]string {
+	specs := map[string]string{
+		"name":        hostParam.Name,
+		"displayName": hostParam.DisplayName,
+		"roles":       strings.Join(slices.Sorted(slices.Values(hostParam.RoleFullnames)), ","),
+	}
+	meta := hostParam.Meta
+	var models []string
+	for _, cpu := range meta.CPU {
+		if model, ok := cpu["model_name"].(string); ok && !slices.Contains(models, model) {
+			models = append(models, model)
+		}
+	}
+	specs["cpu"] = fmt.Sprintf("%d cores %s", len(meta.CPU), strings.Join(models, ","))
+	specs["memory"] = meta.Memory["total"]
+	specs["kernel"] = strings.TrimSpace(meta.Kernel["release"] + " " + meta.Kernel["platform_version"])
+	if meta.Cloud != nil {
+		cloud := meta.Cloud.Provider
+		switch metadata := meta.Cloud.MetaData.(type) {
+		case map[string]string:
+			if t := metadata["instance-type"]; t != "" {
+				cloud += " " + t
+			}
+		case map[string]any:
+			if t, ok := metadata["instance-type"].(string); ok && t != "" {
+				cloud += " " + t
+			}
+		}
+		specs["cloud"] = cloud
+	}
+	var interfaces []string
+	for _, iface := range hostParam.Interfaces {
+		addrs := slices.Concat(iface.IPv4Addresses, iface.IPv6Addresses)
+		if len(addrs) == 0 && iface.IPAddress != "" {
+			addrs = []string{iface.IPAddress}
+		}
+		slices.Sort(addrs)
+		interfaces = append(interfaces, iface.Name+"="+strings.Join(addrs, ","))
+	}
+	slices.Sort(interfaces)
+	specs["interfaces"] = strings.Join(interfaces, " ")
+	return specs
+}
+
+// AnnotateParam is the parameter of the annotation posted by the annotate subcommand.
+type AnnotateParam struct {
+	Title       string
+	Description string
+	From, To    time.Time
+	// Service and Roles are the scope of the annotation (default: the services and the roles of the host)
+	Service string
+	Roles   []string
+}
+
+// Annotate posts the graph annotation to the services of the host.
+func Annotate(conf *config.Config, ameta *AgentMeta, param *AnnotateParam) error {
+	api, err := NewMackerelClient(conf.Apibase, conf.Apikey, ameta.Version, ameta.Revision, conf.Verbose, conf.DisableHTTPKeepAlive)
+	if err != nil {
+		return fmt.Errorf("failed to prepare an api: %s", err.Error())
+	}
+	annotations := conf.Annotations
+	if param.Service != "" {
+		annotations = config.Annotations{Service: param.Service, Roles: param.Roles}
+	}
+	var host *mkr.Host
+	if annotations.Service == "" {
+		SetupHostIDStorage(conf)
+		hostID, err := conf.LoadHostID()
+		if err != nil {
+			return fmt.Errorf("specify the service because the host is not registered: %s", err)
+		}
+		host, err = api.FindHost(hostID)
+		if err != nil {
+			return fmt.Errorf("failed to find the host: %s", err)
+		}
+	}
+	return postAnnotation(api, annotationScopes(&annotations, host), param.Title, param.Description, param.From, param.To)
+}
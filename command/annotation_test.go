@@ -0,0 +1,166 @@
+package command
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent/agent"
+	"github.com/mackerelio/mackerel-agent/config"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+func TestAnnotationScopes(t *testing.T) {
+	host := &mkr.Host{Roles: mkr.Roles{"web": {"db", "app"}, "batch": {}}}
+	tests := []struct {
+		conf   config.Annotations
+		host   *mkr.Host
+		expect map[string][]string
+	}{
+		{config.Annotations{}, host, map[string][]string{"web": {"app", "db"}}},
+		{config.Annotations{Service: "shop"}, host, map[string][]string{"shop": nil}},
+		{config.Annotations{Service: "shop", Roles: []string{"app"}}, nil, map[string][]string{"shop": {"app"}}},
+		{config.Annotations{}, nil, map[string][]string{}},
+	}
+	for _, tt := range tests {
+		scopes := annotationScopes(&tt.conf, tt.host)
+		if !reflect.DeepEqual(scopes, tt.expect) {
+			t.Errorf("annotationScopes(%+v) should be %v but %v", tt.conf, tt.expect, scopes)
+		}
+	}
+}
+
+func TestDiffStrings(t *testing.T) {
+	added, removed := diffStrings([]string{"checks.a", "metrics.b", "metrics.c"}, []string{"metrics.b", "metrics.d"})
+	if !reflect.DeepEqual(added, []string{"metrics.d"}) {
+		t.Errorf("added should be [metrics.d] but %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"checks.a", "metrics.c"}) {
+		t.Errorf("removed should be [checks.a metrics.c] but %v", removed)
+	}
+}
+
+func TestAnnotateEvents(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+
+	var annotations []mkr.GraphAnnotation
+	mockHandlers["POST /api/v0/graph-annotations"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		var annotation mkr.GraphAnnotation
+		if err := json.NewDecoder(req.Body).Decode(&annotation); err != nil {
+			t.Fatal(err)
+		}
+		annotations = append(annotations, annotation)
+		return 200, jsonObject{"id": "annotation-id"}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Annotations.Enabled = true
+	conf.MetricPlugins = map[string]*config.MetricPlugin{"foo": {}}
+	app := &App{
+		Config:    &conf,
+		Host:      &mkr.Host{ID: "xyzabc12345", Name: "host1", Roles: mkr.Roles{"web": {"app"}}},
+		API:       api,
+		AgentMeta: &AgentMeta{Version: "1.0.0"},
+	}
+
+	app.annotateStart()
+	if len(annotations) != 1 {
+		t.Fatalf("only the start should be annotated on the first run but %+v", annotations)
+	}
+	if annotations[0].Title != "mackerel-agent started (host1)" || annotations[0].Service != "web" || !reflect.DeepEqual(annotations[0].Roles, []string{"app"}) {
+		t.Errorf("unexpected annotation: %+v", annotations[0])
+	}
+
+	annotations = nil
+	conf.MetricPlugins = map[string]*config.MetricPlugin{"bar": {}}
+	app.Reloaded = true
+	app.annotateStart()
+	if len(annotations) != 2 {
+		t.Fatalf("the reload and the changes of the plugins should be annotated but %+v", annotations)
+	}
+	if annotations[0].Title != "mackerel-agent reloaded the configuration (host1)" {
+		t.Errorf("unexpected annotation: %+v", annotations[0])
+	}
+	if !strings.Contains(annotations[1].Description, "added: metrics.bar\nremoved: metrics.foo") {
+		t.Errorf("the changes of the plugins should be described but %q", annotations[1].Description)
+	}
+
+	annotations = nil
+	hostParam := &mkr.CreateHostParam{
+		Name: "host1",
+		Meta: mkr.HostMeta{Memory: mkr.Memory{"total": "1024kB", "free": "512kB"}},
+	}
+	app.annotateHostSpecs(hostParam)
+	hostParam.Meta.Memory = mkr.Memory{"total": "1024kB", "free": "256kB"}
+	app.annotateHostSpecs(hostParam)
+	if len(annotations) != 0 {
+		t.Fatalf("the host specs should not be annotated unless changed but %+v", annotations)
+	}
+	hostParam.Meta.Memory = mkr.Memory{"total": "2048kB", "free": "256kB"}
+	app.annotateHostSpecs(hostParam)
+	if len(annotations) != 1 || !strings.Contains(annotations[0].Description, "memory: 1024kB -> 2048kB") {
+		t.Errorf("the change of the memory should be annotated but %+v", annotations)
+	}
+
+	// nothing is posted when disabled
+	annotations = nil
+	conf.Annotations.Enabled = false
+	app.annotateStart()
+	app.annotate("mackerel-agent stopped", "")
+	if len(annotations) != 0 {
+		t.Errorf("nothing should be annotated when disabled but %+v", annotations)
+	}
+}
+
+func TestRun_Reloading(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+
+	var mu sync.Mutex
+	var titles []string
+	mockHandlers["POST /api/v0/graph-annotations"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		var annotation mkr.GraphAnnotation
+		if err := json.NewDecoder(req.Body).Decode(&annotation); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		titles = append(titles, annotation.Title)
+		return 200, jsonObject{"id": "annotation-id"}
+	}
+	mockHandlers["PUT /api/v0/hosts/xyzabc12345"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		return 200, jsonObject{"id": "xyzabc12345"}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Annotations = config.Annotations{Enabled: true, Service: "web"}
+	app := &App{
+		Agent:     &agent.Agent{},
+		Config:    &conf,
+		Host:      &mkr.Host{ID: "xyzabc12345", Name: "host1"},
+		API:       api,
+		AgentMeta: &AgentMeta{Version: "1.0.0"},
+	}
+	app.SetReloading()
+
+	termCh := make(chan struct{}, 1)
+	termCh <- struct{}{}
+	if err := Run(app, termCh); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(titles, []string{"mackerel-agent started (host1)"}) {
+		t.Errorf("the stop should not be annotated on reload but %v", titles)
+	}
+}
@@ -0,0 +1,169 @@
+package command
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/spec"
+)
+
+// cloudTagsHostParam adds the roles and replaces the display name by the tags of the cloud instance.
+// The errors are logged and the given values are returned as they are, not to prevent the agent from starting.
+func cloudTagsHostParam(conf *config.CloudTags, gen spec.CloudMetaGenerator, roles []string, displayName string) ([]string, string) {
+	if len(conf.Roles) == 0 && conf.DisplayName == "" {
+		return roles, displayName
+	}
+	tagsGen, ok := gen.(spec.CloudTagsGenerator)
+	if !ok {
+		logger.Warningf("cloud_tags is not supported on this cloud platform")
+		return roles, displayName
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tags, err := tagsGen.Tags(ctx)
+	if err != nil {
+		logger.Warningf("Failed to get the tags of the instance: %s", err)
+		return roles, displayName
+	}
+	for _, key := range conf.Roles {
+		for role := range strings.SplitSeq(tags[key], ",") {
+			role = strings.TrimSpace(role)
+			if role == "" {
+				continue
+			}
+			if !strings.Contains(role, ":") {
+				logger.Warningf("Ignored the role %q of the tag %q. The role fullname should be \"service:role\"", role, key)
+				continue
+			}
+			if !slices.Contains(roles, role) {
+				// copy not to modify the roles of the configuration
+				roles = append(slices.Clip(roles), role)
+			}
+		}
+	}
+	if conf.DisplayName != "" {
+		if name := tags[conf.DisplayName]; name != "" {
+			displayName = name
+		}
+	}
+	return roles, displayName
+}
+
+// SetupHostIDStorage sets up the storage of the host id of the configured backend.
+func SetupHostIDStorage(conf *config.Config) {
+	setupHostIDStorage(conf, func() *spec.CloudGenerator {
+		return spec.CloudGeneratorSuggester.Suggest(conf)
+	})
+}
+
+// setupHostIDStorage sets up the storage of the host id, which loads the tag of the instance
+// on the cloud platform given by cloudGenerator for "cloud_tag" backend.
+func setupHostIDStorage(conf *config.Config, cloudGenerator func() *spec.CloudGenerator) {
+	if conf.HostIDStorage != nil {
+		return
+	}
+	conf.HostIDStorage = conf.NewHostIDStorage(func(key string) (string, error) {
+		return spec.LoadCloudTag(cloudGenerator(), key)
+	})
+}
+
+// spotInterruptionPollInterval is the interval recommended by AWS to check the interruption notices.
+var spotInterruptionPollInterval = 5 * time.Second
+
+func spotInterruptionLoop(ctx context.Context, app *App) {
+	cGen := app.cloudGenerator.Load()
+	if cGen == nil {
+		logger.Warningf("spot_interruption is enabled but the host is not running on any cloud platform")
+		return
+	}
+	notifier, ok := cGen.CloudMetaGenerator.(spec.SpotInterruptionNotifier)
+	if !ok {
+		logger.Warningf("spot_interruption is not supported on this cloud platform")
+		return
+	}
+	watchSpotInterruption(ctx, app, notifier)
+}
+
+// watchSpotInterruption polls the interruption notice until it is issued or ctx is canceled.
+func watchSpotInterruption(ctx context.Context, app *App, notifier spec.SpotInterruptionNotifier) {
+	rebalanceRecommended := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(spotInterruptionPollInterval):
+			// nop
+		}
+
+		if !rebalanceRecommended {
+			noticeTime, err := notifier.RebalanceRecommendation(ctx)
+			if err != nil {
+				logger.Debugf("Failed to get the rebalance recommendation: %s", err)
+			} else if !noticeTime.IsZero() {
+				logger.Warningf("This spot instance is at an elevated risk of interruption (noticed at %s)", noticeTime)
+				rebalanceRecommended = true
+			}
+		}
+
+		action, err := notifier.SpotInstanceAction(ctx)
+		if err != nil {
+			logger.Debugf("Failed to get the interruption notice: %s", err)
+			continue
+		}
+		if action != nil {
+			handleSpotInterruption(app, action)
+			return
+		}
+	}
+}
+
+func handleSpotInterruption(app *App, action *spec.SpotInstanceAction) {
+	logger.Warningf("This spot instance is going to be interrupted: action = %s, time = %s", action.Action, action.Time)
+	conf := app.Config.SpotInterruption
+	if conf.Status != "" {
+		if err := app.API.UpdateHostStatus(app.Host.ID, conf.Status); err != nil {
+			logger.Errorf("Failed to update host status on the interruption: %s", err)
+		}
+	}
+	// The stopped or hibernated instances are going to be started again with the same host.
+	if conf.Retire && action.Action == "terminate" {
+		if err := retireHost(app); err != nil {
+			logger.Errorf("Failed to retire the host on the interruption: %s", err)
+		}
+	}
+}
+
+// isShuttingDown distinguishes the shutdown of the system and the termination of the instance from the restart of the agent.
+// The cloud platform found on start is used not to detect it again while the system is going down.
+func isShuttingDown(app *App) bool {
+	if isSystemPoweringOff() {
+		logger.Infof("The system is powering off")
+		return true
+	}
+	cGen := app.cloudGenerator.Load()
+	if cGen == nil {
+		return false
+	}
+	notifier, ok := cGen.CloudMetaGenerator.(spec.TerminationNotifier)
+	if !ok {
+		return false
+	}
+	return isInstanceTerminating(notifier)
+}
+
+func isInstanceTerminating(notifier spec.TerminationNotifier) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	terminating, err := notifier.IsTerminating(ctx)
+	if err != nil {
+		logger.Warningf("Failed to check whether the instance is terminating: %s", err)
+		return false
+	}
+	if terminating {
+		logger.Infof("The instance is terminating")
+	}
+	return terminating
+}
@@ -0,0 +1,254 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/agent"
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/spec"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+type fakeCloudGenerator struct {
+	tags map[string]string
+	err  error
+
+	actions     []*spec.SpotInstanceAction
+	terminating bool
+}
+
+func (g *fakeCloudGenerator) Generate() (*mkr.Cloud, error) {
+	return &mkr.Cloud{Provider: "fake"}, nil
+}
+
+func (g *fakeCloudGenerator) SuggestCustomIdentifier() (string, error) {
+	return "", nil
+}
+
+func (g *fakeCloudGenerator) Tags(ctx context.Context) (map[string]string, error) {
+	return g.tags, g.err
+}
+
+func (g *fakeCloudGenerator) SpotInstanceAction(ctx context.Context) (*spec.SpotInstanceAction, error) {
+	if len(g.actions) == 0 {
+		return nil, nil
+	}
+	action := g.actions[0]
+	g.actions = g.actions[1:]
+	return action, nil
+}
+
+func (g *fakeCloudGenerator) RebalanceRecommendation(ctx context.Context) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (g *fakeCloudGenerator) IsTerminating(ctx context.Context) (bool, error) {
+	return g.terminating, g.err
+}
+
+func TestCloudTagsHostParam(t *testing.T) {
+	gen := &fakeCloudGenerator{tags: map[string]string{
+		"Name":           "web-1",
+		"mackerel-roles": "web:app, web:batch,invalid",
+		"extra-roles":    "db:main",
+	}}
+	tests := []struct {
+		name        string
+		conf        config.CloudTags
+		gen         spec.CloudMetaGenerator
+		roles       []string
+		displayName string
+	}{
+		{
+			name:        "not configured",
+			conf:        config.CloudTags{},
+			gen:         gen,
+			roles:       []string{"web:app"},
+			displayName: "from-config",
+		},
+		{
+			name:        "roles and display name",
+			conf:        config.CloudTags{Roles: []string{"mackerel-roles", "extra-roles", "missing"}, DisplayName: "Name"},
+			gen:         gen,
+			roles:       []string{"web:app", "web:batch", "db:main"},
+			displayName: "web-1",
+		},
+		{
+			name:        "missing display name tag",
+			conf:        config.CloudTags{DisplayName: "missing"},
+			gen:         gen,
+			roles:       []string{"web:app"},
+			displayName: "from-config",
+		},
+		{
+			name:        "tags are not available",
+			conf:        config.CloudTags{Roles: []string{"mackerel-roles"}, DisplayName: "Name"},
+			gen:         &fakeCloudGenerator{err: errors.New("not allowed")},
+			roles:       []string{"web:app"},
+			displayName: "from-config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confRoles := []string{"web:app"}
+			roles, displayName := cloudTagsHostParam(&tt.conf, tt.gen, confRoles, "from-config")
+			if !reflect.DeepEqual(roles, tt.roles) {
+				t.Errorf("roles should be %v but %v", tt.roles, roles)
+			}
+			if displayName != tt.displayName {
+				t.Errorf("displayName should be %q but %q", tt.displayName, displayName)
+			}
+			if !reflect.DeepEqual(confRoles, []string{"web:app"}) {
+				t.Errorf("the roles of the configuration should not be modified: %v", confRoles)
+			}
+		})
+	}
+}
+
+func TestWatchSpotInterruption(t *testing.T) {
+	origInterval := spotInterruptionPollInterval
+	spotInterruptionPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { spotInterruptionPollInterval = origInterval })
+
+	tests := []struct {
+		action  string
+		retired bool
+	}{
+		{action: "terminate", retired: true},
+		{action: "stop", retired: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+			defer deferFunc()
+			conf.SpotInterruption = config.SpotInterruption{Enabled: true, Status: "maintenance", Retire: true}
+			if err := conf.SaveHostID("xyzabc12345"); err != nil {
+				t.Fatal(err)
+			}
+
+			var status string
+			retired := false
+			mockHandlers["POST /api/v0/hosts/xyzabc12345/status"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+				var body struct{ Status string }
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					t.Error(err)
+				}
+				status = body.Status
+				return 200, jsonObject{"success": true}
+			}
+			mockHandlers["POST /api/v0/hosts/xyzabc12345/retire"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+				retired = true
+				return 200, jsonObject{"success": true}
+			}
+
+			api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &App{Config: &conf, Host: &mkr.Host{ID: "xyzabc12345"}, API: api}
+			gen := &fakeCloudGenerator{actions: []*spec.SpotInstanceAction{
+				nil,
+				{Action: tt.action, Time: time.Now().Add(2 * time.Minute)},
+			}}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			watchSpotInterruption(ctx, app, gen)
+			if ctx.Err() != nil {
+				t.Fatal("watchSpotInterruption should return on the notice")
+			}
+
+			if status != "maintenance" {
+				t.Errorf("host status should be maintenance but %q", status)
+			}
+			if retired != tt.retired {
+				t.Errorf("retired should be %t but %t", tt.retired, retired)
+			}
+			if app.retired.Load() != tt.retired {
+				t.Errorf("the app should stop posting only when retired")
+			}
+			if _, err := conf.LoadHostID(); (err != nil) != tt.retired {
+				t.Errorf("the host id should be removed only when retired: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsInstanceTerminating(t *testing.T) {
+	if !isInstanceTerminating(&fakeCloudGenerator{terminating: true}) {
+		t.Errorf("the instance should be terminating")
+	}
+	if isInstanceTerminating(&fakeCloudGenerator{terminating: false}) {
+		t.Errorf("the instance should not be terminating")
+	}
+	if isInstanceTerminating(&fakeCloudGenerator{terminating: true, err: errors.New("timeout")}) {
+		t.Errorf("the instance should not be terminating on error")
+	}
+}
+
+func TestRetireHost(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+	if err := conf.SaveHostID("xyzabc12345"); err != nil {
+		t.Fatal(err)
+	}
+
+	retired := false
+	mockHandlers["POST /api/v0/hosts/xyzabc12345/retire"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		retired = true
+		return 200, jsonObject{"success": true}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{Config: &conf, Host: &mkr.Host{ID: "xyzabc12345"}, API: api}
+	if err := retireHost(app); err != nil {
+		t.Fatal(err)
+	}
+	if !retired {
+		t.Errorf("the host should be retired")
+	}
+	if _, err := conf.LoadHostID(); err == nil {
+		t.Errorf("the host id should be removed")
+	}
+
+	mockHandlers["PUT /api/v0/hosts/xyzabc12345"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		t.Errorf("the specs of the retired host should not be updated")
+		return 200, jsonObject{"id": "xyzabc12345"}
+	}
+	app.UpdateHostSpecs()
+}
+
+func TestRun_Retired(t *testing.T) {
+	conf, _, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+	conf.HostStatus = config.HostStatus{OnStop: "poweroff", RetireOnShutdown: true}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{
+		Agent:     &agent.Agent{},
+		Config:    &conf,
+		Host:      &mkr.Host{ID: "xyzabc12345"},
+		API:       api,
+		AgentMeta: &AgentMeta{},
+	}
+	app.retired.Store(true)
+
+	// The mock server fails on any request to the retired host.
+	termCh := make(chan struct{}, 1)
+	termCh <- struct{}{}
+	if err := Run(app, termCh); err != nil {
+		t.Errorf("Run should return nil but %s", err)
+	}
+}
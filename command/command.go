@@ -8,6 +8,8 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Songmu/retry"
@@ -47,7 +49,7 @@ type AgentMeta struct {
 
 // prepareHost collects specs of the host and sends them to Mackerel server.
 // A unique host-id is returned by the server if one is not specified.
-func prepareHost(conf *config.Config, ameta *AgentMeta, api *mackerel.API) (*mkr.Host, error) {
+func prepareHost(conf *config.Config, ameta *AgentMeta, api *mackerel.API, cGen *spec.CloudGenerator) (*mkr.Host, error) {
 	doRetry := func(f func() error) {
 		retry.Retry(retryNum, retryInterval, f) // nolint
 	}
@@ -70,7 +72,7 @@ func prepareHost(conf *config.Config, ameta *AgentMeta, api *mackerel.API) (*mkr
 		return err
 	}
 
-	hostParam, lastErr := collectHostParam(conf, ameta)
+	hostParam, lastErr := collectHostParam(conf, ameta, cGen)
 	if lastErr != nil {
 		return nil, fmt.Errorf("error while collecting host specs: %s", lastErr.Error())
 	}
@@ -154,7 +156,7 @@ func prepareHost(conf *config.Config, ameta *AgentMeta, api *mackerel.API) (*mkr
 func prepareCustomIdentiferHosts(conf *config.Config, api *mackerel.API) map[string]*mkr.Host {
 	customIdentifierHosts := make(map[string]*mkr.Host)
 	for _, customIdentifier := range conf.ListCustomIdentifiers() {
-		host, err := api.FindHostByCustomIdentifier(customIdentifier)
+		host, err := findOrCreateCustomIdentifierHost(conf, api, customIdentifier)
 		if err != nil {
 			logger.Warningf("Failed to retrieve the host of custom_identifier: %s, %s", customIdentifier, err)
 			continue
@@ -164,9 +166,95 @@ func prepareCustomIdentiferHosts(conf *config.Config, api *mackerel.API) map[str
 	return customIdentifierHosts
 }
 
+// findOrCreateCustomIdentifierHost finds the host of the custom identifier,
+// and creates it when not found if configured so by [custom_identifier_host."<custom identifier>"].
+func findOrCreateCustomIdentifierHost(conf *config.Config, api *mackerel.API, customIdentifier string) (*mkr.Host, error) {
+	host, err := api.FindHostByCustomIdentifier(customIdentifier)
+	if err == nil {
+		return host, nil
+	}
+	hostConf, ok := conf.CustomIdentifierHosts[customIdentifier]
+	// create the host only when it is surely not found
+	if _, notFound := err.(*mackerel.InfoError); !notFound || !ok || !hostConf.Create {
+		return nil, err
+	}
+	name := hostConf.Name
+	if name == "" {
+		name = customIdentifier
+	}
+	hostID, err := api.CreateHost(&mkr.CreateHostParam{
+		Name:             name,
+		RoleFullnames:    hostConf.Roles,
+		DisplayName:      hostConf.DisplayName,
+		CustomIdentifier: customIdentifier,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the host: %w", err)
+	}
+	logger.Infof("Created the host of custom_identifier: %s (hostID: %s)", customIdentifier, hostID)
+	return api.FindHost(hostID)
+}
+
+// customIdentifierHostsLoop retries finding the hosts of the custom identifiers not found yet.
+func customIdentifierHostsLoop(ctx context.Context, app *App) {
+	for {
+		var missing []string
+		for _, customIdentifier := range app.Config.ListCustomIdentifiers() {
+			if _, ok := app.customIdentifierHost(customIdentifier); !ok {
+				missing = append(missing, customIdentifier)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(customIdentifierLookupInterval):
+			// nop
+		}
+
+		for _, customIdentifier := range missing {
+			host, err := findOrCreateCustomIdentifierHost(app.Config, app.API, customIdentifier)
+			if err != nil {
+				logger.Debugf("Failed to retrieve the host of custom_identifier: %s, %s", customIdentifier, err)
+				continue
+			}
+			logger.Infof("Found the host of custom_identifier: %s (hostID: %s)", customIdentifier, host.ID)
+			app.setCustomIdentifierHost(customIdentifier, host)
+		}
+	}
+}
+
+func (app *App) customIdentifierHost(customIdentifier string) (*mkr.Host, bool) {
+	app.customIdentifierHostsMu.RLock()
+	defer app.customIdentifierHostsMu.RUnlock()
+	host, ok := app.CustomIdentifierHosts[customIdentifier]
+	return host, ok
+}
+
+func (app *App) setCustomIdentifierHost(customIdentifier string, host *mkr.Host) {
+	app.customIdentifierHostsMu.Lock()
+	defer app.customIdentifierHostsMu.Unlock()
+	if app.CustomIdentifierHosts == nil {
+		app.CustomIdentifierHosts = make(map[string]*mkr.Host)
+	}
+	app.CustomIdentifierHosts[customIdentifier] = host
+}
+
 // Interval between each updating host specs.
 var specsUpdateInterval = 1 * time.Hour
 
+// Interval between each checking the signal of the changes of host specs.
+var specsSignalInterval = 1 * time.Minute
+
+// Interval to update host specs even when they are unchanged, to refresh the volatile values like the usage of the filesystems.
+var specsForceUpdateInterval = 24 * time.Hour
+
+// Interval between each retrying to find the hosts of custom identifiers.
+var customIdentifierLookupInterval = 10 * time.Minute
+
 func delayByHost(host *mkr.Host) int {
 	s := sha1.Sum([]byte(host.ID))
 	return int(s[len(s)-1]) % int(config.PostMetricsInterval.Seconds())
@@ -180,15 +268,41 @@ type App struct {
 	API                   *mackerel.API
 	CustomIdentifierHosts map[string]*mkr.Host
 	AgentMeta             *AgentMeta
+	// Reloaded is true when the agent is started again to reload the configuration
+	Reloaded bool
+
+	// cloudGenerator is the generator of the cloud platform found on start or on updating host specs, or nil
+	cloudGenerator atomic.Pointer[spec.CloudGenerator]
+
+	customIdentifierHostsMu sync.RWMutex
+	annotationMu            sync.Mutex
+
+	hostSpecsMu        sync.Mutex
+	hostSpecsDigest    string
+	hostSpecsUpdatedAt time.Time
+
+	// retired is set when the host is retired by the agent not to post anything to the host any more
+	retired atomic.Bool
+	// reloading is set when the agent is stopped to reload the configuration
+	reloading atomic.Bool
+
+	// for the status served on the admin socket
+	statusMu         sync.Mutex
+	startedAt        time.Time
+	postQueue        chan *postValue
+	lastPostedAt     time.Time
+	postFailureCount int
+	lastPostError    error
 }
 
 type postValue struct {
-	values   []*mkr.HostMetricValue
-	retryCnt int
+	values        []*mkr.HostMetricValue
+	serviceValues map[string][]*mkr.MetricValue // keyed by the service names
+	retryCnt      int
 }
 
 func newPostValue(values []*mkr.HostMetricValue) *postValue {
-	return &postValue{values, 0}
+	return &postValue{values: values}
 }
 
 type loopState uint8
@@ -209,7 +323,18 @@ func loop(app *App, termCh chan struct{}) error {
 	go updateHostSpecsLoop(ctx, app)
 
 	postQueue := make(chan *postValue, postMetricsBufferSize)
-	go enqueueLoop(ctx, app, postQueue)
+	refreshGraphDefsCh := make(chan struct{}, 1)
+	go enqueueLoop(ctx, app, postQueue, refreshGraphDefsCh)
+	app.setPostQueue(postQueue)
+
+	go serveAdmin(ctx, app)
+
+	if app.Config.SpotInterruption.Enabled {
+		go spotInterruptionLoop(ctx, app)
+	}
+
+	// Periodically retry finding the hosts of custom identifiers.
+	go customIdentifierHostsLoop(ctx, app)
 
 	postDelaySeconds := delayByHost(app.Host)
 	initialDelay := postDelaySeconds / 2
@@ -221,6 +346,9 @@ func loop(app *App, termCh chan struct{}) error {
 		app.Agent.InitPluginGenerators(app.API)
 	}
 
+	// Periodically refresh the graph definitions of the plugins.
+	go refreshGraphDefsLoop(ctx, app, refreshGraphDefsCh)
+
 	termMetricsCh := make(chan struct{})
 	var termCheckerCh chan struct{}
 	var termMetadataCh chan struct{}
@@ -268,6 +396,13 @@ func loop(app *App, termCh chan struct{}) error {
 				return nil
 			}
 		case v := <-postQueue:
+			if app.retired.Load() {
+				// discard the values of the retired host
+				if lState == loopStateTerminating && len(postQueue) <= 0 {
+					return nil
+				}
+				continue
+			}
 			origPostValues := [](*postValue){v}
 			if len(postQueue) > 0 {
 				// Bulk posting. However at most "two" metrics are to be posted, so postQueue isn't always empty yet.
@@ -320,21 +455,56 @@ func loop(app *App, termCh chan struct{}) error {
 			}
 
 			var postValues []*mkr.HostMetricValue
+			var serviceValues map[string][]*mkr.MetricValue
 			for _, v := range origPostValues {
 				postValues = append(postValues, v.values...)
+				for service, values := range v.serviceValues {
+					if serviceValues == nil {
+						serviceValues = make(map[string][]*mkr.MetricValue)
+					}
+					serviceValues[service] = append(serviceValues[service], values...)
+				}
+			}
+			// The host metrics and the metrics of each service are retried separately
+			// not to post the succeeded ones again nor to be blocked by the failed ones.
+			var err error
+			var retryValues []*postValue
+			if len(postValues) > 0 {
+				err = postHostMetricValuesWithRetry(app, postValues)
+				if err != nil {
+					for _, v := range origPostValues {
+						if len(v.values) > 0 {
+							retryValues = append(retryValues, &postValue{values: v.values, retryCnt: v.retryCnt})
+						}
+					}
+				}
 			}
-			err := postHostMetricValuesWithRetry(app, postValues)
+			for service, values := range serviceValues {
+				e := postServiceMetricValuesWithRetry(app, service, values)
+				if e == nil {
+					continue
+				}
+				if err == nil {
+					err = e
+				}
+				for _, v := range origPostValues {
+					if failed, ok := v.serviceValues[service]; ok {
+						retryValues = append(retryValues, &postValue{serviceValues: map[string][]*mkr.MetricValue{service: failed}, retryCnt: v.retryCnt})
+					}
+				}
+			}
+			app.recordPost(err)
 			if err != nil {
 				if lState != loopStateTerminating {
 					lState = loopStateHadError
 				}
 				go func() {
-					for _, v := range origPostValues {
+					for _, v := range retryValues {
 						v.retryCnt++
 						// It is difficult to distinguish the error is server error or data error.
 						// So, if retryCnt exceeded the configured limit, postValue is considered invalid and abandoned.
 						if v.retryCnt > postMetricsRetryMax {
-							json, err := json.Marshal(v.values)
+							json, err := json.Marshal(map[string]any{"host": v.values, "service": v.serviceValues})
 							if err != nil {
 								logger.Errorf("Something wrong with post values. marshaling failed.")
 							} else {
@@ -356,9 +526,21 @@ func loop(app *App, termCh chan struct{}) error {
 }
 
 func postHostMetricValuesWithRetry(app *App, postValues []*mkr.HostMetricValue) error {
+	return postMetricValuesWithRetry(func() error {
+		return app.API.PostHostMetricValues(postValues)
+	})
+}
+
+func postServiceMetricValuesWithRetry(app *App, service string, values []*mkr.MetricValue) error {
+	return postMetricValuesWithRetry(func() error {
+		return app.API.PostServiceMetricValues(service, values)
+	})
+}
+
+func postMetricValuesWithRetry(post func() error) error {
 	deadline := time.Now().Add(25 * time.Second)
 
-	err := app.API.PostHostMetricValues(postValues)
+	err := post()
 	if err == nil {
 		logger.Debugf("Posting metrics succeeded.")
 		return err
@@ -367,7 +549,7 @@ func postHostMetricValuesWithRetry(app *App, postValues []*mkr.HostMetricValue)
 	// If first request did not take so long and it failed on network error, retry once immedeately
 	if time.Now().Before(deadline) && mackerel.IsNetworkError(err) {
 		logger.Warningf("Failed to post metrics value (will retry immediately): %s", err.Error())
-		err = app.API.PostHostMetricValues(postValues)
+		err = post()
 		if err == nil {
 			logger.Debugf("Posting metrics recovered.")
 			return nil
@@ -377,31 +559,117 @@ func postHostMetricValuesWithRetry(app *App, postValues []*mkr.HostMetricValue)
 	return err
 }
 
+func serviceMetricValues(service string, values metrics.Values, created int64) []*mkr.MetricValue {
+	var metricValues []*mkr.MetricValue
+	for name, attribute := range values {
+		if math.IsNaN(attribute.Value) || math.IsInf(attribute.Value, 0) {
+			logger.Warningf("Invalid value: service = %s, name = %s, value = %f\n is not sent.", service, name, attribute.Value)
+			continue
+		}
+		ts := created
+		if attribute.Time != nil {
+			ts = *attribute.Time
+		}
+		metricValues = append(metricValues, &mkr.MetricValue{
+			Name:  name,
+			Time:  ts,
+			Value: attribute.Value,
+		})
+	}
+	return metricValues
+}
+
+// updateHostSpecsLoop collects host specs periodically and when the cheap signal of the changes is detected.
 func updateHostSpecsLoop(ctx context.Context, app *App) {
+	signal := hostSpecsSignal()
+	lastCollected := time.Now()
+	app.UpdateHostSpecs()
 	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(specsSignalInterval):
+			// nop
+		}
+		s := hostSpecsSignal()
+		if s == signal && time.Since(lastCollected) < specsUpdateInterval {
+			continue
+		}
+		if s != signal {
+			logger.Debugf("Detected changes of host specs.")
+		}
+		signal = s
+		lastCollected = time.Now()
 		app.UpdateHostSpecs()
+	}
+}
+
+var (
+	graphDefsRefreshInterval    = 1 * time.Hour
+	graphDefsRefreshMinInterval = 5 * time.Minute // Refresh on the new metrics at most once in this interval
+)
+
+// refreshGraphDefsLoop posts the changes of the graph definitions of the plugins
+// periodically and when the new metrics of the plugins are found.
+func refreshGraphDefsLoop(ctx context.Context, app *App, refreshCh <-chan struct{}) {
+	lastRefreshed := time.Now()
+	pending := false
+	for {
+		wait := time.Until(lastRefreshed.Add(graphDefsRefreshInterval))
+		if pending {
+			// Postpone the refresh not to run the plugins too often.
+			wait = time.Until(lastRefreshed.Add(graphDefsRefreshMinInterval))
+		}
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(specsUpdateInterval):
+		case <-time.After(wait):
 			// nop
+		case <-refreshCh:
+			logger.Debugf("New metrics of the plugins are found.")
+			if time.Since(lastRefreshed) < graphDefsRefreshMinInterval {
+				pending = true
+				continue
+			}
+		}
+		pending = false
+		if err := app.Agent.RefreshGraphDefs(app.API); err != nil {
+			logger.Errorf("Failed to refresh graphdefs: %s", err)
 		}
+		lastRefreshed = time.Now()
 	}
 }
 
-func enqueueLoop(ctx context.Context, app *App, postQueue chan *postValue) {
+func enqueueLoop(ctx context.Context, app *App, postQueue chan *postValue, refreshGraphDefsCh chan<- struct{}) {
 	metricsResult := app.Agent.Watch(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case result := <-metricsResult:
+			if app.retired.Load() {
+				continue
+			}
+			if app.Agent.HasNewPluginMetrics(result) {
+				select {
+				case refreshGraphDefsCh <- struct{}{}:
+				default:
+				}
+			}
 			created := result.Created.Unix()
 			var creatingValues []*mkr.HostMetricValue
+			var creatingServiceValues map[string][]*mkr.MetricValue
 			for _, values := range result.Values {
+				if values.Service != nil {
+					if creatingServiceValues == nil {
+						creatingServiceValues = make(map[string][]*mkr.MetricValue)
+					}
+					creatingServiceValues[*values.Service] = append(creatingServiceValues[*values.Service], serviceMetricValues(*values.Service, values.Values, created)...)
+					continue
+				}
 				hostID := app.Host.ID
 				if values.CustomIdentifier != nil {
-					if host, ok := app.CustomIdentifierHosts[*values.CustomIdentifier]; ok {
+					if host, ok := app.customIdentifierHost(*values.CustomIdentifier); ok {
 						hostID = host.ID
 					} else {
 						continue
@@ -432,7 +700,9 @@ func enqueueLoop(ctx context.Context, app *App, postQueue chan *postValue) {
 				}
 			}
 			logger.Debugf("Enqueuing task to post metrics.")
-			postQueue <- newPostValue(creatingValues)
+			v := newPostValue(creatingValues)
+			v.serviceValues = creatingServiceValues
+			postQueue <- v
 		}
 	}
 }
@@ -455,6 +725,7 @@ func runChecker(ctx context.Context, checker *checks.Checker, checkReportCh chan
 			now := time.Now()
 			nextInterval = interval - (now.Sub(nextTime) % interval)
 			nextTime = now.Add(nextInterval)
+			checker.SetNextTime(nextTime)
 
 			if checker.Config.Action != nil {
 				env := []string{fmt.Sprintf("MACKEREL_STATUS=%s", report.Status), fmt.Sprintf("MACKEREL_PREVIOUS_STATUS=%s", lastStatus), fmt.Sprintf("MACKEREL_CHECK_MESSAGE=%s", report.Message)}
@@ -532,7 +803,7 @@ func runCheckersLoop(ctx context.Context, app *App, termCheckerCh <-chan struct{
 			}
 		}
 
-		if len(reports) == 0 {
+		if len(reports) == 0 || app.retired.Load() {
 			continue
 		}
 
@@ -575,7 +846,7 @@ func runCheckersLoop(ctx context.Context, app *App, termCheckerCh <-chan struct{
 func reportCheckMonitors(app *App, customIdentifier string, reports []*checks.Report) {
 	hostID := app.Host.ID
 	if customIdentifier != "" {
-		if host, ok := app.CustomIdentifierHosts[customIdentifier]; ok {
+		if host, ok := app.customIdentifierHost(customIdentifier); ok {
 			hostID = host.ID
 		} else {
 			return
@@ -621,14 +892,14 @@ func reportCheckMonitorsInternal(app *App, hostID string, reports []*checks.Repo
 }
 
 // collectHostParam collects host specs (correspond to "name", "meta", "interfaces" and "customIdentifier" fields in API v0)
-func collectHostParam(conf *config.Config, ameta *AgentMeta) (*mkr.CreateHostParam, error) {
+// cGen is the generator of the cloud platform on which the host is running, or nil.
+func collectHostParam(conf *config.Config, ameta *AgentMeta, cGen *spec.CloudGenerator) (*mkr.CreateHostParam, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, fmt.Errorf("failed to obtain hostname: %s", err.Error())
 	}
 
 	specGens := specGenerators()
-	cGen := spec.CloudGeneratorSuggester.Suggest(conf)
 	if cGen != nil {
 		specGens = append(specGens, cGen)
 	}
@@ -642,6 +913,15 @@ func collectHostParam(conf *config.Config, ameta *AgentMeta) (*mkr.CreateHostPar
 		}
 	}
 
+	roles := conf.Roles
+	displayName := conf.DisplayName
+	if cGen != nil {
+		roles, displayName = cloudTagsHostParam(&conf.CloudTags, cGen.CloudMetaGenerator, roles, displayName)
+	}
+	if conf.Kubernetes.Enabled {
+		roles, customIdentifier = kubernetesHostParam(&conf.Kubernetes, roles, customIdentifier)
+	}
+
 	interfaces, err := interfaceGenerator().Generate()
 	if err != nil {
 		return nil, fmt.Errorf("failed to collect interfaces: %s", err.Error())
@@ -668,28 +948,54 @@ func collectHostParam(conf *config.Config, ameta *AgentMeta) (*mkr.CreateHostPar
 		Name:             hostname,
 		Meta:             meta,
 		Interfaces:       interfaces,
-		RoleFullnames:    conf.Roles,
+		RoleFullnames:    roles,
 		Checks:           checks,
-		DisplayName:      conf.DisplayName,
+		DisplayName:      displayName,
 		CustomIdentifier: customIdentifier,
 	}, nil
 }
 
 // UpdateHostSpecs updates the host information that is already registered on Mackerel.
 func (app *App) UpdateHostSpecs() {
+	if app.retired.Load() {
+		return
+	}
 	logger.Debugf("Updating host specs...")
 
-	hostParam, err := collectHostParam(app.Config, app.AgentMeta)
+	cGen := app.cloudGenerator.Load()
+	if cGen == nil {
+		// The cloud platform is looked up again in case its metadata service was unreachable on start.
+		cGen = spec.CloudGeneratorSuggester.Suggest(app.Config)
+		if cGen != nil {
+			app.cloudGenerator.CompareAndSwap(nil, cGen)
+		}
+	}
+	hostParam, err := collectHostParam(app.Config, app.AgentMeta, cGen)
 	if err != nil {
 		logger.Errorf("While collecting host specs: %s", err)
 		return
 	}
 
+	digest, err := hostSpecsDigest(hostParam)
+	if err != nil {
+		logger.Warningf("Failed to calculate the digest of host specs: %s", err)
+	}
+
+	app.hostSpecsMu.Lock()
+	defer app.hostSpecsMu.Unlock()
+	if digest != "" && digest == app.hostSpecsDigest && time.Since(app.hostSpecsUpdatedAt) < specsForceUpdateInterval {
+		logger.Debugf("Host specs are unchanged.")
+		return
+	}
+
 	_, err = app.API.UpdateHost(app.Host.ID, (*mkr.UpdateHostParam)(hostParam))
 	if err != nil {
 		logger.Errorf("Error while updating host specs: %s", err)
 	} else {
 		logger.Debugf("Host specs sent.")
+		app.hostSpecsDigest = digest
+		app.hostSpecsUpdatedAt = time.Now()
+		app.annotateHostSpecs(hostParam)
 	}
 }
 
@@ -718,42 +1024,42 @@ func Prepare(conf *config.Config, ameta *AgentMeta) (*App, error) {
 		return nil, fmt.Errorf("failed to prepare an api: %s", err.Error())
 	}
 
-	host, err := prepareHost(conf, ameta, api)
+	cGen := spec.CloudGeneratorSuggester.Suggest(conf)
+	setupHostIDStorage(conf, func() *spec.CloudGenerator { return cGen })
+	host, err := prepareHost(conf, ameta, api, cGen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare host: %s", err.Error())
 	}
 
-	return &App{
+	app := &App{
 		Agent:                 NewAgent(conf),
 		Config:                conf,
 		Host:                  host,
 		API:                   api,
 		CustomIdentifierHosts: prepareCustomIdentiferHosts(conf, api),
 		AgentMeta:             ameta,
-	}, nil
+	}
+	app.cloudGenerator.Store(cGen)
+	return app, nil
 }
 
-// RunOnce collects specs and metrics, then output them to stdout.
-func RunOnce(conf *config.Config, ameta *AgentMeta) error {
-	graphdefs, hostSpec, metrics, err := runOncePayload(conf, ameta)
-	if err != nil {
-		return err
+// RunOnce collects specs, metrics and graph definitions, and optionally the results of
+// the checks and the metadata plugins, then output them to stdout.
+func RunOnce(conf *config.Config, ameta *AgentMeta, opt *OnceOption) error {
+	switch opt.Format {
+	case "", "json", "table":
+	default:
+		return fmt.Errorf("unknown format: %q (json or table)", opt.Format)
 	}
-
-	json, err := json.Marshal(map[string]any{
-		"host":    hostSpec,
-		"metrics": metrics,
-	})
+	payload, err := collectOncePayload(conf, ameta, opt)
 	if err != nil {
-		logger.Warningf("Error while marshaling graphdefs: err = %s, graphdefs = %v.", err.Error(), graphdefs)
 		return err
 	}
-	fmt.Println(string(json))
-	return nil
+	return writeOncePayload(os.Stdout, payload, opt.Format)
 }
 
 func runOncePayload(conf *config.Config, ameta *AgentMeta) ([]*mkr.GraphDefsParam, *mkr.CreateHostParam, *agent.MetricsResult, error) {
-	hostParam, err := collectHostParam(conf, ameta)
+	hostParam, err := collectHostParam(conf, ameta, spec.CloudGeneratorSuggester.Suggest(conf))
 	if err != nil {
 		logger.Errorf("While collecting host specs: %s", err)
 		return nil, nil, nil, err
@@ -766,6 +1072,9 @@ func runOncePayload(conf *config.Config, ameta *AgentMeta) ([]*mkr.GraphDefsPara
 	}()
 	ag := NewAgent(conf)
 	graphdefs := ag.CollectGraphDefsOfPlugins()
+	for _, payloads := range ag.CollectServiceGraphDefsOfPlugins() {
+		graphdefs = append(graphdefs, payloads...)
+	}
 	metrics := ag.CollectMetrics(time.Now())
 	return graphdefs, hostParam, metrics, nil
 }
@@ -783,8 +1092,26 @@ func NewAgent(conf *config.Config) *agent.Agent {
 // Run starts the main metric collecting logic and this function will never return.
 func Run(app *App, termCh chan struct{}) error {
 	logger.Infof("Start: apibase = %s, hostName = %s, hostID = %s", app.Config.Apibase, app.Host.Name, app.Host.ID)
+	app.statusMu.Lock()
+	app.startedAt = time.Now()
+	app.statusMu.Unlock()
+	app.annotateStart()
 
 	err := loop(app, termCh)
+	if err == nil && !app.reloading.Load() {
+		// The reload is annotated by the agent started again.
+		app.annotate("mackerel-agent stopped", "")
+	}
+	if err == nil && app.retired.Load() {
+		// already retired on the spot interruption
+		return nil
+	}
+	if err == nil && app.Config.HostStatus.RetireOnShutdown && isShuttingDown(app) {
+		if e := retireHost(app); e != nil {
+			logger.Errorf("Failed to retire the host on shutdown: %s", e)
+		}
+		return nil
+	}
 	if err == nil && app.Config.HostStatus.OnStop != "" {
 		// TODO error handling. support retire(?)
 		e := app.API.UpdateHostStatus(app.Host.ID, app.Config.HostStatus.OnStop)
@@ -795,13 +1122,35 @@ func Run(app *App, termCh chan struct{}) error {
 	return err
 }
 
+// SetReloading tells the agent is going to be stopped to reload the configuration.
+func (app *App) SetReloading() {
+	app.reloading.Store(true)
+}
+
+// retireHost retires the host and removes the saved host id not to be used again.
+func retireHost(app *App) error {
+	err := retry.Retry(3, time.Second, func() error {
+		return app.API.RetireHost(app.Host.ID)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Infof("This host (hostID: %s) has been retired.", app.Host.ID)
+	app.retired.Store(true)
+	if err := app.Config.DeleteSavedHostID(); err != nil {
+		logger.Warningf("Failed to remove HostID file: %s", err)
+	}
+	return nil
+}
+
 func createCheckers(conf *config.Config) []*checks.Checker {
 	checkers := []*checks.Checker{}
 
 	for name, pluginConfig := range conf.CheckPlugins {
 		checker := &checks.Checker{
-			Name:   name,
-			Config: pluginConfig,
+			Name:          name,
+			Config:        pluginConfig,
+			ProcessGroups: conf.ProcessGroups,
 		}
 		logger.Debugf("Checker created: %v", checker)
 		checkers = append(checkers, checker)
@@ -26,9 +26,19 @@ func metricsGenerators(conf *config.Config) []metrics.Generator {
 		&metrics.LoadavgGenerator{},
 		&metricsDarwin.CPUUsageGenerator{},
 		&metricsDarwin.MemoryGenerator{},
-		&metrics.FilesystemGenerator{IgnoreRegexp: conf.Filesystems.Ignore.Regexp, UseMountpoint: conf.Filesystems.UseMountpoint},
+		&metrics.FilesystemGenerator{IgnoreRegexp: conf.Filesystems.Ignore.Regexp, UseMountpoint: conf.Filesystems.UseMountpoint, Inodes: conf.Filesystems.Inodes},
 		&metrics.InterfaceGenerator{IgnoreRegexp: conf.Interfaces.Ignore.Regexp, Interval: metricsInterval},
 	}
 
 	return generators
 }
+
+// isSystemPoweringOff is supported only on the systems running systemd.
+func isSystemPoweringOff() bool {
+	return false
+}
+
+// platformHostSpecsSignal has nothing cheaper than collecting the host specs on this platform.
+func platformHostSpecsSignal() string {
+	return ""
+}
@@ -25,10 +25,20 @@ func metricsGenerators(conf *config.Config) []metrics.Generator {
 	generators := []metrics.Generator{
 		&metrics.LoadavgGenerator{},
 		&metricsFreebsd.CPUUsageGenerator{},
-		&metrics.FilesystemGenerator{IgnoreRegexp: conf.Filesystems.Ignore.Regexp, UseMountpoint: conf.Filesystems.UseMountpoint},
+		&metrics.FilesystemGenerator{IgnoreRegexp: conf.Filesystems.Ignore.Regexp, UseMountpoint: conf.Filesystems.UseMountpoint, Inodes: conf.Filesystems.Inodes},
 		&metricsFreebsd.MemoryGenerator{},
 		&metrics.InterfaceGenerator{IgnoreRegexp: conf.Interfaces.Ignore.Regexp, Interval: metricsInterval},
 	}
 
 	return generators
 }
+
+// isSystemPoweringOff is supported only on the systems running systemd.
+func isSystemPoweringOff() bool {
+	return false
+}
+
+// platformHostSpecsSignal has nothing cheaper than collecting the host specs on this platform.
+func platformHostSpecsSignal() string {
+	return ""
+}
@@ -1,11 +1,19 @@
 package command
 
 import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+
 	"github.com/mackerelio/mackerel-agent/config"
 	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/metrics/container"
+	"github.com/mackerelio/mackerel-agent/metrics/kubernetes"
 	metricsLinux "github.com/mackerelio/mackerel-agent/metrics/linux"
 	"github.com/mackerelio/mackerel-agent/spec"
 	specLinux "github.com/mackerelio/mackerel-agent/spec/linux"
+	"github.com/mackerelio/mackerel-agent/util"
 )
 
 func specGenerators() []spec.Generator {
@@ -28,9 +36,76 @@ func metricsGenerators(conf *config.Config) []metrics.Generator {
 		&metricsLinux.CPUUsageGenerator{Interval: metricsInterval},
 		&metricsLinux.MemoryGenerator{},
 		&metrics.InterfaceGenerator{IgnoreRegexp: conf.Interfaces.Ignore.Regexp, Interval: metricsInterval},
-		&metricsLinux.DiskGenerator{IgnoreRegexp: conf.Disks.Ignore.Regexp, Interval: metricsInterval, UseMountpoint: conf.Filesystems.UseMountpoint},
-		&metrics.FilesystemGenerator{IgnoreRegexp: conf.Filesystems.Ignore.Regexp, UseMountpoint: conf.Filesystems.UseMountpoint},
+		&metricsLinux.DiskGenerator{IgnoreRegexp: conf.Disks.Ignore.Regexp, Interval: metricsInterval, UseMountpoint: conf.Filesystems.UseMountpoint, Detailed: conf.Disks.Detailed},
+		&metrics.FilesystemGenerator{IgnoreRegexp: conf.Filesystems.Ignore.Regexp, UseMountpoint: conf.Filesystems.UseMountpoint, Inodes: conf.Filesystems.Inodes},
+	}
+
+	if conf.Processes.Enabled {
+		generators = append(generators, &metricsLinux.ProcessesGenerator{Interval: metricsInterval})
+	}
+	if conf.Memory.Detailed {
+		generators = append(generators, &metricsLinux.MemoryDetailGenerator{Interval: metricsInterval})
+	}
+	if conf.KernelTables.Enabled {
+		generators = append(generators, &metricsLinux.KernelTablesGenerator{Interval: metricsInterval})
+	}
+	if conf.NFS.Enabled {
+		generators = append(generators, &metricsLinux.NFSGenerator{IgnoreRegexp: conf.NFS.Ignore.Regexp, Interval: metricsInterval})
+	}
+	if conf.Sensors.Enabled {
+		generators = append(generators, &metricsLinux.SensorsGenerator{Interval: metricsInterval})
+	}
+	if conf.Container.Enabled {
+		generators = append(generators, &container.DockerGenerator{Client: container.NewDockerClient(conf.Container.Socket), Interval: metricsInterval})
+	}
+	if conf.Kubernetes.Enabled {
+		client, err := kubernetes.NewClient(&conf.Kubernetes)
+		if err != nil {
+			logger.Errorf("Failed to create a kubelet client: %s", err)
+		} else {
+			generators = append(generators, &kubernetes.KubeletGenerator{Client: client, Interval: metricsInterval})
+		}
+	}
+	if conf.Systemd.Enabled {
+		generators = append(generators, &metricsLinux.SystemdGenerator{Units: conf.Systemd.Units})
+	}
+	if len(conf.ProcessGroups) > 0 {
+		generators = append(generators, &metricsLinux.ProcessGroupGenerator{Groups: conf.ProcessGroups, Interval: metricsInterval})
 	}
 
 	return generators
 }
+
+// isSystemPoweringOff checks whether the system is going to be powered off by the jobs of systemd.
+func isSystemPoweringOff() bool {
+	poweringOff, err := util.IsSystemPoweringOff()
+	if err != nil {
+		logger.Debugf("Failed to check whether the system is powering off: %s", err)
+		return false
+	}
+	return poweringOff
+}
+
+// platformHostSpecsSignal reads the kernel release, the total memory and the online CPUs,
+// which change on the kernel upgrade and the resize of the virtual machine.
+func platformHostSpecsSignal() string {
+	var b strings.Builder
+	for _, file := range []string{"/proc/sys/kernel/osrelease", "/sys/devices/system/cpu/online"} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		b.Write(bytes.TrimSpace(content))
+		b.WriteByte('\n')
+	}
+	if content, err := os.ReadFile("/proc/meminfo"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "MemTotal:") {
+				b.WriteString(line)
+				break
+			}
+		}
+	}
+	return b.String()
+}
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -209,7 +210,7 @@ func TestPrepareWithUpdate(t *testing.T) {
 
 func TestCollectHostParam(t *testing.T) {
 	conf := config.Config{}
-	hostParam, err := collectHostParam(&conf, &AgentMeta{})
+	hostParam, err := collectHostParam(&conf, &AgentMeta{}, nil)
 
 	if err != nil {
 		t.Errorf("collectHostParam should not fail: %s", err)
@@ -248,7 +249,7 @@ func TestCollectHostParamWithChecks(t *testing.T) {
 			},
 		},
 	}
-	hostParam, err := collectHostParam(&conf, &AgentMeta{})
+	hostParam, err := collectHostParam(&conf, &AgentMeta{}, nil)
 
 	if err != nil {
 		t.Errorf("collectHostParam should not fail: %s", err)
@@ -262,6 +263,99 @@ func TestCollectHostParamWithChecks(t *testing.T) {
 	}
 }
 
+func TestPrepareCustomIdentiferHosts(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+
+	existing, creating, missing := "app.example.com", "db.example.com", "cache.example.com"
+	conf.MetricPlugins = map[string]*config.MetricPlugin{
+		"app":   {CustomIdentifier: &existing},
+		"db":    {CustomIdentifier: &creating},
+		"cache": {CustomIdentifier: &missing},
+	}
+	conf.CustomIdentifierHosts = map[string]*config.CustomIdentifierHost{
+		creating: {Create: true, Roles: []string{"db:main"}, DisplayName: "DB"},
+		missing:  {Create: false},
+	}
+
+	mockHandlers["GET /api/v0/hosts"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		if req.URL.Query().Get("customIdentifier") == existing {
+			return 200, jsonObject{"hosts": []mkr.Host{{ID: "app1234", CustomIdentifier: existing}}}
+		}
+		return 200, jsonObject{"hosts": []mkr.Host{}}
+	}
+	var created mkr.CreateHostParam
+	mockHandlers["POST /api/v0/hosts"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		if err := json.NewDecoder(req.Body).Decode(&created); err != nil {
+			t.Error(err)
+		}
+		return 200, jsonObject{"id": "db1234"}
+	}
+	mockHandlers["GET /api/v0/hosts/db1234"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		return 200, jsonObject{"host": mkr.Host{ID: "db1234", CustomIdentifier: creating}}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := prepareCustomIdentiferHosts(&conf, api)
+	if len(hosts) != 2 || hosts[existing].ID != "app1234" || hosts[creating].ID != "db1234" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+	if created.Name != creating || created.CustomIdentifier != creating || created.DisplayName != "DB" || len(created.RoleFullnames) != 1 {
+		t.Errorf("unexpected host param: %+v", created)
+	}
+}
+
+func TestCustomIdentifierHostsLoop(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+
+	origInterval := customIdentifierLookupInterval
+	customIdentifierLookupInterval = 10 * time.Millisecond
+	defer func() {
+		customIdentifierLookupInterval = origInterval
+	}()
+
+	customIdentifier := "app.example.com"
+	conf.CheckPlugins = map[string]*config.CheckPlugin{
+		"chk": {CustomIdentifier: &customIdentifier},
+	}
+
+	var mu sync.Mutex
+	requests := 0
+	mockHandlers["GET /api/v0/hosts"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// the host is registered after the agent started
+		if requests < 3 {
+			return 200, jsonObject{"hosts": []mkr.Host{}}
+		}
+		return 200, jsonObject{"hosts": []mkr.Host{{ID: "app1234", CustomIdentifier: customIdentifier}}}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{Config: &conf, API: api, CustomIdentifierHosts: prepareCustomIdentiferHosts(&conf, api)}
+	if _, ok := app.customIdentifierHost(customIdentifier); ok {
+		t.Fatal("the host should not be found yet")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	customIdentifierHostsLoop(ctx, app)
+	if ctx.Err() != nil {
+		t.Fatal("customIdentifierHostsLoop should return after all hosts are found")
+	}
+	if host, ok := app.customIdentifierHost(customIdentifier); !ok || host.ID != "app1234" {
+		t.Errorf("the host should be found: %v", host)
+	}
+}
+
 type counterGenerator struct {
 	counter int
 	sync.Mutex
@@ -390,6 +484,112 @@ func TestLoop(t *testing.T) {
 	}
 }
 
+type serviceGenerator struct {
+	counterGenerator
+	service string
+}
+
+func (g *serviceGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return nil, nil
+}
+
+func (g *serviceGenerator) CustomIdentifier() *string {
+	return nil
+}
+
+func (g *serviceGenerator) Service() *string {
+	return &g.service
+}
+
+func TestLoop_ServiceMetrics(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+
+	var mu sync.Mutex
+	serviceFailures := 0
+	receivedServiceValues := []mkr.MetricValue{}
+	receivedHostValues := map[float64]int{}
+	done := make(chan struct{})
+
+	mockHandlers["POST /api/v0/tsdb"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		mu.Lock()
+		defer mu.Unlock()
+		payload := []mkr.HostMetricValue{}
+		json.NewDecoder(req.Body).Decode(&payload)
+		for _, p := range payload {
+			if p.Name != "dummy.a" {
+				t.Errorf("only host metrics should be posted as host metrics: %+v", p)
+			}
+			receivedHostValues[p.Value.(float64)]++
+		}
+		return 200, jsonObject{"success": true}
+	}
+	mockHandlers["POST /api/v0/services/kpi/tsdb"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		mu.Lock()
+		defer mu.Unlock()
+		// the service metrics should be queued and retried like host metrics
+		if serviceFailures < 1 {
+			serviceFailures++
+			return 503, jsonObject{"failure": serviceFailures}
+		}
+		payload := []mkr.MetricValue{}
+		json.NewDecoder(req.Body).Decode(&payload)
+		receivedServiceValues = append(receivedServiceValues, payload...)
+		if len(receivedServiceValues) == 2 {
+			close(done)
+		}
+		return 200, jsonObject{"success": true}
+	}
+	mockHandlers["PUT /api/v0/hosts/xyzabc12345"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		return 200, jsonObject{"result": "OK"}
+	}
+
+	ag := &agent.Agent{
+		MetricsGenerators: []metrics.Generator{
+			&counterGenerator{},
+		},
+		PluginGenerators: []metrics.PluginGenerator{
+			&serviceGenerator{service: "kpi"},
+		},
+	}
+	api, err := mackerel.NewAPI(conf.Apibase, conf.Apikey, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{
+		Agent:     ag,
+		Config:    &conf,
+		API:       api,
+		Host:      &mkr.Host{ID: "xyzabc12345"},
+		AgentMeta: &AgentMeta{},
+	}
+	termCh := make(chan struct{})
+	exitCh := make(chan error)
+	go func() {
+		exitCh <- loop(app, termCh)
+	}()
+
+	<-done
+
+	mu.Lock()
+	for _, v := range receivedServiceValues {
+		if v.Name != "dummy.a" {
+			t.Errorf("the service metric should be posted without prefix: %+v", v)
+		}
+	}
+	for v, n := range receivedHostValues {
+		if n != 1 {
+			t.Errorf("the host metric %v should not be posted again on the failure of the service metrics but %d times", v, n)
+		}
+	}
+	mu.Unlock()
+
+	termCh <- struct{}{}
+	if exitErr := <-exitCh; exitErr != nil {
+		t.Errorf("exitErr should be nil, got: %s", exitErr)
+	}
+}
+
 func TestLoop_NetworkError(t *testing.T) {
 	if testing.Verbose() {
 		logging.SetLogLevel(logging.DEBUG)
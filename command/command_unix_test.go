@@ -3,7 +3,10 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mackerelio/mackerel-agent/config"
@@ -25,7 +28,7 @@ func TestRunOnce(t *testing.T) {
 			},
 		},
 	}
-	err := RunOnce(conf, &AgentMeta{})
+	err := RunOnce(conf, &AgentMeta{}, &OnceOption{})
 	if err != nil {
 		t.Errorf("RunOnce() should be nomal exit: %s", err)
 	}
@@ -84,3 +87,79 @@ func TestRunOncePayload(t *testing.T) {
 	}
 
 }
+
+func TestCollectOncePayload(t *testing.T) {
+	conf := &config.Config{
+		CloudPlatform: config.CloudPlatformNone,
+		MetricPlugins: map[string]*config.MetricPlugin{
+			"metric1": {
+				Command: config.Command{Cmd: diceCommand},
+			},
+		},
+		CheckPlugins: map[string]*config.CheckPlugin{
+			"check1": {
+				Command: config.Command{Cmd: "echo critical; exit 2"},
+			},
+			"check2": {
+				Command: config.Command{Cmd: "echo ok"},
+			},
+		},
+		MetadataPlugins: map[string]*config.MetadataPlugin{
+			"metadata1": {
+				Command: config.Command{Cmd: `echo '{"a": 1}'`},
+			},
+			"metadata2": {
+				Command: config.Command{Cmd: "echo invalid"},
+			},
+		},
+	}
+	payload, err := collectOncePayload(conf, &AgentMeta{}, &OnceOption{})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if len(payload.GraphDefs) != 1 || payload.Checks != nil || payload.Metadata != nil {
+		t.Errorf("only the graphdefs should be collected by default: %+v", payload)
+	}
+
+	payload, err = collectOncePayload(conf, &AgentMeta{}, &OnceOption{Checks: true, Metadata: true})
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if len(payload.Checks) != 2 || payload.Checks[0].Name != "check1" || payload.Checks[0].Status != "CRITICAL" || payload.Checks[1].Status != "OK" {
+		t.Errorf("unexpected checks: %+v", payload.Checks)
+	}
+	if !reflect.DeepEqual(payload.Metadata, map[string]any{"metadata1": map[string]any{"a": float64(1)}}) {
+		t.Errorf("unexpected metadata: %+v", payload.Metadata)
+	}
+	if _, ok := payload.MetadataErrors["metadata2"]; !ok {
+		t.Errorf("the error of metadata2 should be reported: %+v", payload.MetadataErrors)
+	}
+
+	var buf bytes.Buffer
+	if err := writeOncePayload(&buf, payload, "table"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"HOST", "custom.dice.d6", "custom.dice", "check1", "CRITICAL", `{"a":1}`, "metadata2"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("the table should contain %q but:\n%s", s, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := writeOncePayload(&buf, payload, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"host", "metrics", "graphDefs", "checks", "metadata", "metadataErrors"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("the json should have %q", key)
+		}
+	}
+
+	if err := RunOnce(conf, &AgentMeta{}, &OnceOption{Format: "xml"}); err == nil {
+		t.Errorf("RunOnce should fail for the unknown format")
+	}
+}
@@ -48,3 +48,13 @@ func metricsGenerators(conf *config.Config) []metrics.Generator {
 
 	return generators
 }
+
+// isSystemPoweringOff is supported only on the systems running systemd.
+func isSystemPoweringOff() bool {
+	return false
+}
+
+// platformHostSpecsSignal has nothing cheaper than collecting the host specs on this platform.
+func platformHostSpecsSignal() string {
+	return ""
+}
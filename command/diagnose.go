@@ -0,0 +1,612 @@
+package command
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/spec"
+)
+
+// DiagnoseOption configures the support bundle created by `diagnose`.
+type DiagnoseOption struct {
+	Output    string   // path of the tarball
+	LogFiles  []string // log files of the agent (default: the log files of the packages if exist)
+	LoadError error    // error while loading the config file
+}
+
+var defaultLogFiles = []string{"/var/log/mackerel-agent.log"}
+
+const (
+	diagnoseLogLines       = 1000
+	diagnoseCommandTimeout = 10 * time.Second
+)
+
+// diagnoseEntry is a file in the support bundle.
+type diagnoseEntry struct {
+	name  string
+	write func(w io.Writer) error
+}
+
+// Diagnose collects the information to troubleshoot the agent into a tarball.
+// The failures of the collections are written in the bundle instead of aborting it.
+func Diagnose(conf *config.Config, ameta *AgentMeta, opt *DiagnoseOption) error {
+	startedAt := time.Now()
+	dir := "mackerel-agent-diagnose-" + startedAt.Format("20060102150405")
+	output := opt.Output
+	if output == "" {
+		output = dir + ".tar.gz"
+	}
+
+	entries := []diagnoseEntry{
+		{"version.txt", func(w io.Writer) error { return writeDiagnoseVersion(w, ameta) }},
+		{"configtest.txt", func(w io.Writer) error { return writeConfigValidation(w, conf.Conffile, opt.LoadError) }},
+		{"connectivity.txt", func(w io.Writer) error { return writeConnectivity(w, conf, ameta) }},
+		{"plugins.txt", func(w io.Writer) error { return writePluginBinaries(w, conf) }},
+		{"timings.txt", func(w io.Writer) error { return writeGeneratorTimings(w, conf) }},
+		{"once.json", func(w io.Writer) error {
+			payload, err := collectOncePayload(conf, ameta, &OnceOption{Checks: true, Metadata: true})
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(payload)
+		}},
+	}
+	if conf.Conffile != "" {
+		entries = append(entries, diagnoseEntry{"config/" + filepath.Base(conf.Conffile), func(w io.Writer) error {
+			return writeRedactedConfig(w, conf.Conffile)
+		}})
+	}
+	if conf.Include != "" {
+		included, err := filepath.Glob(conf.Include)
+		if err != nil {
+			logger.Warningf("Failed to find the included config files: %s", err)
+		}
+		for _, file := range included {
+			entries = append(entries, diagnoseEntry{"config/include/" + filepath.Base(file), func(w io.Writer) error {
+				return writeRedactedConfig(w, file)
+			}})
+		}
+	}
+	logFiles := opt.LogFiles
+	if len(logFiles) == 0 {
+		for _, file := range defaultLogFiles {
+			if _, err := os.Stat(file); err == nil {
+				logFiles = append(logFiles, file)
+			}
+		}
+	}
+	for _, file := range logFiles {
+		entries = append(entries, diagnoseEntry{"logs/" + filepath.Base(file), func(w io.Writer) error {
+			return tailFile(w, file, diagnoseLogLines)
+		}})
+	}
+	if _, err := exec.LookPath("journalctl"); err == nil {
+		entries = append(entries, diagnoseEntry{"logs/journal.txt", func(w io.Writer) error {
+			return runDiagnoseCommand(w, "journalctl", "--unit=mackerel-agent", "--no-pager", fmt.Sprintf("--lines=%d", diagnoseLogLines))
+		}})
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create the support bundle: %w", err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		var buf bytes.Buffer
+		if err := e.write(&buf); err != nil {
+			logger.Warningf("Failed to collect %s: %s", e.name, err)
+			fmt.Fprintf(&buf, "\nerror: %s\n", err)
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:    dir + "/" + e.name,
+			Mode:    0600,
+			Size:    int64(buf.Len()),
+			ModTime: startedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	logger.Infof("Wrote the support bundle to %s (%s)", output, time.Since(startedAt).Round(time.Millisecond))
+	return nil
+}
+
+func writeDiagnoseVersion(w io.Writer, ameta *AgentMeta) error {
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(w, "Agent: %s\n", buildUA(ameta.Version, ameta.Revision))
+	fmt.Fprintf(w, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "Hostname: %s\n", hostname)
+	fmt.Fprintf(w, "Time: %s\n", time.Now().Format(time.RFC3339))
+	if content, err := os.ReadFile("/etc/os-release"); err == nil {
+		fmt.Fprintf(w, "\n/etc/os-release:\n%s\n", indent(string(content)))
+	}
+	if _, err := exec.LookPath("uname"); err == nil {
+		fmt.Fprintf(w, "\nuname -a:\n")
+		return runDiagnoseCommand(w, "uname", "-a")
+	}
+	return nil
+}
+
+func writeConfigValidation(w io.Writer, conffile string, loadErr error) error {
+	if loadErr != nil {
+		fmt.Fprintf(w, "Failed to load the config: %s\n", loadErr)
+	}
+	if conffile == "" {
+		return nil
+	}
+	fmt.Fprintf(w, "Config file: %s\n", conffile)
+	unexpectedKeys, err := config.ValidateConfigFile(conffile)
+	if err != nil {
+		return err
+	}
+	if len(unexpectedKeys) == 0 {
+		fmt.Fprintf(w, "No unexpected keys.\n")
+	}
+	for _, k := range unexpectedKeys {
+		if k.SuggestKey == "" {
+			fmt.Fprintf(w, "%s is unexpected key.\n", k.Key)
+		} else {
+			fmt.Fprintf(w, "%s is unexpected key. Did you mean %s ?\n", k.Key, k.SuggestKey)
+		}
+	}
+	return nil
+}
+
+var (
+	secretConfigKeyRe = regexp.MustCompile(`(?i)apikey|api_key|password|secret|token`)
+	urlCredentialsRe  = regexp.MustCompile(`(://)[^/@\s"']+@`)
+)
+
+const redacted = "<redacted>"
+
+// redactConfig hides the apikey and the other secrets in the content of the config file.
+// The values of env and the arguments of the commands are also hidden as they may contain secrets.
+// Note that the comments are dropped as the content is re-encoded.
+func redactConfig(content string) (string, error) {
+	var tree map[string]any
+	if _, err := toml.Decode(content, &tree); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(redactConfigTable(tree)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func redactConfigTable(table map[string]any) map[string]any {
+	for k, v := range table {
+		switch {
+		case secretConfigKeyRe.MatchString(k):
+			table[k] = redacted
+		case k == "env":
+			if env, ok := v.(map[string]any); ok {
+				for name := range env {
+					env[name] = redacted
+				}
+			} else {
+				table[k] = redacted
+			}
+		case k == "command":
+			table[k] = redactCommandConfig(v)
+		default:
+			table[k] = redactConfigValue(v)
+		}
+	}
+	return table
+}
+
+func redactConfigValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return redactConfigTable(v)
+	case []map[string]any:
+		for i, t := range v {
+			v[i] = redactConfigTable(t)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redactConfigValue(e)
+		}
+	case string:
+		return urlCredentialsRe.ReplaceAllString(v, "${1}"+redacted+"@")
+	}
+	return v
+}
+
+// redactCommandConfig keeps only the executable of the command given in a string or an array.
+func redactCommandConfig(v any) any {
+	switch v := v.(type) {
+	case string:
+		return redactCommandString(v)
+	case []any:
+		for i := 1; i < len(v); i++ {
+			v[i] = redacted
+		}
+		return v
+	}
+	return redacted
+}
+
+func redactCommandString(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) <= 1 {
+		return strings.TrimSpace(s)
+	}
+	return fields[0] + " " + redacted
+}
+
+// redactedCommandString is the CommandString of cmd without the arguments.
+func redactedCommandString(cmd *config.Command) string {
+	if len(cmd.Args) > 0 {
+		args := []string{cmd.Args[0]}
+		for range cmd.Args[1:] {
+			args = append(args, redacted)
+		}
+		return strings.Join(args, " ")
+	}
+	return redactCommandString(cmd.Cmd)
+}
+
+func writeRedactedConfig(w io.Writer, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	// The content is not written as is even if it is invalid, since it may contain secrets.
+	redactedContent, err := redactConfig(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	fmt.Fprintf(w, "# %s\n", file)
+	_, err = io.WriteString(w, redactedContent)
+	return err
+}
+
+// tailFile writes the last n lines of the file, reading at most 1MiB from the end.
+func tailFile(w io.Writer, file string, n int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	const maxSize = 1 << 20
+	offset := max(fi.Size()-maxSize, 0)
+	content, err := io.ReadAll(io.NewSectionReader(f, offset, fi.Size()-offset))
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if offset > 0 {
+		// The first line may be cut in the middle.
+		lines = lines[1:]
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	_, err = io.WriteString(w, strings.Join(lines[max(len(lines)-n, 0):], ""))
+	return err
+}
+
+func runDiagnoseCommand(w io.Writer, name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+func writeConnectivity(w io.Writer, conf *config.Config, ameta *AgentMeta) error {
+	fmt.Fprintf(w, "API base: %s\n", conf.Apibase)
+	fmt.Fprintf(w, "http_proxy (config): %s\n", redactURL(conf.HTTPProxy))
+	fmt.Fprintf(w, "https_proxy (config): %s\n", redactURL(conf.HTTPSProxy))
+	for _, env := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		fmt.Fprintf(w, "%s: %s\n", env, redactURL(os.Getenv(env)))
+	}
+
+	u, err := url.Parse(conf.Apibase)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u.JoinPath("/api/v0/org").String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", conf.Apikey)
+	req.Header.Set("User-Agent", buildUA(ameta.Version, ameta.Revision))
+
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %w", err)
+	}
+	if proxy == nil {
+		fmt.Fprintf(w, "Proxy for the API base: direct\n")
+	} else {
+		fmt.Fprintf(w, "Proxy for the API base: %s\n", proxy.Redacted())
+		startedAt := time.Now()
+		conn, err := net.DialTimeout("tcp", proxyAddress(proxy), diagnoseCommandTimeout)
+		if err != nil {
+			fmt.Fprintf(w, "Proxy connection: failed (%s)\n", err)
+		} else {
+			conn.Close()
+			fmt.Fprintf(w, "Proxy connection: ok (%s)\n", time.Since(startedAt).Round(time.Millisecond))
+		}
+	}
+
+	// The trace hooks may be called from the goroutines dialing in parallel.
+	var (
+		mu                               sync.Mutex
+		traced                           []string
+		dnsStart, connectStart, tlsStart time.Time
+	)
+	tracef := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		traced = append(traced, fmt.Sprintf(format, args...))
+	}
+	writeTraced := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, line := range traced {
+			fmt.Fprintln(w, line)
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				tracef("DNS lookup: failed (%s)", info.Err)
+				return
+			}
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, addr := range info.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			tracef("DNS lookup: %s (%s)", time.Since(dnsStart).Round(time.Millisecond), strings.Join(addrs, ", "))
+		},
+		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStart = time.Now()
+		},
+		ConnectDone: func(_, addr string, err error) {
+			mu.Lock()
+			elapsed := time.Since(connectStart).Round(time.Millisecond)
+			mu.Unlock()
+			if err != nil {
+				tracef("TCP connect to %s: failed (%s)", addr, err)
+				return
+			}
+			tracef("TCP connect to %s: %s", addr, elapsed)
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				tracef("TLS handshake: failed (%s)", err)
+				return
+			}
+			tracef("TLS handshake: %s (%s)", time.Since(tlsStart).Round(time.Millisecond), tls.VersionName(state.Version))
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	startedAt := time.Now()
+	resp, err := client.Do(req)
+	writeTraced()
+	if err != nil {
+		return fmt.Errorf("failed to request the API: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	fmt.Fprintf(w, "Response: %s (%s)\n", resp.Status, time.Since(startedAt).Round(time.Millisecond))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		fmt.Fprintf(w, "The apikey is invalid or does not have the permission.\n")
+	}
+	return nil
+}
+
+func proxyAddress(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	if proxy.Scheme == "https" {
+		return net.JoinHostPort(proxy.Hostname(), "443")
+	}
+	return net.JoinHostPort(proxy.Hostname(), "80")
+}
+
+// redactURL hides the password in the proxy URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// pluginBinary finds the executable of the plugin command.
+func pluginBinary(cmd *config.Command) string {
+	if len(cmd.Args) > 0 {
+		return cmd.Args[0]
+	}
+	if fields := strings.Fields(cmd.Cmd); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// versionedPluginBinaries are the official plugin packages which print their versions by --version.
+var versionedPluginBinaries = []string{"mackerel-agent-plugins", "mackerel-check"}
+
+func writePluginBinaries(w io.Writer, conf *config.Config) error {
+	commands := make(map[string]*config.Command)
+	for name, p := range conf.MetricPlugins {
+		commands["plugin.metrics."+name] = &p.Command
+	}
+	for name, p := range conf.CheckPlugins {
+		if p.Builtin == "" {
+			commands["plugin.checks."+name] = &p.Command
+		}
+	}
+	for name, p := range conf.MetadataPlugins {
+		commands["plugin.metadata."+name] = &p.Command
+	}
+	if len(commands) == 0 {
+		fmt.Fprintf(w, "No plugins.\n")
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		cmd := commands[name]
+		fmt.Fprintf(w, "%s\n  Command: %s\n", name, redactedCommandString(cmd))
+		path, err := exec.LookPath(pluginBinary(cmd))
+		if err != nil {
+			fmt.Fprintf(w, "  Binary: not found (%s)\n", err)
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(path); err == nil && real != path {
+			fmt.Fprintf(w, "  Binary: %s -> %s\n", path, real)
+			path = real
+		} else {
+			fmt.Fprintf(w, "  Binary: %s\n", path)
+		}
+		if fi, err := os.Stat(path); err == nil {
+			fmt.Fprintf(w, "  Size: %d\n  Modified: %s\n", fi.Size(), fi.ModTime().Format(time.RFC3339))
+		}
+		if sum, err := fileSHA256(path); err == nil {
+			fmt.Fprintf(w, "  SHA256: %s\n", sum)
+		}
+		if slices.Contains(versionedPluginBinaries, filepath.Base(path)) {
+			var buf bytes.Buffer
+			if err := runDiagnoseCommand(&buf, path, "--version"); err != nil {
+				fmt.Fprintf(w, "  Version: unknown (%s)\n", err)
+			} else {
+				fmt.Fprintf(w, "  Version: %s\n", oneLine(buf.String()))
+			}
+		}
+	}
+	return nil
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// writeGeneratorTimings runs the spec generators and the metric generators one by one and writes how long each takes.
+func writeGeneratorTimings(w io.Writer, conf *config.Config) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	timing := func(name string, generate func() error) {
+		startedAt := time.Now()
+		err := generate()
+		duration := time.Since(startedAt).Round(time.Millisecond)
+		if err != nil {
+			fmt.Fprintf(tw, "%s\t%s\terror: %s\n", name, duration, oneLine(err.Error()))
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\t\n", name, duration)
+		}
+	}
+
+	fmt.Fprintf(tw, "SPEC GENERATOR\tDURATION\t\n")
+	for _, g := range specGenerators() {
+		timing(fmt.Sprintf("%T", g), func() error {
+			_, err := g.Generate()
+			return err
+		})
+	}
+	var cGen *spec.CloudGenerator
+	timing("cloud (suggest)", func() error {
+		cGen = spec.CloudGeneratorSuggester.Suggest(conf)
+		return nil
+	})
+	if cGen != nil {
+		timing(fmt.Sprintf("%T", cGen.CloudMetaGenerator), func() error {
+			_, err := cGen.Generate()
+			return err
+		})
+	}
+	iGen := interfaceGenerator()
+	timing(fmt.Sprintf("%T", iGen), func() error {
+		_, err := iGen.Generate()
+		return err
+	})
+
+	origInterval := metricsInterval
+	metricsInterval = 1 * time.Second
+	defer func() {
+		metricsInterval = origInterval
+	}()
+	fmt.Fprintf(tw, "\nMETRIC GENERATOR\tDURATION\t\n")
+	for _, g := range metricsGenerators(conf) {
+		timing(fmt.Sprintf("%T", g), func() error {
+			_, err := g.Generate()
+			return err
+		})
+	}
+	for _, name := range slices.Sorted(maps.Keys(conf.MetricPlugins)) {
+		g := metrics.NewPluginGenerator(conf.MetricPlugins[name])
+		timing("plugin.metrics."+name, func() error {
+			_, err := g.Generate()
+			return err
+		})
+	}
+	return tw.Flush()
+}
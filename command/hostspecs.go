@@ -0,0 +1,85 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+// volatileFilesystemKeys are the keys of the filesystem specs which change every time.
+var volatileFilesystemKeys = []string{"kb_used", "kb_available", "percent_used"}
+
+// volatileCPUKeys are the keys of the CPU specs which change by the frequency scaling.
+var volatileCPUKeys = []string{"mhz"}
+
+// hostSpecsDigest returns the digest of the host specs excluding the volatile values
+// like the free memory and the usage of the filesystems, to skip updating the unchanged specs.
+func hostSpecsDigest(param *mkr.CreateHostParam) (string, error) {
+	p := *param
+	p.Meta.Memory = mkr.Memory{}
+	for _, key := range []string{"total", "swap_total"} {
+		if v, ok := param.Meta.Memory[key]; ok {
+			p.Meta.Memory[key] = v
+		}
+	}
+	p.Meta.CPU = make(mkr.CPU, len(param.Meta.CPU))
+	for i, cpu := range param.Meta.CPU {
+		p.Meta.CPU[i] = withoutKeys(cpu, volatileCPUKeys)
+	}
+	p.Meta.Filesystem = mkr.FileSystem{}
+	for name, fs := range param.Meta.Filesystem {
+		if m, ok := fs.(map[string]any); ok {
+			fs = withoutKeys(m, volatileFilesystemKeys)
+		}
+		p.Meta.Filesystem[name] = fs
+	}
+	// The order of the checks and the roles does not matter.
+	p.Checks = slices.Clone(param.Checks)
+	slices.SortFunc(p.Checks, func(a, b mkr.CheckConfig) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	p.RoleFullnames = slices.Sorted(slices.Values(param.RoleFullnames))
+
+	b, err := json.Marshal(&p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func withoutKeys(m map[string]any, keys []string) map[string]any {
+	m = maps.Clone(m)
+	for _, key := range keys {
+		delete(m, key)
+	}
+	return m
+}
+
+// hostSpecsSignal returns the values which are cheap to get but change along with the host specs,
+// i.e. the addresses of the network interfaces, and the kernel, the memory and the CPUs on Linux.
+// The host specs are collected again when the signal changes.
+func hostSpecsSignal() string {
+	var b strings.Builder
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Debugf("Failed to get the network interfaces: %s", err)
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		// The interfaces without addresses, e.g. the veth of the containers, are not in the host specs.
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s %v\n", iface.Name, iface.HardwareAddr, addrs)
+	}
+	b.WriteString(platformHostSpecsSignal())
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+func TestHostSpecsDigest(t *testing.T) {
+	newParam := func(volatile, kbUsed string, roles ...string) *mkr.CreateHostParam {
+		return &mkr.CreateHostParam{
+			Name: "host1",
+			Meta: mkr.HostMeta{
+				CPU:        mkr.CPU{{"model_name": "Xeon", "mhz": volatile}},
+				Memory:     mkr.Memory{"total": "1024kB", "free": volatile},
+				Filesystem: mkr.FileSystem{"/dev/sda1": map[string]any{"kb_size": "100", "kb_used": kbUsed}},
+			},
+			RoleFullnames: roles,
+		}
+	}
+	digest := func(p *mkr.CreateHostParam) string {
+		d, err := hostSpecsDigest(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	base := newParam("512kB", "10", "web:app", "web:db")
+	if digest(base) != digest(newParam("256kB", "20", "web:db", "web:app")) {
+		t.Errorf("the digest should not change by the volatile values and the order of the roles")
+	}
+	if base.Meta.Memory["free"] != "512kB" || base.Meta.Filesystem["/dev/sda1"].(map[string]any)["kb_used"] != "10" {
+		t.Errorf("the param should not be modified: %+v", base.Meta)
+	}
+
+	changed := newParam("512kB", "10", "web:app", "web:db")
+	changed.Meta.Memory["total"] = "2048kB"
+	if digest(base) == digest(changed) {
+		t.Errorf("the digest should change when the total memory changes")
+	}
+	if digest(base) == digest(newParam("512kB", "10", "web:app")) {
+		t.Errorf("the digest should change when the roles change")
+	}
+}
+
+func TestHostSpecsSignal(t *testing.T) {
+	if hostSpecsSignal() != hostSpecsSignal() {
+		t.Errorf("the signal should be stable")
+	}
+}
+
+func TestUpdateHostSpecs_SkipUnchanged(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+	conf.CloudPlatform = config.CloudPlatformNone
+
+	updated := 0
+	mockHandlers["PUT /api/v0/hosts/xyzabc12345"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		updated++
+		return 200, jsonObject{"result": "OK"}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{Config: &conf, Host: &mkr.Host{ID: "xyzabc12345"}, API: api, AgentMeta: &AgentMeta{}}
+
+	app.UpdateHostSpecs()
+	app.UpdateHostSpecs()
+	if updated != 1 {
+		t.Errorf("the unchanged host specs should not be updated but updated %d times", updated)
+	}
+
+	conf.DisplayName = "renamed"
+	app.UpdateHostSpecs()
+	if updated != 2 {
+		t.Errorf("the changed host specs should be updated but updated %d times", updated)
+	}
+
+	app.hostSpecsUpdatedAt = time.Now().Add(-specsForceUpdateInterval)
+	app.UpdateHostSpecs()
+	if updated != 3 {
+		t.Errorf("the host specs should be updated after specsForceUpdateInterval but updated %d times", updated)
+	}
+}
+
+func TestUpdateHostSpecsLoop_SkipUnchanged(t *testing.T) {
+	conf, mockHandlers, _, deferFunc := newMockAPIServer(t)
+	defer deferFunc()
+	conf.CloudPlatform = config.CloudPlatformNone
+
+	var mu sync.Mutex
+	updated := 0
+	mockHandlers["PUT /api/v0/hosts/xyzabc12345"] = func(_ http.ResponseWriter, req *http.Request) (int, jsonObject) {
+		mu.Lock()
+		defer mu.Unlock()
+		updated++
+		return 200, jsonObject{"result": "OK"}
+	}
+
+	api, err := NewMackerelClient(conf.Apibase, "", "1.0.0", "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{Config: &conf, Host: &mkr.Host{ID: "xyzabc12345"}, API: api, AgentMeta: &AgentMeta{}}
+
+	origUpdateInterval, origSignalInterval := specsUpdateInterval, specsSignalInterval
+	specsUpdateInterval, specsSignalInterval = 10*time.Millisecond, 10*time.Millisecond
+	defer func() {
+		specsUpdateInterval, specsSignalInterval = origUpdateInterval, origSignalInterval
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	updateHostSpecsLoop(ctx, app)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if updated != 1 {
+		t.Errorf("the periodic updates of the unchanged host specs should be skipped but updated %d times", updated)
+	}
+}
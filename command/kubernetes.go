@@ -0,0 +1,82 @@
+package command
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/metrics/kubernetes"
+)
+
+// kubernetesNode is the node found from the kubelet and the API server.
+type kubernetesNode struct {
+	roles      []string
+	rolesFound bool
+	name       string
+}
+
+var (
+	kubernetesNodesMu sync.Mutex
+	// kubernetesNodes caches the nodes by the configurations after the first success,
+	// not to query them on every update of the host specs.
+	kubernetesNodes = make(map[*config.Kubernetes]*kubernetesNode)
+)
+
+// kubernetesHostParam adds the roles from the node labels and suggests the node name as the custom identifier.
+// The errors are logged and the given values are returned as they are, not to prevent the agent from starting.
+func kubernetesHostParam(conf *config.Kubernetes, roles []string, customIdentifier string) ([]string, string) {
+	if conf.RoleService == "" && !conf.NodeNameAsCustomIdentifier {
+		return roles, customIdentifier
+	}
+	node := lookupKubernetesNode(conf)
+	for _, role := range node.roles {
+		if !slices.Contains(roles, role) {
+			// copy not to modify the roles of the configuration
+			roles = append(slices.Clip(roles), role)
+		}
+	}
+	if node.name != "" {
+		customIdentifier = node.name
+	}
+	return roles, customIdentifier
+}
+
+// lookupKubernetesNode returns the cached node, looking up what is not found yet.
+func lookupKubernetesNode(conf *config.Kubernetes) kubernetesNode {
+	kubernetesNodesMu.Lock()
+	defer kubernetesNodesMu.Unlock()
+	node, ok := kubernetesNodes[conf]
+	if !ok {
+		node = &kubernetesNode{}
+		kubernetesNodes[conf] = node
+	}
+	needRoles := conf.RoleService != "" && !node.rolesFound
+	needName := conf.NodeNameAsCustomIdentifier && node.name == ""
+	if !needRoles && !needName {
+		return *node
+	}
+
+	client, err := kubernetes.NewClient(conf)
+	if err != nil {
+		logger.Warningf("Failed to create a kubelet client: %s", err)
+		return *node
+	}
+	if needRoles {
+		nodeRoles, err := client.NodeRoles(conf.RoleService)
+		if err != nil {
+			logger.Warningf("Failed to get the roles from the node labels: %s", err)
+		} else {
+			node.roles = nodeRoles
+			node.rolesFound = true
+		}
+	}
+	if needName {
+		nodeName, err := client.SuggestCustomIdentifier()
+		if err != nil {
+			logger.Warningf("Failed to get the node name: %s", err)
+		} else {
+			node.name = nodeName
+		}
+	}
+	return *node
+}
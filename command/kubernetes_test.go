@@ -0,0 +1,64 @@
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+func TestKubernetesHostParam(t *testing.T) {
+	requested := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/nodes/node-1", func(w http.ResponseWriter, r *http.Request) {
+		requested++
+		fmt.Fprint(w, `{"metadata": {"labels": {"node-role.kubernetes.io/worker": "", "kubernetes.io/os": "linux"}}}`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	conf := &config.Kubernetes{
+		KubeletEndpoint:            ts.URL,
+		APIServerEndpoint:          ts.URL,
+		NodeName:                   "node-1",
+		RoleService:                "k8s",
+		NodeNameAsCustomIdentifier: true,
+	}
+	configured := []string{"web:app", "k8s:worker"}
+	roles, customIdentifier := kubernetesHostParam(conf, configured, "i-0123456789")
+	if expect := []string{"web:app", "k8s:worker"}; !reflect.DeepEqual(roles, expect) {
+		t.Errorf("roles should be %v but %v", expect, roles)
+	}
+	if customIdentifier != "node-1" {
+		t.Errorf("custom identifier should be node-1 but %q", customIdentifier)
+	}
+
+	roles, _ = kubernetesHostParam(conf, configured[:1], "")
+	if expect := []string{"web:app", "k8s:worker"}; !reflect.DeepEqual(roles, expect) {
+		t.Errorf("roles should be %v but %v", expect, roles)
+	}
+	if configured[1] != "k8s:worker" {
+		t.Errorf("the configured roles should not be modified: %v", configured)
+	}
+
+	// the node found once should be cached
+	requested = 0
+	roles, customIdentifier = kubernetesHostParam(conf, configured[:1], "")
+	if expect := []string{"web:app", "k8s:worker"}; !reflect.DeepEqual(roles, expect) || customIdentifier != "node-1" {
+		t.Errorf("the cached node should be used but roles = %v, custom identifier = %q", roles, customIdentifier)
+	}
+	if requested != 0 {
+		t.Errorf("the node should not be requested again but requested %d times", requested)
+	}
+
+	// the errors should not prevent the host from being registered
+	failing := *conf
+	failing.APIServerEndpoint = ts.URL + "/unknown"
+	roles, _ = kubernetesHostParam(&failing, []string{"web:app"}, "")
+	if expect := []string{"web:app"}; !reflect.DeepEqual(roles, expect) {
+		t.Errorf("roles should be %v but %v", expect, roles)
+	}
+}
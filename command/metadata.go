@@ -10,9 +10,17 @@ import (
 	"github.com/mackerelio/mackerel-agent/config"
 	"github.com/mackerelio/mackerel-agent/mackerel"
 	"github.com/mackerelio/mackerel-agent/metadata"
+	"github.com/mackerelio/mackerel-agent/metrics/container"
+	"github.com/mackerelio/mackerel-agent/metrics/kubernetes"
 	mkr "github.com/mackerelio/mackerel-client-go"
 )
 
+// the namespaces of the host metadata for the container and pod inventories
+const (
+	containerMetadataNamespace  = "containers"
+	kubernetesMetadataNamespace = "kubernetes"
+)
+
 func metadataGenerators(conf *config.Config) []*metadata.Generator {
 	generators := make([]*metadata.Generator, 0, len(conf.MetadataPlugins))
 
@@ -27,6 +35,32 @@ func metadataGenerators(conf *config.Config) []*metadata.Generator {
 		generators = append(generators, generator)
 	}
 
+	if conf.Container.Enabled && conf.Container.Inventory {
+		if _, ok := conf.MetadataPlugins[containerMetadataNamespace]; ok {
+			logger.Warningf("The container inventory is not published since the metadata plugin %q is configured", containerMetadataNamespace)
+		} else {
+			generators = append(generators, &metadata.Generator{
+				Name:      containerMetadataNamespace,
+				Cachefile: filepath.Join(workdir, containerMetadataNamespace),
+				Source:    container.NewDockerClient(conf.Container.Socket).Inventory,
+			})
+		}
+	}
+
+	if conf.Kubernetes.Enabled && conf.Kubernetes.Metadata {
+		if _, ok := conf.MetadataPlugins[kubernetesMetadataNamespace]; ok {
+			logger.Warningf("The pod inventory is not published since the metadata plugin %q is configured", kubernetesMetadataNamespace)
+		} else if client, err := kubernetes.NewClient(&conf.Kubernetes); err != nil {
+			logger.Errorf("Failed to create a kubelet client: %s", err)
+		} else {
+			generators = append(generators, &metadata.Generator{
+				Name:      kubernetesMetadataNamespace,
+				Cachefile: filepath.Join(workdir, kubernetesMetadataNamespace),
+				Source:    client.Inventory,
+			})
+		}
+	}
+
 	return generators
 }
 
@@ -89,6 +123,9 @@ func runMetadataLoop(ctx context.Context, app *App, termMetadataCh <-chan struct
 			}
 		}
 
+		if app.retired.Load() {
+			continue
+		}
 		for _, result := range results {
 			err := app.API.PutHostMetaData(app.Host.ID, result.namespace, result.metadata)
 			// retry on 5XX errors
@@ -0,0 +1,193 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/agent"
+	"github.com/mackerelio/mackerel-agent/checks"
+	"github.com/mackerelio/mackerel-agent/config"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+// OnceOption configures what `once` runs in addition to the host specs and the metrics, and how it outputs.
+type OnceOption struct {
+	Checks   bool   // run the check plugins
+	Metadata bool   // run the metadata plugins
+	Format   string // "json" (default) or "table"
+}
+
+// oncePayload is what the agent would post, collected by `once`.
+type oncePayload struct {
+	Host           *mkr.CreateHostParam  `json:"host"`
+	Metrics        *agent.MetricsResult  `json:"metrics"`
+	GraphDefs      []*mkr.GraphDefsParam `json:"graphDefs"`
+	Checks         []*onceCheckReport    `json:"checks,omitempty"`
+	Metadata       map[string]any        `json:"metadata,omitempty"`
+	MetadataErrors map[string]string     `json:"metadataErrors,omitempty"`
+}
+
+type onceCheckReport struct {
+	Name             string  `json:"name"`
+	Status           string  `json:"status"`
+	Message          string  `json:"message"`
+	CustomIdentifier *string `json:"customIdentifier,omitempty"`
+}
+
+func collectOncePayload(conf *config.Config, ameta *AgentMeta, opt *OnceOption) (*oncePayload, error) {
+	graphdefs, hostSpec, metrics, err := runOncePayload(conf, ameta)
+	if err != nil {
+		return nil, err
+	}
+	payload := &oncePayload{
+		Host:      hostSpec,
+		Metrics:   metrics,
+		GraphDefs: graphdefs,
+	}
+	if opt.Checks {
+		payload.Checks = runChecksOnce(createCheckers(conf))
+	}
+	if opt.Metadata {
+		payload.Metadata = make(map[string]any)
+		for _, g := range metadataGenerators(conf) {
+			metadata, err := g.Fetch()
+			if err != nil {
+				if payload.MetadataErrors == nil {
+					payload.MetadataErrors = make(map[string]string)
+				}
+				payload.MetadataErrors[g.Name] = err.Error()
+				continue
+			}
+			payload.Metadata[g.Name] = metadata
+		}
+	}
+	return payload, nil
+}
+
+// runChecksOnce runs the checkers concurrently and returns the reports sorted by the names.
+func runChecksOnce(checkers []*checks.Checker) []*onceCheckReport {
+	reports := make([]*onceCheckReport, len(checkers))
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Go(func() {
+			report := checker.Check()
+			reports[i] = &onceCheckReport{
+				Name:             report.Name,
+				Status:           string(report.Status),
+				Message:          report.Message,
+				CustomIdentifier: report.CustomIdentfier,
+			}
+		})
+	}
+	wg.Wait()
+	slices.SortFunc(reports, func(a, b *onceCheckReport) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return reports
+}
+
+func writeOncePayload(w io.Writer, payload *oncePayload, format string) error {
+	switch format {
+	case "", "json":
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case "table":
+		return writeOnceTable(w, payload)
+	}
+	return fmt.Errorf("unknown format: %q", format)
+}
+
+func writeOnceTable(w io.Writer, payload *oncePayload) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	host := payload.Host
+	fmt.Fprintf(tw, "HOST\n")
+	fmt.Fprintf(tw, "  name\t%s\n", host.Name)
+	if host.DisplayName != "" {
+		fmt.Fprintf(tw, "  display name\t%s\n", host.DisplayName)
+	}
+	if host.CustomIdentifier != "" {
+		fmt.Fprintf(tw, "  custom identifier\t%s\n", host.CustomIdentifier)
+	}
+	fmt.Fprintf(tw, "  roles\t%s\n", strings.Join(host.RoleFullnames, ", "))
+	fmt.Fprintf(tw, "  agent\t%s\n", host.Meta.AgentName)
+	fmt.Fprintf(tw, "  kernel\t%s %s\n", host.Meta.Kernel["name"], host.Meta.Kernel["release"])
+	fmt.Fprintf(tw, "  cpu\t%d cores\n", len(host.Meta.CPU))
+	fmt.Fprintf(tw, "  memory\t%s\n", host.Meta.Memory["total"])
+	if host.Meta.Cloud != nil {
+		fmt.Fprintf(tw, "  cloud\t%s\n", host.Meta.Cloud.Provider)
+	}
+	for _, iface := range host.Interfaces {
+		fmt.Fprintf(tw, "  interface\t%s %s\n", iface.Name, strings.Join(slices.Concat(iface.IPv4Addresses, iface.IPv6Addresses), " "))
+	}
+	for _, check := range host.Checks {
+		fmt.Fprintf(tw, "  check monitor\t%s\n", check.Name)
+	}
+
+	fmt.Fprintf(tw, "\nMETRIC\tVALUE\tTARGET\n")
+	for _, values := range payload.Metrics.Values {
+		target := "host"
+		if values.CustomIdentifier != nil {
+			target = "custom_identifier:" + *values.CustomIdentifier
+		}
+		if values.Service != nil {
+			target = "service:" + *values.Service
+		}
+		for _, name := range slices.Sorted(maps.Keys(values.Values)) {
+			v := values.Values[name]
+			value := fmt.Sprint(v.Value)
+			if v.Time != nil {
+				value += " @" + time.Unix(*v.Time, 0).Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, target)
+		}
+	}
+
+	if len(payload.GraphDefs) > 0 {
+		fmt.Fprintf(tw, "\nGRAPH\tUNIT\tMETRICS\n")
+		graphDefs := slices.Clone(payload.GraphDefs)
+		slices.SortFunc(graphDefs, func(a, b *mkr.GraphDefsParam) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		for _, g := range graphDefs {
+			names := make([]string, 0, len(g.Metrics))
+			for _, m := range g.Metrics {
+				names = append(names, m.Name)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", g.Name, g.Unit, strings.Join(names, ", "))
+		}
+	}
+
+	if len(payload.Checks) > 0 {
+		fmt.Fprintf(tw, "\nCHECK\tSTATUS\tMESSAGE\n")
+		for _, c := range payload.Checks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, c.Status, oneLine(c.Message))
+		}
+	}
+
+	if len(payload.Metadata) > 0 || len(payload.MetadataErrors) > 0 {
+		fmt.Fprintf(tw, "\nMETADATA\tVALUE\n")
+		for _, name := range slices.Sorted(maps.Keys(payload.Metadata)) {
+			b, err := json.Marshal(payload.Metadata[name])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", name, b)
+		}
+		for _, name := range slices.Sorted(maps.Keys(payload.MetadataErrors)) {
+			fmt.Fprintf(tw, "%s\terror: %s\n", name, payload.MetadataErrors[name])
+		}
+	}
+	return tw.Flush()
+}
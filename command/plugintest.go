@@ -0,0 +1,171 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/checks"
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+// findPlugin finds the metric or check plugin by the name like "metrics.foo", "checks.foo" or "foo".
+func findPlugin(conf *config.Config, name string) (kind, key string, err error) {
+	if k, ok := strings.CutPrefix(name, "metrics."); ok {
+		if _, found := conf.MetricPlugins[k]; found {
+			return "metrics", k, nil
+		}
+	}
+	if k, ok := strings.CutPrefix(name, "checks."); ok {
+		if _, found := conf.CheckPlugins[k]; found {
+			return "checks", k, nil
+		}
+	}
+	_, isMetric := conf.MetricPlugins[name]
+	_, isCheck := conf.CheckPlugins[name]
+	switch {
+	case isMetric && isCheck:
+		return "", "", fmt.Errorf("both of plugin.metrics.%s and plugin.checks.%s are found. Specify metrics.%s or checks.%s", name, name, name, name)
+	case isMetric:
+		return "metrics", name, nil
+	case isCheck:
+		return "checks", name, nil
+	}
+	return "", "", fmt.Errorf("plugin %q is not found in plugin.metrics nor plugin.checks", name)
+}
+
+// RunPluginTest runs the metric or check plugin once as the agent does, and writes the result to w.
+func RunPluginTest(conf *config.Config, name string, w io.Writer) error {
+	kind, key, err := findPlugin(conf, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Plugin: plugin.%s.%s\n", kind, key)
+	if kind == "metrics" {
+		return testMetricPlugin(conf.MetricPlugins[key], w)
+	}
+	return testCheckPlugin(key, conf.CheckPlugins[key], conf.ProcessGroups, w)
+}
+
+func writeCommand(w io.Writer, cmd *config.Command) {
+	fmt.Fprintf(w, "Command: %s\n", cmd.CommandString())
+	if cmd.User != "" {
+		fmt.Fprintf(w, "User: %s\n", cmd.User)
+	}
+	timeout := "default"
+	if cmd.TimeoutDuration != 0 {
+		timeout = cmd.TimeoutDuration.String()
+	}
+	fmt.Fprintf(w, "Timeout: %s\n", timeout)
+	if len(cmd.Env) > 0 {
+		// The values may be secrets.
+		names := make([]string, 0, len(cmd.Env))
+		for _, env := range cmd.Env {
+			name, _, _ := strings.Cut(env, "=")
+			names = append(names, name)
+		}
+		fmt.Fprintf(w, "Env: %s\n", strings.Join(names, ", "))
+	}
+}
+
+func writeRun(w io.Writer, duration time.Duration, exitCode int, stderr string, err error) {
+	fmt.Fprintf(w, "Duration: %s\n", duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Exit code: %d\n", exitCode)
+	if err != nil {
+		fmt.Fprintf(w, "Error: %s\n", err)
+	}
+	if stderr != "" {
+		fmt.Fprintf(w, "Stderr:\n%s\n", indent(stderr))
+	}
+}
+
+func testMetricPlugin(plugin *config.MetricPlugin, w io.Writer) error {
+	writeCommand(w, &plugin.Command)
+	if plugin.CustomIdentifier != nil {
+		fmt.Fprintf(w, "Custom identifier: %s\n", *plugin.CustomIdentifier)
+	}
+	if plugin.Service != nil {
+		fmt.Fprintf(w, "Service: %s\n", *plugin.Service)
+	}
+
+	result := metrics.InspectPlugin(plugin)
+	writeRun(w, result.Duration, result.ExitCode, result.Stderr, result.Err)
+	if result.Err != nil {
+		return fmt.Errorf("failed to run the plugin: %w", result.Err)
+	}
+
+	fmt.Fprintf(w, "\nValues (%d):\n", len(result.Values))
+	for _, name := range slices.Sorted(maps.Keys(result.Values)) {
+		v := result.Values[name]
+		if v.Time != nil {
+			fmt.Fprintf(w, "  %s\t%v\t(timestamp: %d)\n", name, v.Value, *v.Time)
+		} else {
+			fmt.Fprintf(w, "  %s\t%v\n", name, v.Value)
+		}
+	}
+	if len(result.Rejected) > 0 {
+		fmt.Fprintf(w, "\nRejected lines (%d):\n", len(result.Rejected))
+		for _, r := range result.Rejected {
+			fmt.Fprintf(w, "  %q: %s\n", r.Line, r.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "\nGraph definitions:\n")
+	if result.MetaErr != nil {
+		fmt.Fprintf(w, "  none (%s)\n", result.MetaErr)
+		return nil
+	}
+	graphDefs := slices.Clone(result.GraphDefs)
+	slices.SortFunc(graphDefs, func(a, b *mkr.GraphDefsParam) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, g := range graphDefs {
+		fmt.Fprintf(w, "  %s\tlabel: %q\tunit: %s\n", g.Name, g.DisplayName, g.Unit)
+		for _, m := range g.Metrics {
+			fmt.Fprintf(w, "    %s\tlabel: %q\tstacked: %t\n", m.Name, m.DisplayName, m.IsStacked)
+		}
+	}
+	return nil
+}
+
+func testCheckPlugin(name string, plugin *config.CheckPlugin, groups map[string]*config.ProcessGroup, w io.Writer) error {
+	if plugin.Builtin != "" {
+		fmt.Fprintf(w, "Builtin: %s %v\n", plugin.Builtin, plugin.Targets)
+	} else {
+		writeCommand(w, &plugin.Command)
+	}
+	if plugin.CustomIdentifier != nil {
+		fmt.Fprintf(w, "Custom identifier: %s\n", *plugin.CustomIdentifier)
+	}
+
+	checker := &checks.Checker{Name: name, Config: plugin, ProcessGroups: groups}
+	startedAt := time.Now()
+	report := checker.Check()
+	if run := checker.LastRun(); run != nil {
+		writeRun(w, run.Duration, run.ExitCode, run.Stderr, run.Err)
+		fmt.Fprintf(w, "\nStatus: %s (exit code 0: OK, 1: WARNING, 2: CRITICAL, others: UNKNOWN)\n", report.Status)
+	} else {
+		fmt.Fprintf(w, "Duration: %s\n", time.Since(startedAt).Round(time.Millisecond))
+		fmt.Fprintf(w, "\nStatus: %s\n", report.Status)
+	}
+	if report.Message != "" {
+		fmt.Fprintf(w, "Message:\n%s\n", indent(report.Message))
+	}
+	if plugin.Action != nil {
+		fmt.Fprintf(w, "Action: %s (not run)\n", plugin.Action.CommandString())
+	}
+	return nil
+}
+
+func indent(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "  " + line
+	}
+	return strings.Join(lines, "\n")
+}
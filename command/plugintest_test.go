@@ -0,0 +1,79 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package command
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+func TestRunPluginTest(t *testing.T) {
+	confFile := filepath.Join(t.TempDir(), "mackerel-agent.conf")
+	err := os.WriteFile(confFile, []byte(`
+apikey = "abcde"
+
+[plugin.metrics.foo]
+command = "echo 'foo.a\t1\t1700000000'; echo 'foo.b\tx\t1700000000'; echo oops >&2"
+env = { SECRET = "password" }
+timeout_seconds = 10
+
+[plugin.checks.foo]
+command = "echo critical; exit 2"
+
+[plugin.checks.bar]
+command = "echo ok"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.LoadConfig(confFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		contains []string
+		excludes []string
+		err      bool
+	}{
+		{
+			name:     "metrics.foo",
+			contains: []string{"plugin.metrics.foo", "Timeout: 10s", "Env: SECRET", "oops", "custom.foo.a\t1", `"foo.b\tx\t1700000000": failed to parse the value`, "Graph definitions:"},
+			excludes: []string{"password"},
+		},
+		{
+			name:     "checks.foo",
+			contains: []string{"plugin.checks.foo", "Exit code: 2", "Status: CRITICAL", "critical"},
+		},
+		{
+			name:     "bar",
+			contains: []string{"plugin.checks.bar", "Status: OK"},
+		},
+		{name: "foo", err: true},
+		{name: "baz", err: true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := RunPluginTest(conf, tt.name, &buf)
+		if (err != nil) != tt.err {
+			t.Errorf("RunPluginTest(%q) should fail: %t but err = %v", tt.name, tt.err, err)
+			continue
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(buf.String(), s) {
+				t.Errorf("the output of %q should contain %q but:\n%s", tt.name, s, buf.String())
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(buf.String(), s) {
+				t.Errorf("the output of %q should not contain %q but:\n%s", tt.name, s, buf.String())
+			}
+		}
+	}
+}
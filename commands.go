@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -109,37 +110,88 @@ func doVersion(_ *flag.FlagSet, _ []string) error {
 /*
 	 +command configtest - configtest
 
-		configtest
+		configtest [-json]
 
-do configtest
+test the config file and the included files. It reports the unexpected keys and the semantic problems,
+e.g. the plugin commands not found in PATH, the invalid users and regular expressions,
+the plugins overwritten by the included files and the values the agent adjusts.
+It fails on the CRITICAL problems and the unexpected keys.
 */
 func doConfigtest(fs *flag.FlagSet, argv []string) error {
-	conf, err := resolveConfig(fs, argv)
-	red := color.New(color.FgRed)
-	yellow := color.New(color.FgYellow)
-	if err != nil {
-		return errors.New(red.Sprintf("[CRITICAL] failed to test config: %s", err))
+	conf, asJSON, err := resolveConfigForConfigtest(fs, argv)
+	result := &configtestResult{
+		File:     conf.Conffile,
+		Success:  true,
+		Problems: []config.Problem{},
 	}
-	validResult, err := config.ValidateConfigFile(conf.Conffile)
 	if err != nil {
-		return errors.New(red.Sprintf("[CRITICAL] failed to test config: %s", err))
+		result.add(config.Problem{Severity: config.SeverityCritical, File: conf.Conffile, Message: fmt.Sprintf("failed to test config: %s", err)})
+	}
+	unexpectedKeys, verr := config.ValidateConfigFile(conf.Conffile)
+	if verr != nil && err == nil {
+		result.add(config.Problem{Severity: config.SeverityCritical, File: conf.Conffile, Message: verr.Error()})
 	}
-	if len(validResult) > 0 {
-		var messages strings.Builder
-		for _, v := range validResult {
-			if v.SuggestKey == "" {
-				messages.WriteString(yellow.Sprintf("[WARNING] %s is unexpected key.\n", v.Key))
-			} else {
-				messages.WriteString(yellow.Sprintf("[WARNING] %s is unexpected key. Did you mean %s ?\n", v.Key, v.SuggestKey))
-			}
+	for _, v := range unexpectedKeys {
+		message := fmt.Sprintf("%s is unexpected key.", v.Key)
+		if v.SuggestKey != "" {
+			message = fmt.Sprintf("%s is unexpected key. Did you mean %s ?", v.Key, v.SuggestKey)
 		}
-		return errors.New(messages.String())
+		result.add(config.Problem{Severity: config.SeverityWarning, Key: v.Key, File: conf.Conffile, Message: message})
+		result.Success = false
+	}
+	if problems, err := config.CheckConfigFile(conf.Conffile); err == nil {
+		for _, p := range problems {
+			result.add(p)
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		if !result.Success {
+			return errors.New("configtest failed")
+		}
+		return nil
 	}
 
+	red := color.New(color.FgRed)
+	yellow := color.New(color.FgYellow)
+	var messages strings.Builder
+	for _, p := range result.Problems {
+		message := fmt.Sprintf("[%s] %s", p.Severity, p.Message)
+		if p.File != "" && p.File != conf.Conffile {
+			message += fmt.Sprintf(" (%s)", p.File)
+		}
+		if p.Severity == config.SeverityCritical {
+			messages.WriteString(red.Sprintln(message))
+		} else {
+			messages.WriteString(yellow.Sprintln(message))
+		}
+	}
+	if !result.Success {
+		return errors.New(messages.String())
+	}
+	fmt.Fprint(os.Stderr, messages.String())
 	fmt.Fprintf(os.Stderr, "SUCCESS (%s)\n", conf.Conffile)
 	return nil
 }
 
+type configtestResult struct {
+	File     string           `json:"file"`
+	Success  bool             `json:"success"`
+	Problems []config.Problem `json:"problems"`
+}
+
+func (r *configtestResult) add(p config.Problem) {
+	if p.Severity == config.SeverityCritical {
+		r.Success = false
+	}
+	r.Problems = append(r.Problems, p)
+}
+
 /*
 	 +command retire - retire the host
 
@@ -154,6 +206,7 @@ func doRetire(fs *flag.FlagSet, argv []string) error {
 		return fmt.Errorf("failed to load config: %s", err)
 	}
 
+	command.SetupHostIDStorage(conf)
 	hostID, err := conf.LoadHostID()
 	if err != nil {
 		return fmt.Errorf("hostID file is not found or empty")
@@ -186,14 +239,15 @@ func doRetire(fs *flag.FlagSet, argv []string) error {
 /*
 	 +command once - output onetime
 
-		once
+		once [-checks] [-metadata] [-format=json|table]
 
-output metrics and meta data of the host one time.
+output metrics, graph definitions and meta data of the host one time,
+and the results of the check plugins and the metadata plugins if specified.
 These data are only displayed and not posted to Mackerel.
 */
 func doOnce(fs *flag.FlagSet, argv []string) error {
 	version, gitcommit := fromVCS()
-	conf, err := resolveConfig(fs, argv)
+	conf, opt, err := resolveConfigForOnce(fs, argv)
 	if err != nil {
 		logger.Warningf("failed to load config (but `once` must not required conf): %s", err)
 		conf = &config.Config{}
@@ -201,5 +255,101 @@ func doOnce(fs *flag.FlagSet, argv []string) error {
 	return command.RunOnce(conf, &command.AgentMeta{
 		Version:  version,
 		Revision: gitcommit,
-	})
+	}, opt)
+}
+
+/*
+	 +command diagnose - create a support bundle
+
+		diagnose [-output=xxx.tar.gz] [-log=xxx,yyy]
+
+create a tarball to troubleshoot the agent, which contains the config files with the secrets redacted,
+the version of the agent and the OS, the result of configtest and once, the recent logs,
+the binaries of the plugins, the connectivity to the API base and the timings of the generators.
+*/
+func doDiagnose(fs *flag.FlagSet, argv []string) error {
+	version, gitcommit := fromVCS()
+	conf, opt, err := resolveConfigForDiagnose(fs, argv)
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	if err != nil {
+		logger.Warningf("failed to load config (but `diagnose` continues to report it): %s", err)
+	}
+	return command.Diagnose(conf, &command.AgentMeta{
+		Version:  version,
+		Revision: gitcommit,
+	}, opt)
+}
+
+/*
+	 +command annotate - post a graph annotation
+
+		annotate -title=xxx [-description=xxx] [-service=xxx [-roles=xxx,yyy]] [-from=xxx] [-to=xxx]
+
+post a graph annotation to the services and the roles of the host.
+The time is specified in epoch seconds or RFC3339.
+*/
+func doAnnotate(fs *flag.FlagSet, argv []string) error {
+	version, gitcommit := fromVCS()
+	conf, param, err := resolveConfigForAnnotate(fs, argv)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+	err = command.Annotate(conf, &command.AgentMeta{
+		Version:  version,
+		Revision: gitcommit,
+	}, param)
+	if err != nil {
+		return err
+	}
+	logger.Infof("The annotation has been posted.")
+	return nil
+}
+
+/*
+	 +command status - show the status of the running agent
+
+		status [-json]
+
+show the status of the running agent, i.e. the host id, the uptime, the posts of the metrics,
+the last runs of the plugins and the states of the checkers, through the admin socket.
+*/
+func doStatus(fs *flag.FlagSet, argv []string) error {
+	conf, asJSON, err := resolveConfigForStatus(fs, argv)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+	status, err := command.FetchStatus(conf)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+	return command.WriteStatus(os.Stdout, status)
+}
+
+/*
+	 +command plugin - test a plugin
+
+		plugin test [-conf=mackerel-agent.conf] <name>
+
+run a metric plugin or a check plugin once exactly as the agent does, and display the result.
+The name is like "metrics.foo", "checks.bar" or just the name of the plugin.
+*/
+func doPlugin(fs *flag.FlagSet, argv []string) error {
+	if len(argv) == 0 || argv[0] != "test" {
+		return fmt.Errorf("usage: mackerel-agent plugin test [-conf=mackerel-agent.conf] <name>")
+	}
+	conf, err := resolveConfig(fs, argv[1:])
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mackerel-agent plugin test [-conf=mackerel-agent.conf] <name>")
+	}
+	return command.RunPluginTest(conf, fs.Arg(0), os.Stdout)
 }
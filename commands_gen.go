@@ -46,7 +46,7 @@ func init() {
 			Name:   "configtest",
 			Action: doConfigtest,
 			Short:  "configtest",
-			Long:   "configtest\n\ndo configtest",
+			Long:   "configtest [-json]\n\ntest the config file and the included files. It reports the unexpected keys and the semantic problems,\ne.g. the plugin commands not found in PATH, the invalid users and regular expressions,\nthe plugins overwritten by the included files and the values the agent adjusts.\nIt fails on the CRITICAL problems and the unexpected keys.",
 		},
 	)
 
@@ -64,7 +64,43 @@ func init() {
 			Name:   "once",
 			Action: doOnce,
 			Short:  "output onetime",
-			Long:   "once\n\noutput metrics and meta data of the host one time.\nThese data are only displayed and not posted to Mackerel.",
+			Long:   "once [-checks] [-metadata] [-format=json|table]\n\noutput metrics, graph definitions and meta data of the host one time,\nand the results of the check plugins and the metadata plugins if specified.\nThese data are only displayed and not posted to Mackerel.",
+		},
+	)
+
+	cli.Use(
+		&cli.Command{
+			Name:   "diagnose",
+			Action: doDiagnose,
+			Short:  "create a support bundle",
+			Long:   "diagnose [-output=xxx.tar.gz] [-log=xxx,yyy]\n\ncreate a tarball to troubleshoot the agent, which contains the config files with the secrets redacted,\nthe version of the agent and the OS, the result of configtest and once, the recent logs,\nthe binaries of the plugins, the connectivity to the API base and the timings of the generators.",
+		},
+	)
+
+	cli.Use(
+		&cli.Command{
+			Name:   "annotate",
+			Action: doAnnotate,
+			Short:  "post a graph annotation",
+			Long:   "annotate -title=xxx [-description=xxx] [-service=xxx [-roles=xxx,yyy]] [-from=xxx] [-to=xxx]\n\npost a graph annotation to the services and the roles of the host.\nThe time is specified in epoch seconds or RFC3339.",
+		},
+	)
+
+	cli.Use(
+		&cli.Command{
+			Name:   "status",
+			Action: doStatus,
+			Short:  "show the status of the running agent",
+			Long:   "status [-json]\n\nshow the status of the running agent, i.e. the host id, the uptime, the posts of the metrics,\nthe last runs of the plugins and the states of the checkers, through the admin socket.",
+		},
+	)
+
+	cli.Use(
+		&cli.Command{
+			Name:   "plugin",
+			Action: doPlugin,
+			Short:  "test a plugin",
+			Long:   "plugin test [-conf=mackerel-agent.conf] <name>\n\nrun a metric plugin or a check plugin once exactly as the agent does, and display the result.\nThe name is like \"metrics.foo\", \"checks.bar\" or just the name of the plugin.",
 		},
 	)
 }
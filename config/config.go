@@ -49,6 +49,10 @@ const (
 	CloudPlatformEC2
 	CloudPlatformGCE
 	CloudPlatformAzureVM
+	CloudPlatformOpenStack
+	CloudPlatformOracle
+	CloudPlatformAlibaba
+	CloudPlatformDigitalOcean
 )
 
 func (c CloudPlatform) String() string {
@@ -63,6 +67,14 @@ func (c CloudPlatform) String() string {
 		return "gce"
 	case CloudPlatformAzureVM:
 		return "azurevm"
+	case CloudPlatformOpenStack:
+		return "openstack"
+	case CloudPlatformOracle:
+		return "oracle"
+	case CloudPlatformAlibaba:
+		return "alibaba"
+	case CloudPlatformDigitalOcean:
+		return "digitalocean"
 	}
 	return ""
 }
@@ -85,6 +97,18 @@ func (c *CloudPlatform) UnmarshalText(text []byte) error {
 	case "azurevm":
 		*c = CloudPlatformAzureVM
 		return nil
+	case "openstack":
+		*c = CloudPlatformOpenStack
+		return nil
+	case "oracle":
+		*c = CloudPlatformOracle
+		return nil
+	case "alibaba":
+		*c = CloudPlatformAlibaba
+		return nil
+	case "digitalocean":
+		*c = CloudPlatformDigitalOcean
+		return nil
 	default:
 		*c = CloudPlatformNone // Avoid panic
 		return fmt.Errorf("failed to parse")
@@ -101,16 +125,35 @@ type Config struct {
 	Roles                []string
 	Verbose              bool
 	Silent               bool
-	Diagnostic           bool          `toml:"diagnostic"`
-	DisableHTTPKeepAlive bool          `toml:"disable_http_keep_alive"`
-	DisplayName          string        `toml:"display_name"`
-	HostStatus           HostStatus    `toml:"host_status" conf:"parent"`
-	Disks                Disks         `toml:"disks" conf:"parent"`
-	Filesystems          Filesystems   `toml:"filesystems" conf:"parent"`
-	Interfaces           Interfaces    `toml:"interfaces"  conf:"parent"`
-	HTTPProxy            string        `toml:"http_proxy"`
-	HTTPSProxy           string        `toml:"https_proxy"`
-	CloudPlatform        CloudPlatform `toml:"cloud_platform"`
+	Diagnostic           bool                `toml:"diagnostic"`
+	DisableHTTPKeepAlive bool                `toml:"disable_http_keep_alive"`
+	DisplayName          string              `toml:"display_name"`
+	HostStatus           HostStatus          `toml:"host_status" conf:"parent"`
+	Memory               Memory              `toml:"memory" conf:"parent"`
+	Disks                Disks               `toml:"disks" conf:"parent"`
+	Filesystems          Filesystems         `toml:"filesystems" conf:"parent"`
+	Interfaces           Interfaces          `toml:"interfaces"  conf:"parent"`
+	Processes            Processes           `toml:"processes" conf:"parent"`
+	Systemd              Systemd             `toml:"systemd" conf:"parent"`
+	KernelTables         KernelTables        `toml:"kernel_tables" conf:"parent"`
+	NFS                  NFS                 `toml:"nfs" conf:"parent"`
+	Sensors              Sensors             `toml:"sensors" conf:"parent"`
+	Container            Container           `toml:"container" conf:"parent"`
+	Kubernetes           Kubernetes          `toml:"kubernetes" conf:"parent"`
+	HTTPProxy            string              `toml:"http_proxy"`
+	HTTPSProxy           string              `toml:"https_proxy"`
+	CloudPlatform        CloudPlatform       `toml:"cloud_platform"`
+	CloudTags            CloudTags           `toml:"cloud_tags" conf:"parent"`
+	SpotInterruption     SpotInterruption    `toml:"spot_interruption" conf:"parent"`
+	HostIDStorageConfig  HostIDStorageConfig `toml:"host_id_storage" conf:"parent"`
+	Annotations          Annotations         `toml:"annotations" conf:"parent"`
+	Admin                Admin               `toml:"admin" conf:"parent"`
+
+	// Process groups whose resource usage is collected, keyed by the group names
+	ProcessGroups map[string]*ProcessGroup `toml:"process_group" conf:"parent"`
+
+	// Hosts of the custom_identifier of the plugins, keyed by the custom identifiers
+	CustomIdentifierHosts map[string]*CustomIdentifierHost `toml:"custom_identifier_host" conf:"parent"`
 
 	// This Plugin field is used to decode the toml file. After reading the
 	// configuration from file, this field is set to nil.
@@ -135,12 +178,15 @@ type PluginConfig struct {
 	ExecutionInterval     *duration     `toml:"execution_interval"`
 	MaxCheckAttempts      *int32        `toml:"max_check_attempts"`
 	CustomIdentifier      *string       `toml:"custom_identifier"`
+	Service               *string       `toml:"service"`
 	PreventAlertAutoClose bool          `toml:"prevent_alert_auto_close"`
 	IncludePattern        *string       `toml:"include_pattern"`
 	ExcludePattern        *string       `toml:"exclude_pattern"`
 	Action                CommandConfig `toml:"action" conf:"parent"`
 	Memo                  string        `toml:"memo"`
 	UsePluginTimestamp    bool          `toml:"use_plugin_timestamp"`
+	Builtin               string        `toml:"builtin"`
+	Targets               []string      `toml:"targets"`
 }
 
 // CommandConfig represents an executable command configuration.
@@ -211,6 +257,7 @@ func (cmd *Command) CommandString() string {
 type MetricPlugin struct {
 	Command            Command
 	CustomIdentifier   *string
+	Service            *string // post the metrics as the service metrics of the service
 	IncludePattern     *regexp.Regexp
 	ExcludePattern     *regexp.Regexp
 	UsePluginTimestamp bool
@@ -225,6 +272,15 @@ func (pconf *PluginConfig) buildMetricPlugin() (*MetricPlugin, error) {
 		return nil, fmt.Errorf("failed to parse plugin command. A configuration value of `command` should be string or string slice, but %T", pconf.Raw)
 	}
 
+	if pconf.Service != nil {
+		if *pconf.Service == "" {
+			return nil, fmt.Errorf("service should not be empty")
+		}
+		if pconf.CustomIdentifier != nil {
+			return nil, fmt.Errorf("service and custom_identifier cannot be specified at the same time")
+		}
+	}
+
 	var (
 		includePattern *regexp.Regexp
 		excludePattern *regexp.Regexp
@@ -245,6 +301,7 @@ func (pconf *PluginConfig) buildMetricPlugin() (*MetricPlugin, error) {
 	return &MetricPlugin{
 		Command:            *cmd,
 		CustomIdentifier:   pconf.CustomIdentifier,
+		Service:            pconf.Service,
 		IncludePattern:     includePattern,
 		ExcludePattern:     excludePattern,
 		UsePluginTimestamp: pconf.UsePluginTimestamp,
@@ -253,6 +310,9 @@ func (pconf *PluginConfig) buildMetricPlugin() (*MetricPlugin, error) {
 
 // CheckPlugin represents the configuration of a check plugin
 // The User option is ignored on Windows
+//
+// When Builtin is set, the check is performed inside the agent
+// instead of invoking Command. Targets are passed to the built-in check.
 type CheckPlugin struct {
 	Command               Command
 	CustomIdentifier      *string
@@ -262,6 +322,8 @@ type CheckPlugin struct {
 	PreventAlertAutoClose bool
 	Action                *Command
 	Memo                  string
+	Builtin               string
+	Targets               []string
 }
 
 func (pconf *PluginConfig) buildCheckPlugin(name string) (*CheckPlugin, error) {
@@ -269,6 +331,12 @@ func (pconf *PluginConfig) buildCheckPlugin(name string) (*CheckPlugin, error) {
 	if err != nil {
 		return nil, err
 	}
+	if pconf.Builtin != "" {
+		if cmd != nil {
+			return nil, fmt.Errorf("`command` and `builtin` cannot be specified at the same time")
+		}
+		cmd = &Command{}
+	}
 	if cmd == nil {
 		return nil, fmt.Errorf("failed to parse plugin command. A configuration value of `command` should be string or string slice, but %T", pconf.Raw)
 	}
@@ -301,6 +369,8 @@ func (pconf *PluginConfig) buildCheckPlugin(name string) (*CheckPlugin, error) {
 		PreventAlertAutoClose: pconf.PreventAlertAutoClose,
 		Action:                action,
 		Memo:                  pconf.Memo,
+		Builtin:               pconf.Builtin,
+		Targets:               pconf.Targets,
 	}
 	if plugin.MaxCheckAttempts != nil && *plugin.MaxCheckAttempts > 1 && plugin.PreventAlertAutoClose {
 		*plugin.MaxCheckAttempts = 1
@@ -373,17 +443,26 @@ var PostMetricsInterval = 1 * time.Minute
 type HostStatus struct {
 	OnStart string `toml:"on_start"`
 	OnStop  string `toml:"on_stop"`
+	// Retire the host instead of OnStop when the system is powered off or the cloud instance is terminated
+	RetireOnShutdown bool `toml:"retire_on_shutdown"`
+}
+
+// Memory configure memory related settings
+type Memory struct {
+	Detailed bool `toml:"detailed"`
 }
 
 // Disks configure disks related settings
 type Disks struct {
-	Ignore Regexpwrapper `toml:"ignore"`
+	Ignore   Regexpwrapper `toml:"ignore"`
+	Detailed bool          `toml:"detailed"`
 }
 
 // Filesystems configure filesystem related settings
 type Filesystems struct {
 	Ignore        Regexpwrapper `toml:"ignore"`
 	UseMountpoint bool          `toml:"use_mountpoint"`
+	Inodes        bool          `toml:"inodes"`
 }
 
 // Interfaces configure intefaces related settings
@@ -392,6 +471,129 @@ type Interfaces struct {
 	UseAdapterMetric bool          `toml:"use_adapter"`
 }
 
+// Processes configure process table related settings (Linux only)
+type Processes struct {
+	Enabled bool `toml:"enabled"`
+}
+
+// Systemd configures systemd unit related settings (Linux only)
+type Systemd struct {
+	Enabled bool     `toml:"enabled"`
+	Units   []string `toml:"units"`
+}
+
+// KernelTables configures the metrics of conntrack, neighbor table, entropy and softnet (Linux only)
+type KernelTables struct {
+	Enabled bool `toml:"enabled"`
+}
+
+// NFS configures NFS related settings (Linux only)
+// The mounts whose device or mountpoint matches Ignore are skipped.
+type NFS struct {
+	Enabled bool          `toml:"enabled"`
+	Ignore  Regexpwrapper `toml:"ignore"`
+}
+
+// Sensors configures hardware sensor and CPU frequency metrics (Linux only)
+type Sensors struct {
+	Enabled bool `toml:"enabled"`
+}
+
+// Container configures the metrics of the containers by the Docker Engine API (Linux only)
+// The running containers are also published as host metadata when Inventory is enabled.
+type Container struct {
+	Enabled   bool   `toml:"enabled"`
+	Socket    string `toml:"socket"`
+	Inventory bool   `toml:"inventory"`
+}
+
+// Kubernetes configures the integration with the kubelet of the node running the agent
+type Kubernetes struct {
+	Enabled            bool   `toml:"enabled"`
+	KubeletEndpoint    string `toml:"kubelet_endpoint"`
+	APIServerEndpoint  string `toml:"apiserver_endpoint"`
+	TokenFile          string `toml:"token_file"`
+	CAFile             string `toml:"ca_file"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	NodeName           string `toml:"node_name"`
+	// RoleService attaches the roles of node-role.kubernetes.io/{role} labels to the host as "{RoleService}:{role}"
+	RoleService string `toml:"role_service"`
+	// Metadata publishes the node labels and the pods as the host metadata "kubernetes"
+	Metadata bool `toml:"metadata"`
+	// NodeNameAsCustomIdentifier uses the node name as the custom identifier of the host
+	NodeNameAsCustomIdentifier bool `toml:"node_name_as_custom_identifier"`
+}
+
+// CloudTags configures the host attributes taken from the tags of the cloud instance (EC2 only)
+type CloudTags struct {
+	// Keys of the tags whose values are comma separated role fullnames like "service:role"
+	Roles []string `toml:"roles"`
+	// Key of the tag whose value is used as the display name
+	DisplayName string `toml:"display_name"`
+}
+
+// SpotInterruption configures the behavior on the interruption notices of spot instances (EC2 only)
+type SpotInterruption struct {
+	Enabled bool `toml:"enabled"`
+	// Host status switched to on the notice
+	Status string `toml:"status"`
+	// Retire the host on the notice
+	Retire bool `toml:"retire"`
+}
+
+// CustomIdentifierHost configures the host of a custom_identifier of the plugins
+type CustomIdentifierHost struct {
+	// Create the host when no host is registered with the custom identifier
+	Create bool `toml:"create"`
+	// Name of the host to create (default: the custom identifier)
+	Name        string   `toml:"name"`
+	Roles       []string `toml:"roles"`
+	DisplayName string   `toml:"display_name"`
+}
+
+// Annotations configures the graph annotations posted on the events of the agent,
+// i.e. start, stop, reload of the configuration, changes of the plugins and the host specs.
+type Annotations struct {
+	Enabled bool `toml:"enabled"`
+	// Service of the annotations (default: the services of the roles of the host)
+	Service string `toml:"service"`
+	// Names of the roles in Service to which the annotations are scoped (default: the whole service)
+	Roles []string `toml:"roles"`
+}
+
+// Admin configures the local admin socket to which the status subcommand connects
+type Admin struct {
+	Disabled bool `toml:"disabled"`
+	// Path of the unix domain socket (default: mackerel-agent.sock under root)
+	Socket string `toml:"socket"`
+}
+
+const defaultAdminSocket = "mackerel-agent.sock"
+
+// AdminSocketPath returns the path of the admin socket.
+func (conf *Config) AdminSocketPath() string {
+	if conf.Admin.Socket != "" {
+		return conf.Admin.Socket
+	}
+	return filepath.Join(conf.Root, defaultAdminSocket)
+}
+
+// ProcessGroup configures a group of processes whose resource usage is aggregated (Linux only).
+// A process belongs to the group when it matches any of Name, Cmdline, Pidfile and SystemdUnit.
+type ProcessGroup struct {
+	// Name matches the command name in /proc/{pid}/comm exactly.
+	Name string `toml:"name"`
+	// Cmdline matches the command line joined with spaces.
+	Cmdline Regexpwrapper `toml:"cmdline"`
+	// Pidfile contains the pid of the process.
+	Pidfile string `toml:"pidfile"`
+	// SystemdUnit matches the processes in the cgroup of the unit. ".service" is assumed without suffix.
+	SystemdUnit string `toml:"systemd_unit"`
+	// MinInstances and MaxInstances are used by the "process_count" builtin check.
+	MinInstances *int `toml:"min_instances"`
+	MaxInstances *int `toml:"max_instances"`
+}
+
 // Regexpwrapper is a wrapper type for marshalling string
 type Regexpwrapper struct {
 	*regexp.Regexp
@@ -508,9 +710,23 @@ func loadConfigFile(file string) (*Config, error) {
 		}
 	}
 
+	if err := config.validateProcessGroups(); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
+// validateProcessGroups rejects the process groups which match no processes.
+func (conf *Config) validateProcessGroups() error {
+	for name, group := range conf.ProcessGroups {
+		if group.Name == "" && group.Cmdline.Regexp == nil && group.Pidfile == "" && group.SystemdUnit == "" {
+			return fmt.Errorf("process_group.%s: any of name, cmdline, pidfile and systemd_unit should be specified", name)
+		}
+	}
+	return nil
+}
+
 func includeConfigFile(config *Config, include string) error {
 	files, err := filepath.Glob(include)
 	if err != nil {
@@ -546,7 +762,7 @@ func includeConfigFile(config *Config, include string) error {
 
 func (conf *Config) hostIDStorage() HostIDStorage {
 	if conf.HostIDStorage == nil {
-		conf.HostIDStorage = &FileSystemHostIDStorage{Root: conf.Root}
+		conf.HostIDStorage = conf.NewHostIDStorage(nil)
 	}
 	return conf.HostIDStorage
 }
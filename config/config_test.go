@@ -20,6 +20,42 @@ diagnostic = true
 [filesystems]
 ignore = "/dev/ram.*"
 
+[memory]
+detailed = true
+
+[disks]
+detailed = true
+
+[processes]
+enabled = true
+
+[kernel_tables]
+enabled = true
+
+[nfs]
+enabled = true
+ignore = "^backup:"
+
+[sensors]
+enabled = true
+
+[container]
+enabled = true
+socket = "/run/podman/podman.sock"
+inventory = true
+
+[kubernetes]
+enabled = true
+kubelet_endpoint = "https://10.0.0.1:10250"
+insecure_skip_verify = true
+role_service = "k8s"
+metadata = true
+node_name_as_custom_identifier = true
+
+[systemd]
+enabled = true
+units = ["nginx", "cron.service"]
+
 [plugin.metrics.mysql]
 command = "ruby /path/to/your/plugin/mysql.rb"
 user = "mysql"
@@ -101,6 +137,42 @@ func TestLoadConfig(t *testing.T) {
 		t.Error("should be false (default value should be used)")
 	}
 
+	if config.Memory.Detailed != true {
+		t.Error("memory.detailed should be true (config value should be used)")
+	}
+
+	if config.Disks.Detailed != true {
+		t.Error("disks.detailed should be true (config value should be used)")
+	}
+
+	if config.Processes.Enabled != true {
+		t.Error("processes.enabled should be true (config value should be used)")
+	}
+
+	if config.KernelTables.Enabled != true {
+		t.Error("kernel_tables.enabled should be true (config value should be used)")
+	}
+
+	if !config.NFS.Enabled || config.NFS.Ignore.String() != "^backup:" {
+		t.Errorf("nfs should be configured: %+v", config.NFS)
+	}
+
+	if config.Sensors.Enabled != true {
+		t.Error("sensors.enabled should be true (config value should be used)")
+	}
+
+	if expect := (Container{Enabled: true, Socket: "/run/podman/podman.sock", Inventory: true}); config.Container != expect {
+		t.Errorf("container should be %+v but %+v", expect, config.Container)
+	}
+
+	if k := config.Kubernetes; !k.Enabled || k.KubeletEndpoint != "https://10.0.0.1:10250" || !k.InsecureSkipVerify || k.RoleService != "k8s" || !k.Metadata || !k.NodeNameAsCustomIdentifier {
+		t.Errorf("kubernetes should be configured: %+v", k)
+	}
+
+	if !config.Systemd.Enabled || !reflect.DeepEqual(config.Systemd.Units, []string{"nginx", "cron.service"}) {
+		t.Errorf("systemd should be configured: %+v", config.Systemd)
+	}
+
 	if config.DisableHTTPKeepAlive != false {
 		t.Error("should be false (default value should be used)")
 	}
@@ -113,6 +185,7 @@ display_name = "fghij"
 [host_status]
 on_start = "working"
 on_stop  = "poweroff"
+retire_on_shutdown = true
 `
 
 func TestLoadConfigWithHostStatus(t *testing.T) {
@@ -142,6 +215,143 @@ func TestLoadConfigWithHostStatus(t *testing.T) {
 	if config.HostStatus.OnStop != "poweroff" {
 		t.Error(`HostStatus.OnStop should be "poweroff"`)
 	}
+
+	if !config.HostStatus.RetireOnShutdown {
+		t.Error("HostStatus.RetireOnShutdown should be true")
+	}
+}
+
+var sampleConfigWithCloudTags = `
+apikey = "abcde"
+
+[cloud_tags]
+roles = ["mackerel-roles"]
+display_name = "Name"
+
+[spot_interruption]
+enabled = true
+status = "maintenance"
+retire = true
+`
+
+func TestLoadConfigWithCloudTags(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(sampleConfigWithCloudTags)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+
+	if !reflect.DeepEqual(config.CloudTags.Roles, []string{"mackerel-roles"}) {
+		t.Errorf(`CloudTags.Roles should be ["mackerel-roles"] but %v`, config.CloudTags.Roles)
+	}
+
+	if config.CloudTags.DisplayName != "Name" {
+		t.Errorf(`CloudTags.DisplayName should be "Name" but %q`, config.CloudTags.DisplayName)
+	}
+
+	if !config.SpotInterruption.Enabled || config.SpotInterruption.Status != "maintenance" || !config.SpotInterruption.Retire {
+		t.Errorf("unexpected SpotInterruption: %+v", config.SpotInterruption)
+	}
+}
+
+func TestLoadConfigWithCustomIdentifierHost(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(`
+apikey = "abcde"
+
+[plugin.metrics.db]
+command = "mackerel-plugin-mysql"
+custom_identifier = "db.example.com"
+
+[custom_identifier_host."db.example.com"]
+create = true
+roles = ["db:main"]
+display_name = "DB"
+`)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+
+	host, ok := config.CustomIdentifierHosts["db.example.com"]
+	if !ok {
+		t.Fatal(`custom_identifier_host "db.example.com" should be loaded`)
+	}
+	if !host.Create || host.Name != "" || !reflect.DeepEqual(host.Roles, []string{"db:main"}) || host.DisplayName != "DB" {
+		t.Errorf("unexpected custom_identifier_host: %+v", host)
+	}
+}
+
+func TestLoadConfigWithAnnotations(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(`
+apikey = "abcde"
+
+[annotations]
+enabled = true
+service = "web"
+roles = ["app", "batch"]
+`)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+
+	if !config.Annotations.Enabled || config.Annotations.Service != "web" || !reflect.DeepEqual(config.Annotations.Roles, []string{"app", "batch"}) {
+		t.Errorf("unexpected annotations: %+v", config.Annotations)
+	}
+}
+
+func TestLoadConfigWithServiceMetricPlugin(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(`
+apikey = "abcde"
+
+[plugin.metrics.kpi]
+command = "/usr/local/bin/kpi.sh"
+service = "shop"
+use_plugin_timestamp = true
+`)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("should not raise error: %v", err)
+	}
+	plugin := config.MetricPlugins["kpi"]
+	if plugin.Service == nil || *plugin.Service != "shop" || !plugin.UsePluginTimestamp {
+		t.Errorf("unexpected metric plugin: %+v", plugin)
+	}
+
+	tmpFile, err = newTempFileWithContent(`
+[plugin.metrics.kpi]
+command = "/usr/local/bin/kpi.sh"
+service = "shop"
+custom_identifier = "app.example.com"
+`)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	if _, err := LoadConfig(tmpFile.Name()); err == nil {
+		t.Errorf("service and custom_identifier should not be specified at the same time")
+	}
 }
 
 var sampleConfigWithMountPoint = `
@@ -150,6 +360,7 @@ display_name = "fghij"
 
 [filesystems]
 use_mountpoint = true
+inodes = true
 `
 
 func TestLoadConfigWithMountPoint(t *testing.T) {
@@ -167,6 +378,10 @@ func TestLoadConfigWithMountPoint(t *testing.T) {
 	if config.Filesystems.UseMountpoint != true {
 		t.Error("should be true (config value should be used)")
 	}
+
+	if config.Filesystems.Inodes != true {
+		t.Error("inodes should be true (config value should be used)")
+	}
 }
 
 var sampleConfigWithInvalidIgnoreRegexp = `
@@ -262,6 +477,115 @@ func TestLoadConfigWithInvalidCheckCommand(t *testing.T) {
 	}
 }
 
+var sampleConfigWithBuiltinCheck = `
+apikey = "abcde"
+
+[plugin.checks.readonly]
+builtin = "filesystem_readonly"
+targets = ["/", "/data"]
+`
+
+func TestLoadConfigWithBuiltinCheck(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(sampleConfigWithBuiltinCheck)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("should not raise error: %v", err)
+	}
+
+	check := config.CheckPlugins["readonly"]
+	if check.Builtin != "filesystem_readonly" {
+		t.Errorf("builtin should be 'filesystem_readonly': %v", check.Builtin)
+	}
+	if !reflect.DeepEqual(check.Targets, []string{"/", "/data"}) {
+		t.Errorf("unexpected targets: %v", check.Targets)
+	}
+}
+
+var sampleConfigWithBuiltinCheckAndCommand = `
+apikey = "abcde"
+
+[plugin.checks.readonly]
+command = "check-readonly"
+builtin = "filesystem_readonly"
+`
+
+func TestLoadConfigWithBuiltinCheckAndCommand(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(sampleConfigWithBuiltinCheckAndCommand)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	_, err = LoadConfig(tmpFile.Name())
+	if err == nil {
+		t.Errorf("should raise error: both command and builtin are specified.")
+	}
+}
+
+var sampleConfigWithProcessGroups = `
+apikey = "abcde"
+
+[process_group.nginx]
+name = "nginx"
+systemd_unit = "nginx.service"
+min_instances = 2
+
+[process_group.app]
+cmdline = '^ruby .*app\.rb'
+pidfile = "/run/app.pid"
+
+[plugin.checks.processes]
+builtin = "process_count"
+`
+
+func TestLoadConfigWithProcessGroups(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(sampleConfigWithProcessGroups)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("should not raise error: %v", err)
+	}
+
+	nginx := config.ProcessGroups["nginx"]
+	if nginx.Name != "nginx" || nginx.SystemdUnit != "nginx.service" || *nginx.MinInstances != 2 {
+		t.Errorf("unexpected process group: %+v", nginx)
+	}
+	app := config.ProcessGroups["app"]
+	if !app.Cmdline.MatchString("ruby /srv/app.rb") || app.Pidfile != "/run/app.pid" {
+		t.Errorf("unexpected process group: %+v", app)
+	}
+	if config.CheckPlugins["processes"].Builtin != "process_count" {
+		t.Errorf("builtin check should be loaded: %+v", config.CheckPlugins["processes"])
+	}
+}
+
+func TestLoadConfigWithEmptyProcessGroup(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(`
+apikey = "abcde"
+
+[process_group.empty]
+min_instances = 1
+`)
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	_, err = LoadConfig(tmpFile.Name())
+	if err == nil || !strings.Contains(err.Error(), "process_group.empty") {
+		t.Errorf("an empty process group should be rejected but %v", err)
+	}
+}
+
 var sampleConfigWithTooLargeCheckMemo = `
 apikey = "abcde"
 
@@ -348,6 +672,10 @@ var LoadConfigWithCloudPlatformTests = []struct {
 	{"ec2", CloudPlatformEC2},
 	{"gce", CloudPlatformGCE},
 	{"azurevm", CloudPlatformAzureVM},
+	{"openstack", CloudPlatformOpenStack},
+	{"oracle", CloudPlatformOracle},
+	{"alibaba", CloudPlatformAlibaba},
+	{"digitalocean", CloudPlatformDigitalOcean},
 }
 
 func TestLoadConfigWithCloudPlatform(t *testing.T) {
@@ -908,3 +1236,14 @@ func newTempFileWithContent(content string) (*os.File, error) {
 	tmpf.Close()
 	return tmpf, nil
 }
+
+func TestConfig_AdminSocketPath(t *testing.T) {
+	conf := Config{Root: "/var/lib/mackerel-agent"}
+	if p := conf.AdminSocketPath(); p != filepath.Join("/var/lib/mackerel-agent", "mackerel-agent.sock") {
+		t.Errorf("the admin socket should be under the root by default but %s", p)
+	}
+	conf.Admin.Socket = "/run/mackerel-agent.sock"
+	if p := conf.AdminSocketPath(); p != "/run/mackerel-agent.sock" {
+		t.Errorf("the admin socket should be /run/mackerel-agent.sock but %s", p)
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// HostIDStorageBackend is an enum to represent where the host id is stored.
+type HostIDStorageBackend int
+
+// HostIDStorageBackend enum values
+const (
+	HostIDStorageBackendFile HostIDStorageBackend = iota
+	HostIDStorageBackendEnv
+	HostIDStorageBackendMountedFile
+	HostIDStorageBackendCloudTag
+)
+
+func (b HostIDStorageBackend) String() string {
+	switch b {
+	case HostIDStorageBackendFile:
+		return "file"
+	case HostIDStorageBackendEnv:
+		return "env"
+	case HostIDStorageBackendMountedFile:
+		return "mounted_file"
+	case HostIDStorageBackendCloudTag:
+		return "cloud_tag"
+	}
+	return ""
+}
+
+// UnmarshalText is used by toml unmarshaller
+func (b *HostIDStorageBackend) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "file", "":
+		*b = HostIDStorageBackendFile
+	case "env":
+		*b = HostIDStorageBackendEnv
+	case "mounted_file":
+		*b = HostIDStorageBackendMountedFile
+	case "cloud_tag":
+		*b = HostIDStorageBackendCloudTag
+	default:
+		*b = HostIDStorageBackendFile // Avoid panic
+		return fmt.Errorf("failed to parse")
+	}
+	return nil
+}
+
+// HostIDStorageConfig configures where the host id is loaded from.
+// The backends other than "file" are for the immutable images and the containers
+// whose root filesystem is read-only, and the host id is provisioned in advance.
+type HostIDStorageConfig struct {
+	Backend HostIDStorageBackend `toml:"backend"`
+	// Name of the environment variable for "env" backend (default: MACKEREL_HOST_ID)
+	Env string `toml:"env"`
+	// Path of the file for "mounted_file" backend, e.g. mounted from a ConfigMap or a Secret of Kubernetes
+	File string `toml:"file"`
+	// Key of the tag of the instance for "cloud_tag" backend (default: mackerel-host-id)
+	Tag string `toml:"tag"`
+}
+
+const (
+	defaultHostIDEnv = "MACKEREL_HOST_ID"
+	defaultHostIDTag = "mackerel-host-id"
+)
+
+// NewHostIDStorage creates the HostIDStorage of the configured backend.
+// loadCloudTag loads the value of the tag of the cloud instance for "cloud_tag" backend.
+// It is given by the caller not to import the spec package from config, and may be nil if unsupported.
+func (conf *Config) NewHostIDStorage(loadCloudTag func(key string) (string, error)) HostIDStorage {
+	c := conf.HostIDStorageConfig
+	switch c.Backend {
+	case HostIDStorageBackendEnv:
+		name := c.Env
+		if name == "" {
+			name = defaultHostIDEnv
+		}
+		return &ProvisionedHostIDStorage{
+			Source: fmt.Sprintf("the environment variable %s", name),
+			Load: func() (string, error) {
+				return strings.TrimSpace(os.Getenv(name)), nil
+			},
+		}
+	case HostIDStorageBackendMountedFile:
+		return &ProvisionedHostIDStorage{
+			Source: fmt.Sprintf("the file %s", c.File),
+			Load: func() (string, error) {
+				if c.File == "" {
+					return "", fmt.Errorf("file of host_id_storage is not specified")
+				}
+				content, err := os.ReadFile(c.File)
+				if err != nil {
+					return "", err
+				}
+				return strings.TrimSpace(string(content)), nil
+			},
+		}
+	case HostIDStorageBackendCloudTag:
+		key := c.Tag
+		if key == "" {
+			key = defaultHostIDTag
+		}
+		// The tag is cached once loaded not to request the metadata service every time.
+		var mu sync.Mutex
+		var loaded string
+		return &ProvisionedHostIDStorage{
+			Source: fmt.Sprintf("the tag %s of the instance", key),
+			Load: func() (string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if loaded != "" {
+					return loaded, nil
+				}
+				if loadCloudTag == nil {
+					return "", fmt.Errorf("cloud_tag is not supported")
+				}
+				hostID, err := loadCloudTag(key)
+				if err == nil {
+					loaded = hostID
+				}
+				return hostID, err
+			},
+		}
+	}
+	return &FileSystemHostIDStorage{Root: conf.Root}
+}
+
+// ProvisionedHostIDStorage is a HostIDStorage which loads the host id provisioned in advance by Load.
+// The agent cannot write the host id to the source, so the id of the newly registered host
+// is kept only in memory and the host is going to be registered again on the next start
+// unless the id is provisioned.
+type ProvisionedHostIDStorage struct {
+	Source string // describes where the host id is provisioned
+	Load   func() (string, error)
+
+	mu    sync.Mutex
+	saved string
+}
+
+// LoadHostID loads the provisioned host id, or the one saved in memory.
+func (s *ProvisionedHostIDStorage) LoadHostID() (string, error) {
+	hostID, err := s.Load()
+	if err == nil && hostID != "" {
+		return hostID, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saved != "" {
+		return s.saved, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load the host id from %s: %w", s.Source, err)
+	}
+	return "", fmt.Errorf("the host id is not provisioned in %s", s.Source)
+}
+
+// SaveHostID keeps the host id in memory when it is not the provisioned one.
+func (s *ProvisionedHostIDStorage) SaveHostID(id string) error {
+	if hostID, err := s.Load(); err == nil && hostID == id {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saved != id {
+		configLogger.Warningf("The host id %s cannot be saved to %s. Provision it not to register this host again on the next start.", id, s.Source)
+	}
+	s.saved = id
+	return nil
+}
+
+// DeleteSavedHostID forgets the host id saved in memory.
+func (s *ProvisionedHostIDStorage) DeleteSavedHostID() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = ""
+	if hostID, err := s.Load(); err == nil && hostID != "" {
+		configLogger.Warningf("The host id %s cannot be deleted from %s. Remove it not to use the host again.", hostID, s.Source)
+	}
+	return nil
+}
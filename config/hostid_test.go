@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigWithHostIDStorage(t *testing.T) {
+	tmpFile, err := newTempFileWithContent(`
+apikey = "abcde"
+
+[host_id_storage]
+backend = "mounted_file"
+file = "/etc/mackerel-agent/host-id/id"
+`)
+	if err != nil {
+		t.Fatalf("should not raise error: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	config, err := LoadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("should not raise error: %v", err)
+	}
+	if config.HostIDStorageConfig.Backend != HostIDStorageBackendMountedFile {
+		t.Errorf("backend should be mounted_file but %s", config.HostIDStorageConfig.Backend)
+	}
+	if config.HostIDStorageConfig.File != "/etc/mackerel-agent/host-id/id" {
+		t.Errorf("unexpected file: %s", config.HostIDStorageConfig.File)
+	}
+
+	var backend HostIDStorageBackend
+	if err := backend.UnmarshalText([]byte("configmap")); err == nil {
+		t.Errorf("should raise error for the unknown backend")
+	}
+}
+
+func TestConfig_HostIDStorageBackends(t *testing.T) {
+	tests := []struct {
+		backend HostIDStorageBackend
+		file    bool
+	}{
+		{HostIDStorageBackendFile, true},
+		{HostIDStorageBackendEnv, false},
+		{HostIDStorageBackendMountedFile, false},
+		{HostIDStorageBackendCloudTag, false},
+	}
+	for _, tt := range tests {
+		conf := Config{Root: "test-root", HostIDStorageConfig: HostIDStorageConfig{Backend: tt.backend}}
+		_, ok := conf.hostIDStorage().(*FileSystemHostIDStorage)
+		assert(t, ok == tt.file, "FileSystemHostIDStorage should be used only for file backend: "+tt.backend.String())
+	}
+}
+
+func TestProvisionedHostIDStorage_Env(t *testing.T) {
+	t.Setenv("TEST_MACKEREL_HOST_ID", "")
+	conf := Config{HostIDStorageConfig: HostIDStorageConfig{Backend: HostIDStorageBackendEnv, Env: "TEST_MACKEREL_HOST_ID"}}
+
+	_, err := conf.LoadHostID()
+	assert(t, err != nil, "LoadHostID must fail when the host id is not provisioned")
+
+	// The host is registered newly
+	assertNoError(t, conf.SaveHostID("new-host-id"))
+	hostID, err := conf.LoadHostID()
+	assertNoError(t, err)
+	assert(t, hostID == "new-host-id", "the saved host id should be loaded until the agent stops")
+
+	assertNoError(t, conf.DeleteSavedHostID())
+	_, err = conf.LoadHostID()
+	assert(t, err != nil, "LoadHostID after DeleteSavedHostID must fail")
+
+	t.Setenv("TEST_MACKEREL_HOST_ID", "provisioned-host-id\n")
+	hostID, err = conf.LoadHostID()
+	assertNoError(t, err)
+	assert(t, hostID == "provisioned-host-id", "the provisioned host id should be loaded")
+	assertNoError(t, conf.SaveHostID("provisioned-host-id"))
+}
+
+func TestProvisionedHostIDStorage_MountedFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "id")
+	conf := Config{HostIDStorageConfig: HostIDStorageConfig{Backend: HostIDStorageBackendMountedFile, File: file}}
+
+	_, err := conf.LoadHostID()
+	assert(t, err != nil, "LoadHostID must fail when the file does not exist")
+
+	assertNoError(t, os.WriteFile(file, []byte("provisioned-host-id\n"), 0644))
+	hostID, err := conf.LoadHostID()
+	assertNoError(t, err)
+	assert(t, hostID == "provisioned-host-id", "the provisioned host id should be loaded")
+
+	assertNoError(t, conf.SaveHostID("provisioned-host-id"))
+	content, err := os.ReadFile(file)
+	assertNoError(t, err)
+	assert(t, string(content) == "provisioned-host-id\n", "the mounted file must not be written")
+}
+
+func TestProvisionedHostIDStorage_CloudTag(t *testing.T) {
+	var loadedKey string
+	loadCount := 0
+	conf := Config{HostIDStorageConfig: HostIDStorageConfig{Backend: HostIDStorageBackendCloudTag}}
+	conf.HostIDStorage = conf.NewHostIDStorage(func(key string) (string, error) {
+		loadedKey = key
+		loadCount++
+		return "tagged-host-id", nil
+	})
+	hostID, err := conf.LoadHostID()
+	assertNoError(t, err)
+	assert(t, hostID == "tagged-host-id", "the host id should be loaded from the tag")
+	assert(t, loadedKey == "mackerel-host-id", "the default tag should be mackerel-host-id")
+	assertNoError(t, conf.SaveHostID("tagged-host-id"))
+	assert(t, loadCount == 1, "the tag should be cached once loaded")
+
+	conf.HostIDStorage = conf.NewHostIDStorage(func(key string) (string, error) {
+		return "", errors.New("not running on cloud")
+	})
+	_, err = conf.LoadHostID()
+	assert(t, err != nil, "LoadHostID must fail when the tag cannot be loaded")
+
+	conf.HostIDStorage = conf.NewHostIDStorage(nil)
+	_, err = conf.LoadHostID()
+	assert(t, err != nil, "LoadHostID must fail when cloud_tag is not supported")
+}
@@ -2,11 +2,18 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"os/exec"
+	"os/user"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"slices"
 	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/BurntSushi/toml"
 	"github.com/agext/levenshtein"
@@ -173,3 +180,156 @@ func ValidateConfigFile(file string) ([]UnexpectedKey, error) {
 
 	return unexpectedKeys, nil
 }
+
+// Severity is the severity of a Problem.
+type Severity string
+
+// Severities of the problems, in the same words as the check monitoring.
+const (
+	SeverityCritical Severity = "CRITICAL" // the agent fails to load the config
+	SeverityWarning  Severity = "WARNING"  // the agent loads the config but does not work as written
+)
+
+// Problem represents a semantic problem of the config found by CheckConfigFile.
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Key      string   `json:"key,omitempty"`
+	File     string   `json:"file"`
+	Message  string   `json:"message"`
+}
+
+var pluginKinds = []string{"metrics", "checks", "metadata"}
+
+// shellKeywords are the first words of the shell commands which are not executables.
+var shellKeywords = []string{".", "case", "cd", "eval", "exec", "exit", "export", "for", "if", "set", "source", "ulimit", "while"}
+
+// CheckConfigFile detects the semantic problems of the config file and the included files,
+// which the unexpected keys do not tell, such as the plugin commands not found
+// and the values the agent silently adjusts.
+func CheckConfigFile(file string) ([]Problem, error) {
+	conf := &Config{}
+	if _, err := toml.DecodeFile(file, conf); err != nil {
+		return nil, fmt.Errorf("failed to test config: %s", err)
+	}
+	files := []string{file}
+	if conf.Include != "" {
+		included, err := filepath.Glob(conf.Include)
+		if err != nil {
+			return nil, fmt.Errorf("failed to test config: %s", err)
+		}
+		files = append(files, included...)
+	}
+
+	var problems []Problem
+	definedIn := make(map[string]string)
+	for i, f := range files {
+		if i > 0 {
+			conf = &Config{}
+			if _, err := toml.DecodeFile(f, conf); err != nil {
+				problems = append(problems, Problem{SeverityCritical, "", f, fmt.Sprintf("failed to load the included config file: %s", err)})
+				continue
+			}
+		}
+		for _, kind := range pluginKinds {
+			for _, name := range slices.Sorted(maps.Keys(conf.Plugin[kind])) {
+				key := "plugin." + kind + "." + name
+				if prev, ok := definedIn[key]; ok {
+					problems = append(problems, Problem{SeverityWarning, key, f, fmt.Sprintf("%s overwrites the one defined in %s", key, prev)})
+				}
+				definedIn[key] = f
+				for _, p := range checkPluginConfig(kind, key, conf.Plugin[kind][name]) {
+					p.File = f
+					problems = append(problems, p)
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+func checkPluginConfig(kind, key string, pconf *PluginConfig) []Problem {
+	var problems []Problem
+	if kind != "checks" || pconf.Builtin == "" {
+		problems = append(problems, checkCommandConfig(key, pconf.CommandConfig)...)
+	}
+	switch kind {
+	case "metrics":
+		patterns := []struct {
+			name    string
+			pattern *string
+		}{
+			{"include_pattern", pconf.IncludePattern},
+			{"exclude_pattern", pconf.ExcludePattern},
+		}
+		for _, p := range patterns {
+			if p.pattern == nil {
+				continue
+			}
+			if _, err := regexp.Compile(*p.pattern); err != nil {
+				problems = append(problems, Problem{SeverityCritical, key + "." + p.name, "", fmt.Sprintf("%s.%s is an invalid regular expression: %s", key, p.name, err)})
+			}
+		}
+	case "checks":
+		if pconf.Action.Raw != nil {
+			problems = append(problems, checkCommandConfig(key+".action", pconf.Action)...)
+		}
+		if pconf.PreventAlertAutoClose && pconf.MaxCheckAttempts != nil && *pconf.MaxCheckAttempts > 1 {
+			problems = append(problems, Problem{SeverityWarning, key + ".max_check_attempts", "", fmt.Sprintf("%s.max_check_attempts is set to 1 because it is unavailable with prevent_alert_auto_close", key)})
+		}
+		if v := pconf.CheckInterval.Minutes(); v != nil && (*v < 1 || *v > 60) {
+			problems = append(problems, Problem{SeverityWarning, key + ".check_interval", "", fmt.Sprintf("%s.check_interval is %d minutes, but is clamped between 1 and 60 minutes", key, *v)})
+		}
+		if v := pconf.NotificationInterval.Minutes(); v != nil && *v < 10 {
+			problems = append(problems, Problem{SeverityWarning, key + ".notification_interval", "", fmt.Sprintf("%s.notification_interval is %d minutes, but is treated as 10 minutes", key, *v)})
+		}
+		if utf8.RuneCountInString(pconf.Memo) > 250 {
+			problems = append(problems, Problem{SeverityWarning, key + ".memo", "", fmt.Sprintf("%s.memo exceeds 250 characters, and is truncated", key)})
+		}
+	case "metadata":
+		if v := pconf.ExecutionInterval.Minutes(); v != nil && *v < 10 {
+			problems = append(problems, Problem{SeverityWarning, key + ".execution_interval", "", fmt.Sprintf("%s.execution_interval is %d minutes, but is treated as 10 minutes", key, *v)})
+		}
+	}
+	return problems
+}
+
+func checkCommandConfig(key string, cc CommandConfig) []Problem {
+	cmd, err := cc.parse()
+	if err == nil && cmd == nil {
+		err = fmt.Errorf("`command` is not specified")
+	}
+	if err != nil {
+		return []Problem{{SeverityCritical, key + ".command", "", fmt.Sprintf("%s.command is invalid: %s", key, err)}}
+	}
+
+	var problems []Problem
+	if bin := commandExecutable(cmd); bin != "" {
+		if _, err := exec.LookPath(bin); err != nil {
+			problems = append(problems, Problem{SeverityWarning, key + ".command", "", fmt.Sprintf("%s.command %q is not found in PATH", key, bin)})
+		}
+	}
+	if cmd.User != "" {
+		if runtime.GOOS == "windows" {
+			problems = append(problems, Problem{SeverityWarning, key + ".user", "", fmt.Sprintf("%s.user is ignored on Windows", key)})
+		} else if _, err := user.Lookup(cmd.User); err != nil {
+			problems = append(problems, Problem{SeverityWarning, key + ".user", "", fmt.Sprintf("%s.user is invalid: %s", key, err)})
+		}
+	}
+	return problems
+}
+
+// commandExecutable returns the executable of the command, or "" if it cannot be told without running the shell.
+func commandExecutable(cmd *Command) string {
+	if len(cmd.Args) > 0 {
+		return cmd.Args[0]
+	}
+	if runtime.GOOS == "windows" {
+		// The builtin commands of cmd.exe are not executables.
+		return ""
+	}
+	fields := strings.Fields(cmd.Cmd)
+	if len(fields) == 0 || strings.ContainsAny(fields[0], "=$\"'`(){};&|<>") || slices.Contains(shellKeywords, fields[0]) {
+		return ""
+	}
+	return fields[0]
+}
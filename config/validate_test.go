@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -96,3 +99,106 @@ func TestValidateConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckConfigFile(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mackerel-agent.conf")
+	included := filepath.Join(dir, "conf.d", "foo.conf")
+	err = os.WriteFile(file, []byte(fmt.Sprintf(`
+apikey = "abcde"
+include = '%s'
+
+[plugin.metrics.foo]
+command = ['%s']
+include_pattern = "foo("
+exclude_pattern = "bar"
+
+[plugin.metrics.bar]
+command = ["no-such-command", "--verbose"]
+
+[plugin.checks.foo]
+command = ['%s']
+prevent_alert_auto_close = true
+max_check_attempts = 3
+check_interval = 90
+notification_interval = 5
+
+[plugin.checks.builtin]
+builtin = "ok"
+
+[plugin.metadata.foo]
+command = ['%s']
+execution_interval = 1
+`, filepath.Join(dir, "conf.d", "*.conf"), exe, exe, exe)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Dir(included), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(included, []byte(fmt.Sprintf(`
+[plugin.metrics.foo]
+command = ['%s']
+
+[plugin.checks.bar]
+command = 1
+`, exe)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := CheckConfigFile(file)
+	if err != nil {
+		t.Fatalf("should not raise error: %v", err)
+	}
+
+	want := []struct {
+		severity Severity
+		key      string
+		file     string
+	}{
+		{SeverityWarning, "plugin.metrics.bar.command", file},
+		{SeverityCritical, "plugin.metrics.foo.include_pattern", file},
+		{SeverityWarning, "plugin.checks.foo.max_check_attempts", file},
+		{SeverityWarning, "plugin.checks.foo.check_interval", file},
+		{SeverityWarning, "plugin.checks.foo.notification_interval", file},
+		{SeverityWarning, "plugin.metadata.foo.execution_interval", file},
+		{SeverityWarning, "plugin.metrics.foo", included},
+		{SeverityCritical, "plugin.checks.bar.command", included},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("%d problems should be found but %+v", len(want), problems)
+	}
+	for i, p := range problems {
+		if p.Severity != want[i].severity || p.Key != want[i].key || p.File != want[i].file {
+			t.Errorf("problem should be %+v but %+v", want[i], p)
+		}
+	}
+}
+
+func TestCommandExecutable(t *testing.T) {
+	tests := []struct {
+		cmd  Command
+		want string
+	}{
+		{Command{Args: []string{"/usr/bin/foo", "-x"}}, "/usr/bin/foo"},
+		{Command{Cmd: "mackerel-plugin-foo -x | grep bar"}, "mackerel-plugin-foo"},
+		{Command{Cmd: "FOO=bar mackerel-plugin-foo"}, ""},
+		{Command{Cmd: "exec mackerel-plugin-foo"}, ""},
+		{Command{Cmd: "'/path/to/foo bar'"}, ""},
+		{Command{Cmd: ""}, ""},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if runtime.GOOS == "windows" && len(tt.cmd.Args) == 0 {
+			want = ""
+		}
+		if got := commandExecutable(&tt.cmd); got != want {
+			t.Errorf("commandExecutable(%+v) should be %q but %q", tt.cmd, want, got)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/mackerelio/mackerel-agent/command"
 	"github.com/mackerelio/mackerel-agent/config"
 	"github.com/mackerelio/mackerel-agent/pidfile"
+	"github.com/mackerelio/mackerel-agent/supervisor"
 	"github.com/motemen/go-cli"
 )
 
@@ -79,6 +81,113 @@ func resolveConfigForRetire(fs *flag.FlagSet, argv []string) (*config.Config, bo
 	return conf, *force, err
 }
 
+func resolveConfigForOnce(fs *flag.FlagSet, argv []string) (*config.Config, *command.OnceOption, error) {
+	opt := &command.OnceOption{}
+	fs.BoolVar(&opt.Checks, "checks", false, "run the check plugins")
+	fs.BoolVar(&opt.Metadata, "metadata", false, "run the metadata plugins")
+	fs.StringVar(&opt.Format, "format", "json", "output format (json or table)")
+	conf, err := resolveConfig(fs, argv)
+	return conf, opt, err
+}
+
+func resolveConfigForConfigtest(fs *flag.FlagSet, argv []string) (*config.Config, bool, error) {
+	var asJSON = fs.Bool("json", false, "output the result in JSON")
+	conf, err := resolveConfig(fs, argv)
+	if err != nil {
+		// The problems of the config file are reported even when it fails to load.
+		conf = &config.Config{Conffile: fs.Lookup("conf").Value.String()}
+	}
+	return conf, *asJSON, err
+}
+
+func resolveConfigForDiagnose(fs *flag.FlagSet, argv []string) (*config.Config, *command.DiagnoseOption, error) {
+	opt := &command.DiagnoseOption{}
+	fs.StringVar(&opt.Output, "output", "", "Path of the support bundle (default: mackerel-agent-diagnose-<time>.tar.gz)")
+	logFiles := fs.String("log", "", "Comma separated paths of the log files of the agent")
+	conf, err := resolveConfig(fs, argv)
+	if *logFiles != "" {
+		opt.LogFiles = strings.Split(*logFiles, ",")
+	}
+	if err != nil {
+		// The support bundle is needed especially when the config is broken.
+		opt.LoadError = err
+		conf = &config.Config{
+			Conffile: fs.Lookup("conf").Value.String(),
+			Apibase:  fs.Lookup("apibase").Value.String(),
+		}
+	}
+	return conf, opt, err
+}
+
+func resolveConfigForStatus(fs *flag.FlagSet, argv []string) (*config.Config, bool, error) {
+	var asJSON = fs.Bool("json", false, "output the status in JSON")
+	conf, err := resolveConfig(fs, argv)
+	return conf, *asJSON, err
+}
+
+type annotateFlags struct {
+	title, description, service, roles, from, to string
+}
+
+func resolveConfigForAnnotate(fs *flag.FlagSet, argv []string) (*config.Config, *command.AnnotateParam, error) {
+	var f annotateFlags
+	fs.StringVar(&f.title, "title", "", "Title of the annotation (required)")
+	fs.StringVar(&f.description, "description", "", "Description of the annotation")
+	fs.StringVar(&f.service, "service", "", "Service of the annotation (default: the services of the roles of the host)")
+	fs.StringVar(&f.roles, "roles", "", "Comma separated names of the roles in the service")
+	fs.StringVar(&f.from, "from", "", "Start time of the annotation in epoch seconds or RFC3339 (default: now)")
+	fs.StringVar(&f.to, "to", "", "End time of the annotation in epoch seconds or RFC3339 (default: from)")
+	conf, err := resolveConfig(fs, argv)
+	if err != nil {
+		return nil, nil, err
+	}
+	param, err := f.annotateParam(time.Now())
+	return conf, param, err
+}
+
+func (f *annotateFlags) annotateParam(now time.Time) (*command.AnnotateParam, error) {
+	if f.title == "" {
+		return nil, fmt.Errorf("title must be specified")
+	}
+	if f.roles != "" && f.service == "" {
+		return nil, fmt.Errorf("service must be specified with roles")
+	}
+	param := &command.AnnotateParam{
+		Title:       f.title,
+		Description: f.description,
+		Service:     f.service,
+		From:        now,
+	}
+	for role := range strings.SplitSeq(f.roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			param.Roles = append(param.Roles, role)
+		}
+	}
+	var err error
+	if f.from != "" {
+		if param.From, err = parseAnnotationTime(f.from); err != nil {
+			return nil, fmt.Errorf("invalid from: %s", err)
+		}
+	}
+	param.To = param.From
+	if f.to != "" {
+		if param.To, err = parseAnnotationTime(f.to); err != nil {
+			return nil, fmt.Errorf("invalid to: %s", err)
+		}
+	}
+	if param.To.Before(param.From) {
+		return nil, fmt.Errorf("to must not be before from")
+	}
+	return param, nil
+}
+
+func parseAnnotationTime(s string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 // resolveConfig parses command line arguments and loads config file to
 // return config.Config information.
 func resolveConfig(fs *flag.FlagSet, argv []string) (*config.Config, error) {
@@ -219,9 +328,13 @@ func start(conf *config.Config, termCh chan struct{}) error {
 	if err != nil {
 		return fmt.Errorf("command.Prepare failed: %s", err)
 	}
+	app.Reloaded = os.Getenv(supervisor.ReloadedEnv) != ""
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	if supervisor.ReloadSignal != nil {
+		signal.Notify(c, supervisor.ReloadSignal)
+	}
 	if conf.AutoShutdown {
 		prog, err := os.Executable()
 		if err != nil {
@@ -245,6 +358,10 @@ func signalHandler(c chan os.Signal, app *command.App, termCh chan struct{}) {
 
 			app.UpdateHostSpecs()
 		} else {
+			if sig == supervisor.ReloadSignal {
+				// stopped by the supervisor to start again with the new configuration
+				app.SetReloading()
+			}
 			if !received {
 				received = true
 				logger.Infof(
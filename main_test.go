@@ -6,6 +6,7 @@ import (
 	"math"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 	"testing"
 	"time"
@@ -121,6 +122,62 @@ func TestResolveConfigForRetire(t *testing.T) {
 	}
 }
 
+func TestResolveConfigForAnnotate(t *testing.T) {
+	confFile, err := os.CreateTemp("", "mackerel-config-test")
+	if err != nil {
+		t.Fatalf("Could not create temporary config file for test")
+	}
+	confFile.WriteString(`apikey="DUMMYAPIKEY"
+`)
+	confFile.Sync()
+	confFile.Close()
+	defer os.Remove(confFile.Name())
+
+	argv := []string{
+		"-conf=" + confFile.Name(),
+		"-title=deploy",
+		"-service=web",
+		"-roles=app, batch",
+		"-from=1700000000",
+		"-to=2023-11-14T22:23:20Z",
+		"-role=hoge:fuga",
+	}
+	conf, param, err := resolveConfigForAnnotate(&flag.FlagSet{}, argv)
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	if conf.Apikey != "DUMMYAPIKEY" {
+		t.Errorf("Apikey should be 'DUMMYAPIKEY'")
+	}
+	if param.Title != "deploy" || param.Service != "web" || !reflect.DeepEqual(param.Roles, []string{"app", "batch"}) {
+		t.Errorf("unexpected param: %+v", param)
+	}
+	if param.From.Unix() != 1700000000 || param.To.Unix() != 1700000600 {
+		t.Errorf("unexpected time: from = %s, to = %s", param.From, param.To)
+	}
+
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		flags annotateFlags
+		ok    bool
+	}{
+		{annotateFlags{title: "deploy"}, true},
+		{annotateFlags{}, false},
+		{annotateFlags{title: "deploy", roles: "app"}, false},
+		{annotateFlags{title: "deploy", from: "yesterday"}, false},
+		{annotateFlags{title: "deploy", from: "1700000000", to: "1699999999"}, false},
+	}
+	for _, tt := range tests {
+		param, err := tt.flags.annotateParam(now)
+		if (err == nil) != tt.ok {
+			t.Errorf("annotateParam(%+v) should succeed: %t but err = %v", tt.flags, tt.ok, err)
+		}
+		if err == nil && (!param.From.Equal(now) || !param.To.Equal(now)) {
+			t.Errorf("the time should be now by default but %s - %s", param.From, param.To)
+		}
+	}
+}
+
 func TestCreateAndRemovePidFile(t *testing.T) {
 	file, err := os.CreateTemp("", "")
 	if err != nil {
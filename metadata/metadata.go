@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/cmdutil"
 	"github.com/mackerelio/mackerel-agent/config"
 )
 
@@ -20,11 +21,21 @@ type Generator struct {
 	Config       *config.MetadataPlugin
 	Cachefile    string
 	PrevMetadata any
+
+	// Source generates the metadata inside the agent instead of the command of Config.
+	Source func() (any, error)
+
+	lastRun cmdutil.RunRecorder
 }
 
 // Fetch invokes the command and returns the result
 func (g *Generator) Fetch() (any, error) {
+	if g.Source != nil {
+		return g.fetchSource()
+	}
+	startedAt := time.Now()
 	message, stderr, exitCode, err := g.Config.Command.Run()
+	g.lastRun.Record(startedAt, stderr, exitCode, err)
 
 	if err != nil {
 		logger.Warningf("Error occurred while executing a metadata plugin %q: %v", g.Name, err)
@@ -48,6 +59,28 @@ func (g *Generator) Fetch() (any, error) {
 	return metadata, nil
 }
 
+// LastRun returns the result of the last run of the command, or nil if not run yet.
+func (g *Generator) LastRun() *cmdutil.RunResult {
+	return g.lastRun.Last()
+}
+
+// fetchSource normalizes the metadata of Source through JSON to compare with the cache file
+func (g *Generator) fetchSource() (any, error) {
+	v, err := g.Source()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the metadata to json: %v", err)
+	}
+	var metadata any
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
 // IsChanged returns whether the metadata has been changed or not
 func (g *Generator) IsChanged(metadata any) bool {
 	if g.PrevMetadata == nil {
@@ -118,7 +151,7 @@ const defaultExecutionInterval = 10 * time.Minute
 
 // Interval calculates the time interval of command execution
 func (g *Generator) Interval() time.Duration {
-	if g.Config.ExecutionInterval == nil {
+	if g.Config == nil || g.Config.ExecutionInterval == nil {
 		return defaultExecutionInterval
 	}
 	interval := time.Duration(*g.Config.ExecutionInterval) * time.Minute
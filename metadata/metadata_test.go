@@ -180,3 +180,36 @@ func TestMetadataGeneratorInterval(t *testing.T) {
 func pint(i int32) *int32 {
 	return &i
 }
+
+func TestMetadataGeneratorSource(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	g := Generator{
+		Name:      "items",
+		Cachefile: filepath.Join(t.TempDir(), "items"),
+		Source: func() (any, error) {
+			return map[string]any{"items": []*item{{Name: "foo"}}}, nil
+		},
+	}
+	metadata, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if err := g.Save(metadata); err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+
+	// the metadata loaded from the cache file should be equal to the fetched one
+	g.PrevMetadata = nil
+	metadata, err = g.Fetch()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if g.IsChanged(metadata) {
+		t.Errorf("metadata should not be changed: %v, %v", g.PrevMetadata, metadata)
+	}
+	if g.Interval() != defaultExecutionInterval {
+		t.Errorf("interval should be %s but %s", defaultExecutionInterval, g.Interval())
+	}
+}
@@ -34,11 +34,11 @@ func (g *AgentGenerator) CustomIdentifier() *string {
 // PrepareGraphDefs for PluginGenerator interface
 func (g *AgentGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
 	meta := &pluginMeta{
-		Graphs: map[string]customGraphDef{
+		Graphs: map[string]CustomGraphDef{
 			"agent.memory": {
 				Label: "Agent Memory",
 				Unit:  "bytes",
-				Metrics: []customGraphMetricDef{
+				Metrics: []CustomGraphMetricDef{
 					{Name: "alloc", Label: "Alloc"},
 					{Name: "sys", Label: "Sys"},
 					{Name: "heapAlloc", Label: "Heap Alloc"},
@@ -48,11 +48,11 @@ func (g *AgentGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
 			"agent.runtime": {
 				Label: "Agent Runtime",
 				Unit:  "integer",
-				Metrics: []customGraphMetricDef{
+				Metrics: []CustomGraphMetricDef{
 					{Name: "goroutine_num", Label: "Goroutine Num"},
 				},
 			},
 		},
 	}
-	return makeGraphDefsParam(meta), nil
+	return makeGraphDefsParam(meta, pluginPrefix), nil
 }
@@ -0,0 +1,155 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultDockerSocket is the default path of the Docker Engine API socket
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+const timeout = 10 * time.Second
+
+// DockerClient is a client of the Docker Engine API over a Unix socket.
+// Podman and other runtimes which serve the Docker compatible API can also be used.
+type DockerClient struct {
+	httpCli *http.Client
+}
+
+// NewDockerClient creates a DockerClient which connects to the socket
+func NewDockerClient(socket string) *DockerClient {
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+	return &DockerClient{
+		httpCli: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				// the host part of the URLs is ignored
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+				Proxy: nil,
+			},
+		},
+	}
+}
+
+// Container is an entry of GET /containers/json
+type Container struct {
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	State   string            `json:"State"`
+	Created int64             `json:"Created"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+// Name returns the name of the container without the leading slash
+func (c *Container) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID[:min(len(c.ID), 12)]
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// Stats is a part of the response of GET /containers/{id}/stats
+type Stats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"` // nanoseconds
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+func (c *DockerClient) get(ctx context.Context, path string, query url.Values, v any) error {
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s failed: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ListContainers lists the running containers
+func (c *DockerClient) ListContainers(ctx context.Context) ([]*Container, error) {
+	var containers []*Container
+	if err := c.get(ctx, "/containers/json", nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// ContainerStats returns the current statistics of the container without waiting for the next sample
+func (c *DockerClient) ContainerStats(ctx context.Context, id string) (*Stats, error) {
+	query := url.Values{"stream": {"false"}, "one-shot": {"true"}}
+	var stats Stats
+	if err := c.get(ctx, "/containers/"+id+"/stats", query, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ContainerInventory is an entry of the container inventory published as host metadata.
+// The human-readable status such as "Up 2 hours" is not included because it changes every time
+// and the metadata would be updated on every run.
+type ContainerInventory struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	State   string `json:"state"`
+	Created string `json:"created"`
+}
+
+// Inventory returns the running containers as host metadata
+func (c *DockerClient) Inventory() (any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	containers, err := c.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inventory := make([]*ContainerInventory, 0, len(containers))
+	for _, container := range containers {
+		inventory = append(inventory, &ContainerInventory{
+			ID:      container.ID[:min(len(container.ID), 12)],
+			Name:    container.Name(),
+			Image:   container.Image,
+			State:   container.State,
+			Created: time.Unix(container.Created, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	return map[string]any{"containers": inventory}, nil
+}
@@ -0,0 +1,167 @@
+package container
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+DockerGenerator collects the resource usage of the running containers by the Docker Engine API
+
+`custom.container.{name}.cpu.usage`: CPU time as percentage of a single core
+`custom.container.{name}.memory.{usage,limit}`: memory usage excluding the page cache, and the limit in bytes
+`custom.container.{name}.network.{rx,tx}`: bytes per second received and transmitted by all interfaces
+`custom.container.{name}.blkio.{read,write}`: bytes per second read from and written to the block devices
+
+name = the container name sanitized by util.SanitizeMetricKey
+*/
+type DockerGenerator struct {
+	Client   *DockerClient
+	Interval time.Duration
+}
+
+var dockerLogger = logging.GetLogger("metrics.container")
+
+type containerSample struct {
+	name        string
+	cpu         uint64
+	memoryUsage uint64
+	memoryLimit uint64
+	rx, tx      uint64
+	read, write uint64
+}
+
+// Generate container metrics
+func (g *DockerGenerator) Generate() (metrics.Values, error) {
+	prev, err := g.sample()
+	if err != nil {
+		dockerLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	time.Sleep(g.Interval)
+
+	curr, err := g.sample()
+	if err != nil {
+		dockerLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+	return deriveContainerValues(prev, curr, g.Interval.Seconds()), nil
+}
+
+// sample collects the statistics of the running containers keyed by the container IDs.
+// The containers which stop while collecting are skipped.
+func (g *DockerGenerator) sample() (map[string]*containerSample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	containers, err := g.Client.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	samples := make(map[string]*containerSample, len(containers))
+	for _, c := range containers {
+		stats, err := g.Client.ContainerStats(ctx, c.ID)
+		if err != nil {
+			dockerLogger.Debugf("Failed to get stats of %s: %s", c.Name(), err)
+			continue
+		}
+		samples[c.ID] = newContainerSample(c.Name(), stats)
+	}
+	return samples, nil
+}
+
+func newContainerSample(name string, stats *Stats) *containerSample {
+	s := &containerSample{
+		name:        name,
+		cpu:         stats.CPUStats.CPUUsage.TotalUsage,
+		memoryUsage: stats.MemoryStats.Usage,
+		memoryLimit: stats.MemoryStats.Limit,
+	}
+	// exclude the page cache like `docker stats` does ("total_inactive_file" for cgroup v1 and "inactive_file" for v2)
+	cache, ok := stats.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache = stats.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < s.memoryUsage {
+		s.memoryUsage -= cache
+	}
+	for _, n := range stats.Networks {
+		s.rx += n.RxBytes
+		s.tx += n.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			s.read += entry.Value
+		case "write":
+			s.write += entry.Value
+		}
+	}
+	return s
+}
+
+func deriveContainerValues(prev, curr map[string]*containerSample, seconds float64) metrics.Values {
+	ret := make(metrics.Values)
+	for id, c := range curr {
+		p, ok := prev[id]
+		if !ok {
+			continue
+		}
+		prefix := "custom.container." + util.SanitizeMetricKey(c.name) + "."
+		rate := func(curr, prev uint64) metrics.ValueAttribute {
+			return metrics.NewValueAttribute(float64(util.DiffResettableCounter(curr, prev)) / seconds)
+		}
+		// nanoseconds -> percentage
+		ret[prefix+"cpu.usage"] = metrics.NewValueAttribute(float64(util.DiffResettableCounter(c.cpu, p.cpu)) / 1e7 / seconds)
+		ret[prefix+"memory.usage"] = metrics.NewValueAttribute(float64(c.memoryUsage))
+		ret[prefix+"memory.limit"] = metrics.NewValueAttribute(float64(c.memoryLimit))
+		ret[prefix+"network.rx"] = rate(c.rx, p.rx)
+		ret[prefix+"network.tx"] = rate(c.tx, p.tx)
+		ret[prefix+"blkio.read"] = rate(c.read, p.read)
+		ret[prefix+"blkio.write"] = rate(c.write, p.write)
+	}
+	return ret
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *DockerGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"container.#.cpu": {
+			Label: "Container CPU",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "usage", Label: "Usage"},
+			},
+		},
+		"container.#.memory": {
+			Label: "Container Memory",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "usage", Label: "Usage"},
+				{Name: "limit", Label: "Limit"},
+			},
+		},
+		"container.#.network": {
+			Label: "Container Network",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "rx", Label: "Received"},
+				{Name: "tx", Label: "Transmitted"},
+			},
+		},
+		"container.#.blkio": {
+			Label: "Container Block I/O",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "write", Label: "Write"},
+			},
+		},
+	}), nil
+}
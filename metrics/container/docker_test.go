@@ -0,0 +1,117 @@
+package container
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newStubDockerServer serves the Docker Engine API on a Unix socket and returns the path of the socket
+func newStubDockerServer(t *testing.T, cpu *uint64) string {
+	t.Helper()
+	// the path of a Unix socket must be short
+	dir, err := os.MkdirTemp("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+  {"Id": "8dfafdbc3a40c2b4a1b3e2f1", "Names": ["/web.1"], "Image": "nginx:1.27", "State": "running", "Status": "Up 2 hours", "Created": 1700000000},
+  {"Id": "1a2b3c4d5e6f7a8b9c0d1e2f", "Names": ["/gone"], "Image": "busybox", "State": "running", "Status": "Up 1 second", "Created": 1700000100}
+]`)
+	})
+	mux.HandleFunc("GET /containers/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "8dfafdbc3a40c2b4a1b3e2f1" {
+			http.Error(w, `{"message": "No such container"}`, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("stream") != "false" {
+			t.Errorf("stats should not be streamed")
+		}
+		*cpu += 500000000
+		fmt.Fprintf(w, `{
+  "cpu_stats": {"cpu_usage": {"total_usage": %d}},
+  "memory_stats": {"usage": 104857600, "limit": 536870912, "stats": {"inactive_file": 4857600}},
+  "networks": {"eth0": {"rx_bytes": 1000, "tx_bytes": 2000}, "eth1": {"rx_bytes": 10, "tx_bytes": 20}},
+  "blkio_stats": {"io_service_bytes_recursive": [{"op": "read", "value": 4096}, {"op": "write", "value": 8192}]}
+}`, *cpu)
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return socket
+}
+
+func TestDockerGenerator(t *testing.T) {
+	var cpu uint64
+	socket := newStubDockerServer(t, &cpu)
+
+	g := &DockerGenerator{Client: NewDockerClient(socket), Interval: 100 * time.Millisecond}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := map[string]float64{
+		// 0.5 seconds of CPU time in 0.1 seconds
+		"custom.container.web_1.cpu.usage":    500,
+		"custom.container.web_1.memory.usage": 100000000,
+		"custom.container.web_1.memory.limit": 536870912,
+		"custom.container.web_1.network.rx":   0,
+		"custom.container.web_1.network.tx":   0,
+		"custom.container.web_1.blkio.read":   0,
+		"custom.container.web_1.blkio.write":  0,
+	}
+	if len(values) != len(expect) {
+		t.Errorf("unexpected metrics: %+v", values)
+	}
+	for name, v := range expect {
+		if value, ok := values[name]; !ok || value.Value != v {
+			t.Errorf("%s should be %f but %+v", name, v, value)
+		}
+	}
+}
+
+func TestDockerClient_Inventory(t *testing.T) {
+	var cpu uint64
+	socket := newStubDockerServer(t, &cpu)
+
+	inventory, err := NewDockerClient(socket).Inventory()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := map[string]any{
+		"containers": []*ContainerInventory{
+			{ID: "8dfafdbc3a40", Name: "web.1", Image: "nginx:1.27", State: "running", Created: "2023-11-14T22:13:20Z"},
+			{ID: "1a2b3c4d5e6f", Name: "gone", Image: "busybox", State: "running", Created: "2023-11-14T22:15:00Z"},
+		},
+	}
+	if !reflect.DeepEqual(inventory, expect) {
+		t.Errorf("inventory should be %+v but %+v", expect, inventory)
+	}
+}
+
+func TestDockerClient_Error(t *testing.T) {
+	var cpu uint64
+	socket := newStubDockerServer(t, &cpu)
+
+	_, err := NewDockerClient(socket).ContainerStats(t.Context(), "unknown")
+	if err == nil {
+		t.Fatalf("error should be returned for unknown containers")
+	}
+	if _, err := NewDockerClient(filepath.Join(t.TempDir(), "none.sock")).ListContainers(t.Context()); err == nil {
+		t.Errorf("error should be returned when the socket does not exist")
+	}
+}
@@ -6,15 +6,24 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/mackerelio/golib/logging"
 	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
 )
 
 // FilesystemGenerator is common filesystem metrics generator on unix os.
+//
+// When Inodes is enabled, it also generates inode usage of filesystems
+//   - custom.filesystem.{name}.inodes.total: the number of inodes
+//   - custom.filesystem.{name}.inodes.used: the number of used inodes
 type FilesystemGenerator struct {
 	IgnoreRegexp  *regexp.Regexp
 	UseMountpoint bool
+	Inodes        bool
 }
 
+var filesystemLogger = logging.GetLogger("metrics.filesystem")
+
 // Generate the metrics of filesystems
 func (g *FilesystemGenerator) Generate() (Values, error) {
 	filesystems, err := util.CollectDfValues()
@@ -37,7 +46,41 @@ func (g *FilesystemGenerator) Generate() (Values, error) {
 			// kilo bytes -> bytes
 			ret["filesystem."+metricName+".size"] = NewValueAttribute(float64(dfs.Used+dfs.Available) * 1024)
 			ret["filesystem."+metricName+".used"] = NewValueAttribute(float64(dfs.Used) * 1024)
+
+			if g.Inodes {
+				inodes, err := util.CollectInodeStat(dfs.Mounted)
+				if err != nil {
+					filesystemLogger.Warningf("Failed to collect inodes of %s: %s", dfs.Mounted, err)
+					continue
+				}
+				// Some filesystems such as btrfs do not have a fixed number of inodes.
+				if inodes.Total == 0 {
+					continue
+				}
+				ret["custom.filesystem."+metricName+".inodes.total"] = NewValueAttribute(float64(inodes.Total))
+				ret["custom.filesystem."+metricName+".inodes.used"] = NewValueAttribute(float64(inodes.Used))
+			}
 		}
 	}
 	return ret, nil
 }
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *FilesystemGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	if !g.Inodes {
+		return nil, nil
+	}
+	meta := &pluginMeta{
+		Graphs: map[string]CustomGraphDef{
+			"filesystem.#.inodes": {
+				Label: "Filesystem Inodes",
+				Unit:  "integer",
+				Metrics: []CustomGraphMetricDef{
+					{Name: "total", Label: "Total"},
+					{Name: "used", Label: "Used"},
+				},
+			},
+		},
+	}
+	return makeGraphDefsParam(meta, pluginPrefix), nil
+}
@@ -3,6 +3,7 @@
 package metrics
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -14,3 +15,40 @@ func TestFilesystemGenerate(t *testing.T) {
 		t.Errorf("Generate() failed: %s", err)
 	}
 }
+
+func TestFilesystemGenerate_Inodes(t *testing.T) {
+	g := &FilesystemGenerator{Inodes: true}
+
+	values, err := g.Generate()
+	if err != nil {
+		t.Errorf("Generate() failed: %s", err)
+	}
+	for name, v := range values {
+		if strings.HasSuffix(name, ".inodes.used") {
+			total, ok := values[strings.TrimSuffix(name, ".used")+".total"]
+			if !ok {
+				t.Errorf("%s should be generated with total", name)
+				continue
+			}
+			if v.Value > total.Value {
+				t.Errorf("%s should not exceed total: %f > %f", name, v.Value, total.Value)
+			}
+		}
+	}
+}
+
+func TestFilesystemPrepareGraphDefs(t *testing.T) {
+	g := &FilesystemGenerator{}
+	if defs, _ := g.PrepareGraphDefs(); len(defs) != 0 {
+		t.Errorf("graph definitions should be empty without inodes: %v", defs)
+	}
+
+	g = &FilesystemGenerator{Inodes: true}
+	defs, err := g.PrepareGraphDefs()
+	if err != nil {
+		t.Fatalf("PrepareGraphDefs() failed: %s", err)
+	}
+	if len(defs) != 1 || defs[0].Name != "custom.filesystem.#.inodes" {
+		t.Errorf("unexpected graph definitions: %v", defs)
+	}
+}
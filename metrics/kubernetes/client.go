@@ -0,0 +1,209 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+// the defaults for the agent running in a pod with a service account
+const (
+	defaultKubeletEndpoint = "https://localhost:10250"
+	defaultTokenFile       = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultCAFile          = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+const timeout = 10 * time.Second
+
+// Client is a client of the kubelet and the API server
+type Client struct {
+	httpCli      *http.Client
+	kubeletURL   *url.URL
+	apiServerURL *url.URL // nil when the API server is not available
+	tokenFile    string
+	nodeName     string
+}
+
+// NewClient creates a Client from the configuration.
+// The endpoints, the token and the node name default to the ones of the pod running the agent.
+func NewClient(conf *config.Kubernetes) (*Client, error) {
+	kubelet := conf.KubeletEndpoint
+	if kubelet == "" {
+		kubelet = defaultKubeletEndpoint
+	}
+	kubeletURL, err := url.Parse(kubelet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubelet_endpoint: %s", err)
+	}
+	c := &Client{kubeletURL: kubeletURL, tokenFile: conf.TokenFile, nodeName: conf.NodeName}
+
+	apiServer := conf.APIServerEndpoint
+	if host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"); apiServer == "" && host != "" && port != "" {
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if apiServer != "" {
+		if c.apiServerURL, err = url.Parse(apiServer); err != nil {
+			return nil, fmt.Errorf("invalid apiserver_endpoint: %s", err)
+		}
+	}
+	if c.tokenFile == "" {
+		if _, err := os.Stat(defaultTokenFile); err == nil {
+			c.tokenFile = defaultTokenFile
+		}
+	}
+	if c.nodeName == "" {
+		// usually given by the downward API
+		c.nodeName = os.Getenv("NODE_NAME")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	caFile := conf.CAFile
+	if caFile == "" {
+		caFile = defaultCAFile
+	}
+	if pem, err := os.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	} else if conf.CAFile != "" {
+		return nil, err
+	}
+	c.httpCli = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			// the kubelet and the API server are accessed directly
+			Proxy: nil,
+		},
+	}
+	return c, nil
+}
+
+func (c *Client) get(ctx context.Context, base *url.URL, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", base.JoinPath(path).String(), nil)
+	if err != nil {
+		return err
+	}
+	// the token is read every time since it is rotated
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s failed: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Summary is a part of the response of the kubelet GET /stats/summary
+type Summary struct {
+	Node struct {
+		NodeName string `json:"nodeName"`
+	} `json:"node"`
+	Pods []*PodStats `json:"pods"`
+}
+
+// PodStats is the resource usage of a pod in Summary
+type PodStats struct {
+	PodRef struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"podRef"`
+	CPU *struct {
+		UsageNanoCores *uint64 `json:"usageNanoCores"`
+	} `json:"cpu"`
+	Memory *struct {
+		WorkingSetBytes *uint64 `json:"workingSetBytes"`
+	} `json:"memory"`
+	Network *struct {
+		RxBytes *uint64 `json:"rxBytes"`
+		TxBytes *uint64 `json:"txBytes"`
+	} `json:"network"`
+}
+
+// Summary returns the resource usage of the node and the pods
+func (c *Client) Summary(ctx context.Context) (*Summary, error) {
+	var summary Summary
+	if err := c.get(ctx, c.kubeletURL, "/stats/summary", &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// Pod is a part of a pod in the response of the kubelet GET /pods
+type Pod struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// Pods returns the pods on the node
+func (c *Client) Pods(ctx context.Context) ([]*Pod, error) {
+	var list struct {
+		Items []*Pod `json:"items"`
+	}
+	if err := c.get(ctx, c.kubeletURL, "/pods", &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// NodeName returns the name of the node, which is retrieved from the kubelet unless configured
+func (c *Client) NodeName(ctx context.Context) (string, error) {
+	if c.nodeName != "" {
+		return c.nodeName, nil
+	}
+	summary, err := c.Summary(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.nodeName = summary.Node.NodeName
+	return c.nodeName, nil
+}
+
+// NodeLabels returns the labels of the node from the API server
+func (c *Client) NodeLabels(ctx context.Context) (map[string]string, error) {
+	if c.apiServerURL == nil {
+		return nil, fmt.Errorf("the API server is not available (specify apiserver_endpoint)")
+	}
+	name, err := c.NodeName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var node struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := c.get(ctx, c.apiServerURL, "/api/v1/nodes/"+url.PathEscape(name), &node); err != nil {
+		return nil, err
+	}
+	return node.Metadata.Labels, nil
+}
@@ -0,0 +1,191 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+KubeletGenerator collects the resource usage of the pods on the node from the kubelet /stats/summary and /pods
+
+`custom.kubernetes.pod.{pod}.cpu.usage`: CPU usage as percentage of a single core
+`custom.kubernetes.pod.{pod}.memory.working_set`: working set in bytes
+`custom.kubernetes.pod.{pod}.network.{rx,tx}`: bytes per second received and transmitted
+
+pod = "{namespace}_{name}" sanitized by util.SanitizeMetricKey
+
+`custom.kubernetes.namespace.{namespace}.cpu.usage`: the sum of the CPU usage of the pods in the namespace
+`custom.kubernetes.namespace.{namespace}.memory.working_set`: the sum of the working set of the pods in the namespace
+`custom.kubernetes.namespace.{namespace}.pods.count`: the number of the running pods in the namespace
+`custom.kubernetes.pods.{phase}`: the number of the pods on the node in each phase
+
+phase = "pending", "running", "succeeded", "failed", "unknown"
+*/
+type KubeletGenerator struct {
+	Client   *Client
+	Interval time.Duration
+}
+
+var kubeletLogger = logging.GetLogger("metrics.kubernetes")
+
+var podPhases = []string{"pending", "running", "succeeded", "failed", "unknown"}
+
+// Generate pod metrics
+func (g *KubeletGenerator) Generate() (metrics.Values, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.Interval+2*timeout)
+	defer cancel()
+	prev, err := g.Client.Summary(ctx)
+	if err != nil {
+		kubeletLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	time.Sleep(g.Interval)
+
+	curr, err := g.Client.Summary(ctx)
+	if err != nil {
+		kubeletLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+	ret := deriveSummaryValues(prev, curr, g.Interval.Seconds())
+
+	pods, err := g.Client.Pods(ctx)
+	if err != nil {
+		kubeletLogger.Warningf("Failed to get pods: %s", err)
+		return ret, nil
+	}
+	for name, value := range countPods(pods) {
+		ret[name] = metrics.NewValueAttribute(value)
+	}
+	return ret, nil
+}
+
+func podKey(namespace, name string) string {
+	return util.SanitizeMetricKey(namespace + "_" + name)
+}
+
+func deriveSummaryValues(prev, curr *Summary, seconds float64) metrics.Values {
+	prevPods := make(map[string]*PodStats, len(prev.Pods))
+	for _, p := range prev.Pods {
+		prevPods[podKey(p.PodRef.Namespace, p.PodRef.Name)] = p
+	}
+	ret := make(metrics.Values)
+	namespaceCPU := make(map[string]float64)
+	namespaceMemory := make(map[string]float64)
+	for _, p := range curr.Pods {
+		key := podKey(p.PodRef.Namespace, p.PodRef.Name)
+		prefix := "custom.kubernetes.pod." + key + "."
+		ns := util.SanitizeMetricKey(p.PodRef.Namespace)
+		// the kubelet omits the values which are not collected yet
+		if p.CPU != nil && p.CPU.UsageNanoCores != nil {
+			// nanocores -> percentage
+			usage := float64(*p.CPU.UsageNanoCores) / 1e7
+			ret[prefix+"cpu.usage"] = metrics.NewValueAttribute(usage)
+			namespaceCPU[ns] += usage
+		}
+		if p.Memory != nil && p.Memory.WorkingSetBytes != nil {
+			ret[prefix+"memory.working_set"] = metrics.NewValueAttribute(float64(*p.Memory.WorkingSetBytes))
+			namespaceMemory[ns] += float64(*p.Memory.WorkingSetBytes)
+		}
+		pp, ok := prevPods[key]
+		if !ok || p.Network == nil || pp.Network == nil {
+			continue
+		}
+		if p.Network.RxBytes != nil && pp.Network.RxBytes != nil {
+			ret[prefix+"network.rx"] = metrics.NewValueAttribute(float64(util.DiffResettableCounter(*p.Network.RxBytes, *pp.Network.RxBytes)) / seconds)
+		}
+		if p.Network.TxBytes != nil && pp.Network.TxBytes != nil {
+			ret[prefix+"network.tx"] = metrics.NewValueAttribute(float64(util.DiffResettableCounter(*p.Network.TxBytes, *pp.Network.TxBytes)) / seconds)
+		}
+	}
+	for ns, usage := range namespaceCPU {
+		ret["custom.kubernetes.namespace."+ns+".cpu.usage"] = metrics.NewValueAttribute(usage)
+	}
+	for ns, usage := range namespaceMemory {
+		ret["custom.kubernetes.namespace."+ns+".memory.working_set"] = metrics.NewValueAttribute(usage)
+	}
+	return ret
+}
+
+// countPods counts the pods by the phases, and the running pods by the namespaces
+func countPods(pods []*Pod) map[string]float64 {
+	ret := make(map[string]float64)
+	for _, phase := range podPhases {
+		ret["custom.kubernetes.pods."+phase] = 0
+	}
+	for _, p := range pods {
+		phase := strings.ToLower(p.Status.Phase)
+		if phase == "" {
+			phase = "unknown"
+		}
+		ret["custom.kubernetes.pods."+phase]++
+		if phase == "running" {
+			ret["custom.kubernetes.namespace."+util.SanitizeMetricKey(p.Metadata.Namespace)+".pods.count"]++
+		}
+	}
+	return ret
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *KubeletGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	phases := make([]metrics.CustomGraphMetricDef, 0, len(podPhases))
+	for _, phase := range podPhases {
+		phases = append(phases, metrics.CustomGraphMetricDef{Name: phase, Label: phase, Stacked: true})
+	}
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"kubernetes.pod.#.cpu": {
+			Label: "Kubernetes Pod CPU",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "usage", Label: "Usage"},
+			},
+		},
+		"kubernetes.pod.#.memory": {
+			Label: "Kubernetes Pod Memory",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "working_set", Label: "Working set"},
+			},
+		},
+		"kubernetes.pod.#.network": {
+			Label: "Kubernetes Pod Network",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "rx", Label: "Received"},
+				{Name: "tx", Label: "Transmitted"},
+			},
+		},
+		"kubernetes.namespace.#.cpu": {
+			Label: "Kubernetes Namespace CPU",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "usage", Label: "Usage"},
+			},
+		},
+		"kubernetes.namespace.#.memory": {
+			Label: "Kubernetes Namespace Memory",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "working_set", Label: "Working set"},
+			},
+		},
+		"kubernetes.namespace.#.pods": {
+			Label: "Kubernetes Namespace Pods",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Running"},
+			},
+		},
+		"kubernetes.pods": {
+			Label:   "Kubernetes Pods",
+			Unit:    "integer",
+			Metrics: phases,
+		},
+	}), nil
+}
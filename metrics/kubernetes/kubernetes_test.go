@@ -0,0 +1,179 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+// newStubServer serves the kubelet and the API server endpoints
+func newStubServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var rxBytes uint64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats/summary", func(w http.ResponseWriter, r *http.Request) {
+		rxBytes += 1000
+		fmt.Fprintf(w, `{
+  "node": {"nodeName": "node-1", "cpu": {"usageNanoCores": 1500000000}},
+  "pods": [
+    {
+      "podRef": {"name": "web-7d4b9c8f5-x2x9k", "namespace": "default", "uid": "1"},
+      "cpu": {"usageNanoCores": 250000000},
+      "memory": {"workingSetBytes": 104857600},
+      "network": {"rxBytes": %d, "txBytes": 500}
+    },
+    {
+      "podRef": {"name": "coredns-5d78c9869d-abcde", "namespace": "kube-system", "uid": "2"},
+      "cpu": {"usageNanoCores": 5000000},
+      "memory": {"workingSetBytes": 20971520}
+    },
+    {
+      "podRef": {"name": "starting", "namespace": "default", "uid": "3"}
+    }
+  ]
+}`, rxBytes)
+	})
+	mux.HandleFunc("GET /pods", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"kind": "PodList", "items": [
+  {"metadata": {"name": "web-7d4b9c8f5-x2x9k", "namespace": "default"}, "status": {"phase": "Running"}},
+  {"metadata": {"name": "coredns-5d78c9869d-abcde", "namespace": "kube-system"}, "status": {"phase": "Running"}},
+  {"metadata": {"name": "starting", "namespace": "default"}, "status": {"phase": "Pending"}}
+]}`)
+	})
+	mux.HandleFunc("GET /api/v1/nodes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.PathValue("name") != "node-1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"metadata": {"name": "node-1", "labels": {
+  "kubernetes.io/hostname": "node-1",
+  "node-role.kubernetes.io/control-plane": "",
+  "node-role.kubernetes.io/ingress": ""
+}}}`)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestClient(t *testing.T, ts *httptest.Server) *Client {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("test-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(&config.Kubernetes{
+		KubeletEndpoint:   ts.URL,
+		APIServerEndpoint: ts.URL,
+		TokenFile:         tokenFile,
+	})
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	return c
+}
+
+func TestKubeletGenerator(t *testing.T) {
+	ts := newStubServer(t)
+	g := &KubeletGenerator{Client: newTestClient(t, ts), Interval: 100 * time.Millisecond}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := map[string]float64{
+		"custom.kubernetes.pod.default_web-7d4b9c8f5-x2x9k.cpu.usage":                   25,
+		"custom.kubernetes.pod.default_web-7d4b9c8f5-x2x9k.memory.working_set":          104857600,
+		"custom.kubernetes.pod.default_web-7d4b9c8f5-x2x9k.network.rx":                  10000,
+		"custom.kubernetes.pod.default_web-7d4b9c8f5-x2x9k.network.tx":                  0,
+		"custom.kubernetes.pod.kube-system_coredns-5d78c9869d-abcde.cpu.usage":          0.5,
+		"custom.kubernetes.pod.kube-system_coredns-5d78c9869d-abcde.memory.working_set": 20971520,
+		"custom.kubernetes.namespace.default.cpu.usage":                                 25,
+		"custom.kubernetes.namespace.default.memory.working_set":                        104857600,
+		"custom.kubernetes.namespace.default.pods.count":                                1,
+		"custom.kubernetes.namespace.kube-system.cpu.usage":                             0.5,
+		"custom.kubernetes.namespace.kube-system.memory.working_set":                    20971520,
+		"custom.kubernetes.namespace.kube-system.pods.count":                            1,
+		"custom.kubernetes.pods.running":                                                2,
+		"custom.kubernetes.pods.pending":                                                1,
+		"custom.kubernetes.pods.succeeded":                                              0,
+		"custom.kubernetes.pods.failed":                                                 0,
+		"custom.kubernetes.pods.unknown":                                                0,
+	}
+	for name, v := range expect {
+		if value, ok := values[name]; !ok || value.Value != v {
+			t.Errorf("%s should be %f but %+v", name, v, value)
+		}
+	}
+	if len(values) != len(expect) {
+		t.Errorf("unexpected metrics: %+v", values)
+	}
+}
+
+func TestClient_NodeRoles(t *testing.T) {
+	ts := newStubServer(t)
+	c := newTestClient(t, ts)
+
+	roles, err := c.NodeRoles("k8s")
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := []string{"k8s:control-plane", "k8s:ingress"}
+	if !reflect.DeepEqual(roles, expect) {
+		t.Errorf("roles should be %v but %v", expect, roles)
+	}
+
+	customIdentifier, err := c.SuggestCustomIdentifier()
+	if err != nil || customIdentifier != "node-1" {
+		t.Errorf("custom identifier should be node-1 but %q (%v)", customIdentifier, err)
+	}
+}
+
+func TestClient_Inventory(t *testing.T) {
+	ts := newStubServer(t)
+	c := newTestClient(t, ts)
+
+	inventory, err := c.Inventory()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := &NodeInventory{
+		Node: "node-1",
+		Labels: map[string]string{
+			"kubernetes.io/hostname":                "node-1",
+			"node-role.kubernetes.io/control-plane": "",
+			"node-role.kubernetes.io/ingress":       "",
+		},
+		Pods: []*PodInventory{
+			{Namespace: "default", Name: "starting", Phase: "Pending"},
+			{Namespace: "default", Name: "web-7d4b9c8f5-x2x9k", Phase: "Running"},
+			{Namespace: "kube-system", Name: "coredns-5d78c9869d-abcde", Phase: "Running"},
+		},
+	}
+	if !reflect.DeepEqual(inventory, expect) {
+		t.Errorf("inventory should be %+v but %+v", expect, inventory)
+	}
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	ts := newStubServer(t)
+	c, err := NewClient(&config.Kubernetes{KubeletEndpoint: ts.URL, APIServerEndpoint: ts.URL, NodeName: "node-1"})
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	// the default token file does not exist in the test environment
+	c.tokenFile = ""
+	if _, err := c.NodeRoles("k8s"); err == nil {
+		t.Errorf("error should be returned without the token")
+	}
+}
@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent/util"
+)
+
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// NodeRoles returns the role fullnames "{service}:{role}" of the node-role.kubernetes.io/{role} labels
+func (c *Client) NodeRoles(service string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+	labels, err := c.NodeLabels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return nodeRoles(labels, service), nil
+}
+
+func nodeRoles(labels map[string]string, service string) []string {
+	var roles []string
+	for key := range labels {
+		if role, ok := strings.CutPrefix(key, nodeRoleLabelPrefix); ok && role != "" {
+			roles = append(roles, service+":"+util.SanitizeMetricKey(role))
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// NodeInventory is the host metadata of the node and the pods on it
+type NodeInventory struct {
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Pods   []*PodInventory   `json:"pods"`
+}
+
+// PodInventory is a pod in NodeInventory
+type PodInventory struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Phase     string `json:"phase"`
+}
+
+// Inventory returns the node labels and the pods as host metadata.
+// The labels are omitted when the API server is not available.
+func (c *Client) Inventory() (any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
+	defer cancel()
+	pods, err := c.Pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node, err := c.NodeName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &NodeInventory{Node: node, Pods: make([]*PodInventory, 0, len(pods))}
+	if c.apiServerURL != nil {
+		if inventory.Labels, err = c.NodeLabels(ctx); err != nil {
+			kubeletLogger.Warningf("Failed to get the node labels: %s", err)
+		}
+	}
+	for _, p := range pods {
+		inventory.Pods = append(inventory.Pods, &PodInventory{Namespace: p.Metadata.Namespace, Name: p.Metadata.Name, Phase: p.Status.Phase})
+	}
+	slices.SortFunc(inventory.Pods, func(a, b *PodInventory) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+	return inventory, nil
+}
+
+// SuggestCustomIdentifier suggests the node name as the custom identifier of the host
+func (c *Client) SuggestCustomIdentifier() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.NodeName(ctx)
+}
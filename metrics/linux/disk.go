@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"maps"
 	"os"
 	"regexp"
 	"strconv"
@@ -15,6 +16,7 @@ import (
 	"github.com/mackerelio/golib/logging"
 	"github.com/mackerelio/mackerel-agent/metrics"
 	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
 )
 
 /*
@@ -25,9 +27,18 @@ collect disk I/O
 device = "sda1", "xvda1" and so on...
 
 metric = "reads", "readsMerged", "sectorsRead", "readTime", "writes", "writesMerged", "sectorsWritten", "writeTime", "ioInProgress", "ioTime", "ioTimeWeighted"
+and "discards", "discardsMerged", "sectorsDiscarded", "discardTime" (Linux 4.18+), "flushes", "flushTime" (Linux 5.5+)
 
 graph: `disk.{device}.{metric}.delta`
 
+When Detailed is enabled, the following metrics are derived from the differences of the statistics:
+
+`custom.disk.{device}.bytes.{read,written,discarded}`: bytes per second (sectors are always 512 bytes in /proc/diskstats)
+`custom.disk.{device}.await.{read,write,discard,flush}`: average time in milliseconds spent for each I/O
+`custom.disk.{device}.ops.{discards,flushes}`: discard and flush requests per second
+`custom.disk.{device}.queue.depth`: average number of I/O requests in the queue (derived from ioTimeWeighted)
+`custom.disk.{device}.utilization.percent`: percentage of time the device was busy (derived from ioTime)
+
 cat /proc/diskstats sample:
 	202       1 xvda1 750193 3037 28116978 368712 16600606 7233846 424712632 23987908 0 2355636 24345740
 	202       2 xvda2 1641 9310 87552 1252 6365 3717 80664 24192 0 15040 25428
@@ -45,14 +56,24 @@ type DiskGenerator struct {
 	IgnoreRegexp  *regexp.Regexp
 	Interval      time.Duration
 	UseMountpoint bool
+	Detailed      bool
 }
 
 var diskMetricsNames = []string{
 	"reads", "readsMerged", "sectorsRead", "readTime",
 	"writes", "writesMerged", "sectorsWritten", "writeTime",
 	"ioInProgress", "ioTime", "ioTimeWeighted",
+	// Linux 4.18+
+	"discards", "discardsMerged", "sectorsDiscarded", "discardTime",
+	// Linux 5.5+
+	"flushes", "flushTime",
 }
 
+// The columns up to ioTimeWeighted are available in all supported kernels.
+const diskMetricsRequiredColumns = 11
+
+const diskSectorSize = 512
+
 // metrics for posting to Mackerel
 var postDiskMetricsRegexp = regexp.MustCompile(`^disk\..+\.(reads|writes)$`)
 
@@ -83,9 +104,120 @@ func (g *DiskGenerator) Generate() (metrics.Values, error) {
 		}
 	}
 
+	if g.Detailed {
+		maps.Copy(ret, g.deriveDetailedValues(prevValues, currValues))
+	}
+
 	return ret, nil
 }
 
+func (g *DiskGenerator) deriveDetailedValues(prevValues, currValues metrics.Values) metrics.Values {
+	seconds := g.Interval.Seconds()
+	ret := make(metrics.Values)
+	for name := range currValues {
+		device, ok := strings.CutSuffix(strings.TrimPrefix(name, "disk."), ".reads")
+		if !ok {
+			continue
+		}
+		delta := func(metric string) (float64, bool) {
+			key := "disk." + device + "." + metric
+			prev, ok1 := prevValues[key]
+			curr, ok2 := currValues[key]
+			// Skip values of counters which are not available or have been reset.
+			if !ok1 || !ok2 || curr.Value < prev.Value {
+				return 0, false
+			}
+			return curr.Value - prev.Value, true
+		}
+		prefix := "custom.disk." + device + "."
+		perSecond := func(name, metric string, scale float64) {
+			if d, ok := delta(metric); ok {
+				ret[prefix+name] = metrics.NewValueAttribute(d * scale / seconds)
+			}
+		}
+		await := func(name, timeMetric, countMetric string) {
+			t, ok1 := delta(timeMetric)
+			n, ok2 := delta(countMetric)
+			if !ok1 || !ok2 {
+				return
+			}
+			v := 0.0
+			if n > 0 {
+				v = t / n
+			}
+			ret[prefix+"await."+name] = metrics.NewValueAttribute(v)
+		}
+
+		perSecond("bytes.read", "sectorsRead", diskSectorSize)
+		perSecond("bytes.written", "sectorsWritten", diskSectorSize)
+		perSecond("bytes.discarded", "sectorsDiscarded", diskSectorSize)
+		perSecond("ops.discards", "discards", 1)
+		perSecond("ops.flushes", "flushes", 1)
+		await("read", "readTime", "reads")
+		await("write", "writeTime", "writes")
+		await("discard", "discardTime", "discards")
+		await("flush", "flushTime", "flushes")
+		// ioTime and ioTimeWeighted are in milliseconds
+		if d, ok := delta("ioTimeWeighted"); ok {
+			ret[prefix+"queue.depth"] = metrics.NewValueAttribute(d / (seconds * 1000))
+		}
+		if d, ok := delta("ioTime"); ok {
+			ret[prefix+"utilization.percent"] = metrics.NewValueAttribute(min(d/(seconds*1000)*100, 100))
+		}
+	}
+	return ret
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *DiskGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	if !g.Detailed {
+		return nil, nil
+	}
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"disk.#.bytes": {
+			Label: "Disk Throughput",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "written", Label: "Written"},
+				{Name: "discarded", Label: "Discarded"},
+			},
+		},
+		"disk.#.await": {
+			Label: "Disk Await",
+			Unit:  "milliseconds",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "write", Label: "Write"},
+				{Name: "discard", Label: "Discard"},
+				{Name: "flush", Label: "Flush"},
+			},
+		},
+		"disk.#.ops": {
+			Label: "Disk Discard/Flush IOPS",
+			Unit:  "iops",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "discards", Label: "Discards"},
+				{Name: "flushes", Label: "Flushes"},
+			},
+		},
+		"disk.#.queue": {
+			Label: "Disk Queue Depth",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "depth", Label: "Depth"},
+			},
+		},
+		"disk.#.utilization": {
+			Label: "Disk Utilization",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "percent", Label: "Utilization"},
+			},
+		},
+	}), nil
+}
+
 func (g *DiskGenerator) collectDiskstatValues() (metrics.Values, error) {
 	out, err := os.ReadFile("/proc/diskstats")
 	if err != nil {
@@ -118,7 +250,7 @@ func (g *DiskGenerator) parseDiskStats(out []byte, mapping map[string]string) (m
 		device := cols[2]
 		values := cols[3:]
 
-		if len(values) < len(diskMetricsNames) {
+		if len(values) < diskMetricsRequiredColumns {
 			diskLogger.Warningf("Failed to parse disk metrics: %s", device)
 			break
 		}
@@ -138,7 +270,7 @@ func (g *DiskGenerator) parseDiskStats(out []byte, mapping map[string]string) (m
 
 		deviceResult := make(map[string]float64)
 		hasNonZeroValue := false
-		for i := range diskMetricsNames {
+		for i := range min(len(values), len(diskMetricsNames)) {
 			key := fmt.Sprintf("disk.%s.%s", deviceLabel, diskMetricsNames[i])
 			value, err := strconv.ParseFloat(values[i], 64)
 			if err != nil {
@@ -4,6 +4,7 @@ package linux
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -126,34 +127,127 @@ func TestParseDiskStats_MoreFields(t *testing.T) {
 	}
 
 	expect := metrics.Values{
-		"disk.xvda1.reads":          metrics.NewValueAttribute(750193),
-		"disk.xvda1.readsMerged":    metrics.NewValueAttribute(3037),
-		"disk.xvda1.sectorsRead":    metrics.NewValueAttribute(28116978),
-		"disk.xvda1.readTime":       metrics.NewValueAttribute(368712),
-		"disk.xvda1.writes":         metrics.NewValueAttribute(16600606),
-		"disk.xvda1.writesMerged":   metrics.NewValueAttribute(7233846),
-		"disk.xvda1.sectorsWritten": metrics.NewValueAttribute(424712632),
-		"disk.xvda1.writeTime":      metrics.NewValueAttribute(23987908),
-		"disk.xvda1.ioInProgress":   metrics.NewValueAttribute(0),
-		"disk.xvda1.ioTime":         metrics.NewValueAttribute(2355636),
-		"disk.xvda1.ioTimeWeighted": metrics.NewValueAttribute(24345740),
-		"disk.loop0.reads":          metrics.NewValueAttribute(15),
-		"disk.loop0.readsMerged":    metrics.NewValueAttribute(0),
-		"disk.loop0.sectorsRead":    metrics.NewValueAttribute(0),
-		"disk.loop0.readTime":       metrics.NewValueAttribute(0),
-		"disk.loop0.writes":         metrics.NewValueAttribute(0),
-		"disk.loop0.writesMerged":   metrics.NewValueAttribute(0),
-		"disk.loop0.sectorsWritten": metrics.NewValueAttribute(0),
-		"disk.loop0.writeTime":      metrics.NewValueAttribute(0),
-		"disk.loop0.ioInProgress":   metrics.NewValueAttribute(0),
-		"disk.loop0.ioTime":         metrics.NewValueAttribute(0),
-		"disk.loop0.ioTimeWeighted": metrics.NewValueAttribute(0),
+		"disk.xvda1.reads":            metrics.NewValueAttribute(750193),
+		"disk.xvda1.readsMerged":      metrics.NewValueAttribute(3037),
+		"disk.xvda1.sectorsRead":      metrics.NewValueAttribute(28116978),
+		"disk.xvda1.readTime":         metrics.NewValueAttribute(368712),
+		"disk.xvda1.writes":           metrics.NewValueAttribute(16600606),
+		"disk.xvda1.writesMerged":     metrics.NewValueAttribute(7233846),
+		"disk.xvda1.sectorsWritten":   metrics.NewValueAttribute(424712632),
+		"disk.xvda1.writeTime":        metrics.NewValueAttribute(23987908),
+		"disk.xvda1.ioInProgress":     metrics.NewValueAttribute(0),
+		"disk.xvda1.ioTime":           metrics.NewValueAttribute(2355636),
+		"disk.xvda1.ioTimeWeighted":   metrics.NewValueAttribute(24345740),
+		"disk.xvda1.discards":         metrics.NewValueAttribute(0),
+		"disk.xvda1.discardsMerged":   metrics.NewValueAttribute(0),
+		"disk.xvda1.sectorsDiscarded": metrics.NewValueAttribute(0),
+		"disk.xvda1.discardTime":      metrics.NewValueAttribute(0),
+		"disk.loop0.reads":            metrics.NewValueAttribute(15),
+		"disk.loop0.readsMerged":      metrics.NewValueAttribute(0),
+		"disk.loop0.sectorsRead":      metrics.NewValueAttribute(0),
+		"disk.loop0.readTime":         metrics.NewValueAttribute(0),
+		"disk.loop0.writes":           metrics.NewValueAttribute(0),
+		"disk.loop0.writesMerged":     metrics.NewValueAttribute(0),
+		"disk.loop0.sectorsWritten":   metrics.NewValueAttribute(0),
+		"disk.loop0.writeTime":        metrics.NewValueAttribute(0),
+		"disk.loop0.ioInProgress":     metrics.NewValueAttribute(0),
+		"disk.loop0.ioTime":           metrics.NewValueAttribute(0),
+		"disk.loop0.ioTimeWeighted":   metrics.NewValueAttribute(0),
+		"disk.loop0.discards":         metrics.NewValueAttribute(0),
+		"disk.loop0.discardsMerged":   metrics.NewValueAttribute(0),
+		"disk.loop0.sectorsDiscarded": metrics.NewValueAttribute(0),
+		"disk.loop0.discardTime":      metrics.NewValueAttribute(0),
 	}
 	if !reflect.DeepEqual(result, expect) {
 		t.Errorf("result is not expected one: %+v", result)
 	}
 }
 
+func TestParseDiskStats_FlushFields(t *testing.T) {
+	g := &DiskGenerator{Interval: 1 * time.Second}
+	// There are 20 columns since Linux 5.5+.
+	out := []byte(`259       0 nvme0n1 1000 10 80000 500 2000 20 160000 1500 0 1800 2100 30 0 6000 40 50 60`)
+
+	var emptyMapping map[string]string
+	result, err := g.parseDiskStats(out, emptyMapping)
+	if err != nil {
+		t.Errorf("error should be nil but: %s", err)
+	}
+
+	expect := map[string]float64{
+		"disk.nvme0n1.discards":         30,
+		"disk.nvme0n1.sectorsDiscarded": 6000,
+		"disk.nvme0n1.discardTime":      40,
+		"disk.nvme0n1.flushes":          50,
+		"disk.nvme0n1.flushTime":        60,
+	}
+	for name, value := range expect {
+		if result[name].Value != value {
+			t.Errorf("%s should be %f but %+v", name, value, result[name])
+		}
+	}
+	if len(result) != len(diskMetricsNames) {
+		t.Errorf("all columns should be parsed: %+v", result)
+	}
+}
+
+func TestDeriveDetailedValues(t *testing.T) {
+	g := &DiskGenerator{Interval: 10 * time.Second, Detailed: true}
+	prev := metrics.Values{
+		"disk.sda.reads":          metrics.NewValueAttribute(100),
+		"disk.sda.sectorsRead":    metrics.NewValueAttribute(1000),
+		"disk.sda.readTime":       metrics.NewValueAttribute(200),
+		"disk.sda.writes":         metrics.NewValueAttribute(100),
+		"disk.sda.sectorsWritten": metrics.NewValueAttribute(1000),
+		"disk.sda.writeTime":      metrics.NewValueAttribute(200),
+		"disk.sda.ioTime":         metrics.NewValueAttribute(1000),
+		"disk.sda.ioTimeWeighted": metrics.NewValueAttribute(1000),
+	}
+	curr := metrics.Values{
+		"disk.sda.reads":          metrics.NewValueAttribute(150),
+		"disk.sda.sectorsRead":    metrics.NewValueAttribute(3000),
+		"disk.sda.readTime":       metrics.NewValueAttribute(300),
+		"disk.sda.writes":         metrics.NewValueAttribute(100),
+		"disk.sda.sectorsWritten": metrics.NewValueAttribute(1000),
+		"disk.sda.writeTime":      metrics.NewValueAttribute(200),
+		"disk.sda.ioTime":         metrics.NewValueAttribute(6000),
+		"disk.sda.ioTimeWeighted": metrics.NewValueAttribute(26000),
+	}
+
+	result := g.deriveDetailedValues(prev, curr)
+	expect := metrics.Values{
+		"custom.disk.sda.bytes.read":          metrics.NewValueAttribute(2000 * 512 / 10),
+		"custom.disk.sda.bytes.written":       metrics.NewValueAttribute(0),
+		"custom.disk.sda.await.read":          metrics.NewValueAttribute(2),
+		"custom.disk.sda.await.write":         metrics.NewValueAttribute(0),
+		"custom.disk.sda.queue.depth":         metrics.NewValueAttribute(2.5),
+		"custom.disk.sda.utilization.percent": metrics.NewValueAttribute(50),
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("result is not expected one: %+v", result)
+	}
+}
+
+func TestDiskGenerator_PrepareGraphDefs(t *testing.T) {
+	g := &DiskGenerator{}
+	if defs, _ := g.PrepareGraphDefs(); len(defs) != 0 {
+		t.Errorf("graph definitions should be empty unless detailed: %v", defs)
+	}
+
+	g = &DiskGenerator{Detailed: true}
+	defs, err := g.PrepareGraphDefs()
+	if err != nil {
+		t.Fatalf("PrepareGraphDefs() failed: %s", err)
+	}
+	for _, def := range defs {
+		for _, m := range def.Metrics {
+			if !strings.HasPrefix(m.Name, def.Name+".") {
+				t.Errorf("metric %s should belong to the graph %s", m.Name, def.Name)
+			}
+		}
+	}
+}
+
 func TestParseDiskStats_ShouldIgnoreIfAllFieldsAreZeroOrSpecificDeviceName(t *testing.T) {
 	g := &DiskGenerator{Interval: 1 * time.Second}
 	out := []byte(`253       0 dm-0 2 0 40 0 314 0 2512 2136 0 236 2136
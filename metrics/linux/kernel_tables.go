@@ -0,0 +1,232 @@
+//go:build linux
+
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+KernelTablesGenerator collects the usage of the kernel tables which cause packet drops when they are saturated
+
+`custom.conntrack.entries.{count,max}`: nf_conntrack_count and nf_conntrack_max (only when nf_conntrack is loaded)
+`custom.neighbor.entries.{count,max}`: IPv4 neighbor (ARP) entries and the hard limit gc_thresh3
+`custom.entropy.bits.{available,pool_size}`: entropy_avail and poolsize of the random number generator
+`custom.softnet.packets.{processed,dropped,time_squeeze}`: per second retrieved from /proc/net/softnet_stat
+
+`custom.kernel.limit_ratio.{name}`: each of the above as percentage of its limit
+
+name = "conntrack", "neighbor", "softnet_dropped", "softnet_time_squeeze"
+
+The limits of softnet dropped and time_squeeze are the processed packets.
+The softnet values are not posted when the number of the online CPUs changes during the interval.
+*/
+type KernelTablesGenerator struct {
+	Interval time.Duration
+}
+
+var kernelTablesLogger = logging.GetLogger("metrics.kernelTables")
+
+// Generate kernel table metrics
+func (g *KernelTablesGenerator) Generate() (metrics.Values, error) {
+	prev, err := readSoftnetStat()
+	if err != nil {
+		kernelTablesLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	time.Sleep(g.Interval)
+
+	curr, err := readSoftnetStat()
+	if err != nil {
+		kernelTablesLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+	ret := deriveSoftnetValues(prev, curr, g.Interval.Seconds())
+
+	setEntries := func(name string, count, limit uint64) {
+		ret["custom."+name+".entries.count"] = metrics.NewValueAttribute(float64(count))
+		ret["custom."+name+".entries.max"] = metrics.NewValueAttribute(float64(limit))
+		if limit > 0 {
+			ret["custom.kernel.limit_ratio."+name] = metrics.NewValueAttribute(float64(count) * 100 / float64(limit))
+		}
+	}
+
+	// nf_conntrack is not loaded unless any netfilter rules use it
+	count, errCount := readUintFile("sys/net/netfilter/nf_conntrack_count")
+	limit, errLimit := readUintFile("sys/net/netfilter/nf_conntrack_max")
+	if errCount == nil && errLimit == nil {
+		setEntries("conntrack", count, limit)
+	}
+
+	if out, err := os.ReadFile(filepath.Join(procPath, "net/arp")); err != nil {
+		kernelTablesLogger.Warningf("Failed to read arp: %s", err)
+	} else if limit, err := readUintFile("sys/net/ipv4/neigh/default/gc_thresh3"); err != nil {
+		kernelTablesLogger.Warningf("Failed to read gc_thresh3: %s", err)
+	} else {
+		setEntries("neighbor", countArpEntries(out), limit)
+	}
+
+	available, errAvail := readUintFile("sys/kernel/random/entropy_avail")
+	poolSize, errPool := readUintFile("sys/kernel/random/poolsize")
+	if errAvail != nil || errPool != nil {
+		kernelTablesLogger.Warningf("Failed to read entropy: %v, %v", errAvail, errPool)
+	} else {
+		ret["custom.entropy.bits.available"] = metrics.NewValueAttribute(float64(available))
+		ret["custom.entropy.bits.pool_size"] = metrics.NewValueAttribute(float64(poolSize))
+	}
+	return ret, nil
+}
+
+func readUintFile(name string) (uint64, error) {
+	out, err := os.ReadFile(filepath.Join(procPath, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// countArpEntries counts the entries of /proc/net/arp except the header line.
+func countArpEntries(out []byte) uint64 {
+	var n uint64
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return n - 1
+}
+
+// softnetStat is the 32-bit counters of a CPU.
+type softnetStat struct {
+	processed   uint32
+	dropped     uint32
+	timeSqueeze uint32
+}
+
+func readSoftnetStat() ([]softnetStat, error) {
+	out, err := os.ReadFile(filepath.Join(procPath, "net/softnet_stat"))
+	if err != nil {
+		return nil, err
+	}
+	return parseSoftnetStat(out)
+}
+
+/*
+cat /proc/net/softnet_stat sample (a line per CPU in hexadecimal):
+
+	0000108e 00000000 00000003 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+	00002f1c 00000001 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+
+The columns are processed, dropped and time_squeeze in order.
+*/
+func parseSoftnetStat(out []byte) ([]softnetStat, error) {
+	var stats []softnetStat
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		var values [3]uint32
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i], 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse /proc/net/softnet_stat: %s", err)
+			}
+			values[i] = uint32(v)
+		}
+		stats = append(stats, softnetStat{processed: values[0], dropped: values[1], timeSqueeze: values[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func deriveSoftnetValues(prev, curr []softnetStat, seconds float64) metrics.Values {
+	// the rows cannot be matched to the CPUs when any CPU goes online or offline
+	if len(prev) != len(curr) {
+		kernelTablesLogger.Debugf("The number of the CPUs in softnet_stat changed from %d to %d", len(prev), len(curr))
+		return metrics.Values{}
+	}
+	// the counters wrap around at 32 bits per CPU, so they are diffed per CPU before summed up
+	var processed, dropped, timeSqueeze uint64
+	for i := range curr {
+		processed += uint64(curr[i].processed - prev[i].processed)
+		dropped += uint64(curr[i].dropped - prev[i].dropped)
+		timeSqueeze += uint64(curr[i].timeSqueeze - prev[i].timeSqueeze)
+	}
+	ret := metrics.Values{
+		"custom.softnet.packets.processed":    metrics.NewValueAttribute(float64(processed) / seconds),
+		"custom.softnet.packets.dropped":      metrics.NewValueAttribute(float64(dropped) / seconds),
+		"custom.softnet.packets.time_squeeze": metrics.NewValueAttribute(float64(timeSqueeze) / seconds),
+	}
+	if processed > 0 {
+		ret["custom.kernel.limit_ratio.softnet_dropped"] = metrics.NewValueAttribute(float64(dropped) * 100 / float64(processed))
+		ret["custom.kernel.limit_ratio.softnet_time_squeeze"] = metrics.NewValueAttribute(float64(timeSqueeze) * 100 / float64(processed))
+	}
+	return ret
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *KernelTablesGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"conntrack.entries": {
+			Label: "Conntrack Entries",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Count"},
+				{Name: "max", Label: "Max"},
+			},
+		},
+		"neighbor.entries": {
+			Label: "Neighbor Table Entries",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Count"},
+				{Name: "max", Label: "Max (gc_thresh3)"},
+			},
+		},
+		"entropy.bits": {
+			Label: "Entropy",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "available", Label: "Available"},
+				{Name: "pool_size", Label: "Pool size"},
+			},
+		},
+		"softnet.packets": {
+			Label: "Softnet Packets",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "processed", Label: "Processed"},
+				{Name: "dropped", Label: "Dropped"},
+				{Name: "time_squeeze", Label: "Time squeeze"},
+			},
+		},
+		"kernel.limit_ratio": {
+			Label: "Kernel Table Usage",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "conntrack", Label: "Conntrack"},
+				{Name: "neighbor", Label: "Neighbor"},
+				{Name: "softnet_dropped", Label: "Softnet dropped"},
+				{Name: "softnet_time_squeeze", Label: "Softnet time squeeze"},
+			},
+		},
+	}), nil
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+package linux
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKernelTablesGenerator(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"sys/net/netfilter/nf_conntrack_count":  "49152\n",
+		"sys/net/netfilter/nf_conntrack_max":    "65536\n",
+		"sys/net/ipv4/neigh/default/gc_thresh3": "1024\n",
+		"sys/kernel/random/entropy_avail":       "64\n",
+		"sys/kernel/random/poolsize":            "256\n",
+		"net/arp": `IP address       HW type     Flags       HW address            Mask     Device
+192.0.2.1        0x1         0x2         02:fc:00:00:00:05     *        eth0
+192.0.2.2        0x1         0x2         02:fc:00:00:00:06     *        eth0
+`,
+		"net/softnet_stat": "0000108e 00000000 00000003 00000000 00000000\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(orig string) { procPath = orig }(procPath)
+	procPath = dir
+
+	g := &KernelTablesGenerator{Interval: 10 * time.Millisecond}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := map[string]float64{
+		"custom.conntrack.entries.count":      49152,
+		"custom.conntrack.entries.max":        65536,
+		"custom.kernel.limit_ratio.conntrack": 75,
+		"custom.neighbor.entries.count":       2,
+		"custom.neighbor.entries.max":         1024,
+		"custom.entropy.bits.available":       64,
+		"custom.softnet.packets.dropped":      0,
+	}
+	for name, v := range expect {
+		value, ok := values[name]
+		if !ok {
+			t.Errorf("%s should be collected", name)
+			continue
+		}
+		if value.Value != v {
+			t.Errorf("%s should be %f but %f", name, v, value.Value)
+		}
+	}
+	if _, ok := values["custom.kernel.limit_ratio.softnet_dropped"]; ok {
+		t.Errorf("softnet ratio should not be collected without processed packets")
+	}
+	if _, ok := values["custom.kernel.limit_ratio.entropy"]; ok {
+		t.Errorf("entropy should not be collected as a limit ratio")
+	}
+}
+
+func TestKernelTablesGenerator_WithoutConntrack(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "net/softnet_stat"), []byte("00000001 00000000 00000000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { procPath = orig }(procPath)
+	procPath = dir
+
+	g := &KernelTablesGenerator{Interval: 10 * time.Millisecond}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if _, ok := values["custom.conntrack.entries.count"]; ok {
+		t.Errorf("conntrack should not be collected without nf_conntrack: %+v", values)
+	}
+}
+
+func TestParseSoftnetStat(t *testing.T) {
+	out := []byte(`0000108e 00000000 00000003 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+00002f1c 00000001 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+`)
+	stat, err := parseSoftnetStat(out)
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := []softnetStat{{processed: 0x108e, timeSqueeze: 3}, {processed: 0x2f1c, dropped: 1}}
+	if !reflect.DeepEqual(stat, expect) {
+		t.Errorf("softnet stat should be %+v but %+v", expect, stat)
+	}
+
+	if _, err := parseSoftnetStat([]byte("zzzz 0 0\n")); err == nil {
+		t.Errorf("error should be returned for invalid values")
+	}
+}
+
+func TestDeriveSoftnetValues(t *testing.T) {
+	// the processed of the second CPU wraps around
+	prev := []softnetStat{{processed: 1000, dropped: 1, timeSqueeze: 10}, {processed: math.MaxUint32 - 499}}
+	curr := []softnetStat{{processed: 2000, dropped: 21, timeSqueeze: 50}, {processed: 500}}
+	values := deriveSoftnetValues(prev, curr, 10)
+	expect := map[string]float64{
+		"custom.softnet.packets.processed":               200,
+		"custom.softnet.packets.dropped":                 2,
+		"custom.softnet.packets.time_squeeze":            4,
+		"custom.kernel.limit_ratio.softnet_dropped":      1,
+		"custom.kernel.limit_ratio.softnet_time_squeeze": 2,
+	}
+	for name, v := range expect {
+		if value, ok := values[name]; !ok || value.Value != v {
+			t.Errorf("%s should be %f but %+v", name, v, value)
+		}
+	}
+
+	// a CPU goes offline
+	values = deriveSoftnetValues(prev, curr[:1], 10)
+	if len(values) != 0 {
+		t.Errorf("softnet values should not be collected when the number of the CPUs changes but %+v", values)
+	}
+}
@@ -0,0 +1,249 @@
+//go:build linux
+
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+MemoryDetailGenerator collects the breakdown of memory usage from /proc/meminfo and the paging activities from /proc/vmstat
+
+`custom.memory.slab.{reclaimable,unreclaimable}`: SReclaimable and SUnreclaim in bytes
+`custom.memory.dirty.{dirty,writeback}`: Dirty and Writeback in bytes
+`custom.memory.lru.{active_anon,inactive_anon,active_file,inactive_file}`: the LRU lists in bytes
+`custom.memory.hugepages.{total,used,free}`: the size of the persistent huge pages in bytes
+`custom.memory.page_faults.{minor,major}`: page faults per second
+`custom.memory.swap_io.{in,out}`: bytes per second swapped in and out
+`custom.memory.oom_kill.count`: OOM kills during the interval (Linux 4.13 or later)
+*/
+type MemoryDetailGenerator struct {
+	Interval time.Duration
+}
+
+// the keys of /proc/meminfo and the metric names
+var meminfoMetricNames = map[string]string{
+	"SReclaimable":   "slab.reclaimable",
+	"SUnreclaim":     "slab.unreclaimable",
+	"Dirty":          "dirty.dirty",
+	"Writeback":      "dirty.writeback",
+	"Active(anon)":   "lru.active_anon",
+	"Inactive(anon)": "lru.inactive_anon",
+	"Active(file)":   "lru.active_file",
+	"Inactive(file)": "lru.inactive_file",
+}
+
+// Generate detailed memory values
+func (g *MemoryDetailGenerator) Generate() (metrics.Values, error) {
+	prev, err := readVmstat()
+	if err != nil {
+		memoryLogger.Errorf("Failed to read vmstat (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	time.Sleep(g.Interval)
+
+	curr, err := readVmstat()
+	if err != nil {
+		memoryLogger.Errorf("Failed to read vmstat (skip these metrics): %s", err)
+		return nil, err
+	}
+	ret := deriveVmstatValues(prev, curr, g.Interval.Seconds())
+
+	out, err := os.ReadFile(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		memoryLogger.Warningf("Failed to read meminfo: %s", err)
+		return ret, nil
+	}
+	meminfo, err := parseMeminfo(out)
+	if err != nil {
+		memoryLogger.Warningf("Failed to parse meminfo: %s", err)
+		return ret, nil
+	}
+	for key, name := range meminfoMetricNames {
+		if v, ok := meminfo[key]; ok {
+			ret["custom.memory."+name] = metrics.NewValueAttribute(float64(v))
+		}
+	}
+	// HugePages_* are the numbers of pages, not sizes
+	if size, ok := meminfo["Hugepagesize"]; ok {
+		total, free := meminfo["HugePages_Total"], meminfo["HugePages_Free"]
+		ret["custom.memory.hugepages.total"] = metrics.NewValueAttribute(float64(total * size))
+		ret["custom.memory.hugepages.free"] = metrics.NewValueAttribute(float64(free * size))
+		ret["custom.memory.hugepages.used"] = metrics.NewValueAttribute(float64(subtractOrZero(total, free) * size))
+	}
+	return ret, nil
+}
+
+func readVmstat() (map[string]uint64, error) {
+	out, err := os.ReadFile(filepath.Join(procPath, "vmstat"))
+	if err != nil {
+		return nil, err
+	}
+	return parseVmstat(out)
+}
+
+/*
+cat /proc/meminfo sample:
+
+	MemTotal:        8029460 kB
+	Dirty:               100 kB
+	Writeback:             0 kB
+	HugePages_Total:       0
+	HugePages_Free:        0
+	Hugepagesize:       2048 kB
+
+The values with "kB" are converted to bytes.
+*/
+func parseMeminfo(out []byte) (map[string]uint64, error) {
+	ret := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s of /proc/meminfo: %s", key, err)
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+		ret[key] = v
+	}
+	return ret, scanner.Err()
+}
+
+/*
+cat /proc/vmstat sample:
+
+	pgfault 1181236
+	pgmajfault 1340
+	pswpin 0
+	pswpout 0
+	oom_kill 0
+*/
+func parseVmstat(out []byte) (map[string]uint64, error) {
+	ret := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s of /proc/vmstat: %s", fields[0], err)
+		}
+		ret[fields[0]] = v
+	}
+	return ret, scanner.Err()
+}
+
+func deriveVmstatValues(prev, curr map[string]uint64, seconds float64) metrics.Values {
+	diff := func(key string) uint64 {
+		return util.DiffResettableCounter(curr[key], prev[key])
+	}
+	// pgfault includes major faults
+	major := diff("pgmajfault")
+	minor := subtractOrZero(diff("pgfault"), major)
+	ret := metrics.Values{
+		"custom.memory.page_faults.minor": metrics.NewValueAttribute(float64(minor) / seconds),
+		"custom.memory.page_faults.major": metrics.NewValueAttribute(float64(major) / seconds),
+		"custom.memory.swap_io.in":        metrics.NewValueAttribute(float64(diff("pswpin")*uint64(pageSize)) / seconds),
+		"custom.memory.swap_io.out":       metrics.NewValueAttribute(float64(diff("pswpout")*uint64(pageSize)) / seconds),
+	}
+	if _, ok := curr["oom_kill"]; ok {
+		ret["custom.memory.oom_kill.count"] = metrics.NewValueAttribute(float64(diff("oom_kill")))
+	}
+	return ret
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *MemoryDetailGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"memory.slab": {
+			Label: "Memory Slab",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "reclaimable", Label: "Reclaimable", Stacked: true},
+				{Name: "unreclaimable", Label: "Unreclaimable", Stacked: true},
+			},
+		},
+		"memory.dirty": {
+			Label: "Memory Dirty Pages",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "dirty", Label: "Dirty", Stacked: true},
+				{Name: "writeback", Label: "Writeback", Stacked: true},
+			},
+		},
+		"memory.lru": {
+			Label: "Memory Anon/File",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "active_anon", Label: "Active (anon)", Stacked: true},
+				{Name: "inactive_anon", Label: "Inactive (anon)", Stacked: true},
+				{Name: "active_file", Label: "Active (file)", Stacked: true},
+				{Name: "inactive_file", Label: "Inactive (file)", Stacked: true},
+			},
+		},
+		"memory.hugepages": {
+			Label: "Memory Huge Pages",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "used", Label: "Used", Stacked: true},
+				{Name: "free", Label: "Free", Stacked: true},
+				{Name: "total", Label: "Total"},
+			},
+		},
+		"memory.page_faults": {
+			Label: "Page Faults",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "minor", Label: "Minor", Stacked: true},
+				{Name: "major", Label: "Major", Stacked: true},
+			},
+		},
+		"memory.swap_io": {
+			Label: "Swap I/O",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "in", Label: "Swap in"},
+				{Name: "out", Label: "Swap out"},
+			},
+		},
+		"memory.oom_kill": {
+			Label: "OOM Kills",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Count"},
+			},
+		},
+	}), nil
+}
+
+// subtractOrZero returns a - b or 0 if b is larger.
+// Unlike util.DiffResettableCounter, this is for the values which are not resettable counters.
+func subtractOrZero(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryDetailGenerator(t *testing.T) {
+	dir := t.TempDir()
+	meminfo := `MemTotal:        8029460 kB
+SReclaimable:     102400 kB
+SUnreclaim:        51200 kB
+Dirty:               100 kB
+Writeback:             0 kB
+Active(anon):     409600 kB
+Inactive(anon):   204800 kB
+Active(file):     819200 kB
+Inactive(file):   614400 kB
+HugePages_Total:      16
+HugePages_Free:       10
+Hugepagesize:       2048 kB
+`
+	vmstat := `pgfault 1181236
+pgmajfault 1340
+pswpin 0
+pswpout 0
+oom_kill 2
+`
+	for name, content := range map[string]string{"meminfo": meminfo, "vmstat": vmstat} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(orig string) { procPath = orig }(procPath)
+	procPath = dir
+
+	g := &MemoryDetailGenerator{Interval: 10 * time.Millisecond}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := map[string]float64{
+		"custom.memory.slab.reclaimable":   102400 * 1024,
+		"custom.memory.slab.unreclaimable": 51200 * 1024,
+		"custom.memory.dirty.dirty":        100 * 1024,
+		"custom.memory.dirty.writeback":    0,
+		"custom.memory.lru.active_anon":    409600 * 1024,
+		"custom.memory.lru.inactive_file":  614400 * 1024,
+		"custom.memory.hugepages.total":    16 * 2048 * 1024,
+		"custom.memory.hugepages.used":     6 * 2048 * 1024,
+		"custom.memory.hugepages.free":     10 * 2048 * 1024,
+		"custom.memory.page_faults.minor":  0,
+		"custom.memory.swap_io.in":         0,
+		"custom.memory.oom_kill.count":     0,
+	}
+	for name, v := range expect {
+		value, ok := values[name]
+		if !ok {
+			t.Errorf("%s should be collected", name)
+			continue
+		}
+		if value.Value != v {
+			t.Errorf("%s should be %f but %f", name, v, value.Value)
+		}
+	}
+}
+
+func TestDeriveVmstatValues(t *testing.T) {
+	prev := map[string]uint64{"pgfault": 1000, "pgmajfault": 10, "pswpin": 0, "pswpout": 10}
+	curr := map[string]uint64{"pgfault": 1520, "pgmajfault": 30, "pswpin": 5, "pswpout": 30}
+	values := deriveVmstatValues(prev, curr, 10)
+
+	expect := map[string]float64{
+		"custom.memory.page_faults.minor": 50,
+		"custom.memory.page_faults.major": 2,
+		"custom.memory.swap_io.in":        float64(pageSize) / 2,
+		"custom.memory.swap_io.out":       float64(pageSize) * 2,
+	}
+	for name, v := range expect {
+		if value, ok := values[name]; !ok || value.Value != v {
+			t.Errorf("%s should be %f but %+v", name, v, value)
+		}
+	}
+	if _, ok := values["custom.memory.oom_kill.count"]; ok {
+		t.Errorf("oom_kill should not be collected on old kernels")
+	}
+
+	prev["oom_kill"], curr["oom_kill"] = 1, 3
+	values = deriveVmstatValues(prev, curr, 10)
+	if v, ok := values["custom.memory.oom_kill.count"]; !ok || v.Value != 2 {
+		t.Errorf("oom_kill.count should be 2 but %+v", v)
+	}
+
+	// pgfault has been reset and is smaller than the major faults
+	curr["pgfault"] = 5
+	values = deriveVmstatValues(prev, curr, 10)
+	if v := values["custom.memory.page_faults.minor"]; v.Value != 0 {
+		t.Errorf("page_faults.minor should be 0 but %+v", v)
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	meminfo, err := parseMeminfo([]byte("Dirty:               100 kB\nHugePages_Total:      16\n"))
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if meminfo["Dirty"] != 100*1024 || meminfo["HugePages_Total"] != 16 {
+		t.Errorf("unexpected meminfo: %v", meminfo)
+	}
+	if _, err := parseMeminfo([]byte("Dirty: x kB\n")); err == nil {
+		t.Errorf("error should be returned for invalid values")
+	}
+}
@@ -0,0 +1,350 @@
+//go:build linux
+
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+NFSGenerator collects the statistics of NFS mounts from /proc/self/mountstats,
+and the NFS client and server from /proc/net/rpc/nfs and /proc/net/rpc/nfsd
+
+For each NFS mount:
+
+`custom.nfs.mount.{mount}.ops.{read,write,total}`: operations per second
+`custom.nfs.mount.{mount}.bytes.{read,written}`: bytes per second transferred by READ and WRITE
+`custom.nfs.mount.{mount}.rtt.{read,write}`: average round trip time of READ and WRITE in milliseconds
+`custom.nfs.mount.{mount}.exec.{read,write}`: average execute time (including the queueing) of READ and WRITE in milliseconds
+`custom.nfs.mount.{mount}.retransmissions.total`: retransmissions per second
+
+mount = the mountpoint sanitized by util.SanitizeMetricKey (e.g. "_mnt_nfs")
+
+`custom.nfs.client.rpc.{calls,retransmissions}`: RPC calls per second of the client
+`custom.nfs.server.rpc.{calls,bad_calls}`: RPC calls per second of the server (only when nfsd is running)
+`custom.nfs.server.io.{read,written}`: bytes per second read and written by the server
+
+The mounts whose device (e.g. "server:/export") or mountpoint matches IgnoreRegexp are skipped.
+*/
+type NFSGenerator struct {
+	IgnoreRegexp *regexp.Regexp
+	Interval     time.Duration
+}
+
+var nfsLogger = logging.GetLogger("metrics.nfs")
+
+// Generate NFS metrics
+func (g *NFSGenerator) Generate() (metrics.Values, error) {
+	prev, err := g.collect()
+	if err != nil {
+		nfsLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	time.Sleep(g.Interval)
+
+	curr, err := g.collect()
+	if err != nil {
+		nfsLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+	return deriveNFSValues(prev, curr, g.Interval.Seconds()), nil
+}
+
+type nfsStats struct {
+	mounts map[string]*nfsMountStats // keyed by the mountpoints
+	client map[string][]uint64       // the lines of /proc/net/rpc/nfs keyed by the first fields
+	server map[string][]uint64       // the lines of /proc/net/rpc/nfsd keyed by the first fields
+}
+
+func (g *NFSGenerator) collect() (*nfsStats, error) {
+	out, err := os.ReadFile(filepath.Join(procPath, "self/mountstats"))
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := parseMountstats(out)
+	if err != nil {
+		return nil, err
+	}
+	stats := &nfsStats{mounts: make(map[string]*nfsMountStats, len(mounts))}
+	for _, m := range mounts {
+		if g.IgnoreRegexp != nil && (g.IgnoreRegexp.MatchString(m.device) || g.IgnoreRegexp.MatchString(m.mountpoint)) {
+			continue
+		}
+		stats.mounts[m.mountpoint] = m
+	}
+	// These files do not exist when the NFS client or server module is not loaded.
+	if out, err := os.ReadFile(filepath.Join(procPath, "net/rpc/nfs")); err == nil {
+		stats.client = parseRPCStats(out)
+	}
+	if out, err := os.ReadFile(filepath.Join(procPath, "net/rpc/nfsd")); err == nil {
+		stats.server = parseRPCStats(out)
+	}
+	return stats, nil
+}
+
+// nfsOpStats is the per-op statistics of an NFS mount.
+type nfsOpStats struct {
+	ops           uint64
+	transmissions uint64
+	bytesSent     uint64
+	bytesReceived uint64
+	rtt           uint64 // cumulative milliseconds
+	exec          uint64 // cumulative milliseconds
+}
+
+type nfsMountStats struct {
+	device     string
+	mountpoint string
+	ops        map[string]*nfsOpStats // keyed by the operation names such as "READ"
+}
+
+/*
+cat /proc/self/mountstats sample (some lines are omitted):
+
+	device /dev/sda1 mounted on / with fstype ext4
+	device server:/export mounted on /mnt/nfs with fstype nfs4 statvers=1.1
+		opts:	rw,vers=4.2,rsize=1048576,wsize=1048576
+		RPC iostats version: 1.1  p/v: 100003/4 (nfs)
+		xprt:	tcp 0 1 2 0 11 27 27 0 27 0 2 0 0
+		per-op statistics
+		        NULL: 1 1 0 44 24 0 0 0 0
+		        READ: 100 102 0 15200 1654000 20 300 350 0
+		       WRITE: 50 50 0 1654000 8000 100 200 400 0
+
+The fields of per-op statistics are operations, transmissions, major timeouts, bytes sent, bytes received,
+cumulative queue time, cumulative response time (RTT) and cumulative total request time (execute time), and errors (since Linux 5.3).
+*/
+func parseMountstats(out []byte) ([]*nfsMountStats, error) {
+	var mounts []*nfsMountStats
+	var mount *nfsMountStats
+	perOp := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "device" {
+			mount, perOp = nil, false
+			// device {device} mounted on {mountpoint} with fstype {fstype}
+			if len(fields) >= 8 && strings.HasPrefix(fields[7], "nfs") {
+				mount = &nfsMountStats{device: fields[1], mountpoint: fields[4], ops: make(map[string]*nfsOpStats)}
+				mounts = append(mounts, mount)
+			}
+			continue
+		}
+		if fields[0] == "per-op" {
+			perOp = true
+			continue
+		}
+		if mount == nil || !perOp || len(fields) < 9 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		var values [8]uint64
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse per-op statistics of %s: %s", mount.mountpoint, err)
+			}
+			values[i] = v
+		}
+		mount.ops[strings.TrimSuffix(fields[0], ":")] = &nfsOpStats{
+			ops:           values[0],
+			transmissions: values[1],
+			bytesSent:     values[3],
+			bytesReceived: values[4],
+			rtt:           values[6],
+			exec:          values[7],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+/*
+cat /proc/net/rpc/nfsd sample (the lines not used are omitted):
+
+	io 1654000 8000
+	rpc 100 0 0 0 0
+	proc4 2 1 99
+
+The values which are not unsigned integers are skipped.
+*/
+func parseRPCStats(out []byte) map[string][]uint64 {
+	ret := make(map[string][]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		values := make([]uint64, 0, len(fields)-1)
+		for _, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				break
+			}
+			values = append(values, v)
+		}
+		ret[fields[0]] = values
+	}
+	return ret
+}
+
+func deriveNFSValues(prev, curr *nfsStats, seconds float64) metrics.Values {
+	ret := make(metrics.Values)
+	for mountpoint, c := range curr.mounts {
+		p, ok := prev.mounts[mountpoint]
+		if !ok {
+			continue
+		}
+		prefix := "custom.nfs.mount." + util.SanitizeMetricKey(mountpoint) + "."
+		diff := func(op string, field func(*nfsOpStats) uint64) uint64 {
+			co, ok1 := c.ops[op]
+			po, ok2 := p.ops[op]
+			if !ok1 || !ok2 {
+				return 0
+			}
+			return util.DiffResettableCounter(field(co), field(po))
+		}
+		ops := func(s *nfsOpStats) uint64 { return s.ops }
+		transmissions := func(s *nfsOpStats) uint64 { return s.transmissions }
+		var total, retrans uint64
+		for op := range c.ops {
+			n, t := diff(op, ops), diff(op, transmissions)
+			total += n
+			if t > n {
+				retrans += t - n
+			}
+		}
+		reads, writes := diff("READ", ops), diff("WRITE", ops)
+		ret[prefix+"ops.read"] = metrics.NewValueAttribute(float64(reads) / seconds)
+		ret[prefix+"ops.write"] = metrics.NewValueAttribute(float64(writes) / seconds)
+		ret[prefix+"ops.total"] = metrics.NewValueAttribute(float64(total) / seconds)
+		ret[prefix+"bytes.read"] = metrics.NewValueAttribute(float64(diff("READ", func(s *nfsOpStats) uint64 { return s.bytesReceived })) / seconds)
+		ret[prefix+"bytes.written"] = metrics.NewValueAttribute(float64(diff("WRITE", func(s *nfsOpStats) uint64 { return s.bytesSent })) / seconds)
+		ret[prefix+"retransmissions.total"] = metrics.NewValueAttribute(float64(retrans) / seconds)
+
+		// the averages are not defined without operations
+		for op, n := range map[string]uint64{"read": reads, "write": writes} {
+			if n == 0 {
+				continue
+			}
+			name := strings.ToUpper(op)
+			ret[prefix+"rtt."+op] = metrics.NewValueAttribute(float64(diff(name, func(s *nfsOpStats) uint64 { return s.rtt })) / float64(n))
+			ret[prefix+"exec."+op] = metrics.NewValueAttribute(float64(diff(name, func(s *nfsOpStats) uint64 { return s.exec })) / float64(n))
+		}
+	}
+
+	// rpc {calls} {retransmissions} {authrefresh}
+	if calls, retrans, ok := diffRPCStats(prev.client, curr.client, "rpc", 0, 1); ok {
+		ret["custom.nfs.client.rpc.calls"] = metrics.NewValueAttribute(float64(calls) / seconds)
+		ret["custom.nfs.client.rpc.retransmissions"] = metrics.NewValueAttribute(float64(retrans) / seconds)
+	}
+	// rpc {calls} {badcalls} {badfmt} {badauth} {badclnt}
+	if calls, bad, ok := diffRPCStats(prev.server, curr.server, "rpc", 0, 1); ok {
+		ret["custom.nfs.server.rpc.calls"] = metrics.NewValueAttribute(float64(calls) / seconds)
+		ret["custom.nfs.server.rpc.bad_calls"] = metrics.NewValueAttribute(float64(bad) / seconds)
+	}
+	// io {bytes read} {bytes written}
+	if read, written, ok := diffRPCStats(prev.server, curr.server, "io", 0, 1); ok {
+		ret["custom.nfs.server.io.read"] = metrics.NewValueAttribute(float64(read) / seconds)
+		ret["custom.nfs.server.io.written"] = metrics.NewValueAttribute(float64(written) / seconds)
+	}
+	return ret
+}
+
+// diffRPCStats returns the differences of the i-th and j-th values of the line.
+func diffRPCStats(prev, curr map[string][]uint64, key string, i, j int) (uint64, uint64, bool) {
+	p, c := prev[key], curr[key]
+	if len(p) <= max(i, j) || len(c) <= max(i, j) {
+		return 0, 0, false
+	}
+	return util.DiffResettableCounter(c[i], p[i]), util.DiffResettableCounter(c[j], p[j]), true
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *NFSGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"nfs.mount.#.ops": {
+			Label: "NFS Mount Operations",
+			Unit:  "iops",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "write", Label: "Write"},
+				{Name: "total", Label: "Total"},
+			},
+		},
+		"nfs.mount.#.bytes": {
+			Label: "NFS Mount Throughput",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "written", Label: "Written"},
+			},
+		},
+		"nfs.mount.#.rtt": {
+			Label: "NFS Mount RTT",
+			Unit:  "milliseconds",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "write", Label: "Write"},
+			},
+		},
+		"nfs.mount.#.exec": {
+			Label: "NFS Mount Execute Time",
+			Unit:  "milliseconds",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "write", Label: "Write"},
+			},
+		},
+		"nfs.mount.#.retransmissions": {
+			Label: "NFS Mount Retransmissions",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "total", Label: "Retransmissions per second"},
+			},
+		},
+		"nfs.client.rpc": {
+			Label: "NFS Client RPC",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "calls", Label: "Calls"},
+				{Name: "retransmissions", Label: "Retransmissions"},
+			},
+		},
+		"nfs.server.rpc": {
+			Label: "NFS Server RPC",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "calls", Label: "Calls"},
+				{Name: "bad_calls", Label: "Bad calls"},
+			},
+		},
+		"nfs.server.io": {
+			Label: "NFS Server I/O",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "written", Label: "Written"},
+			},
+		},
+	}), nil
+}
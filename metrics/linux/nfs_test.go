@@ -0,0 +1,142 @@
+//go:build linux
+
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var mountstatsSample = []byte(`device /dev/sda1 mounted on / with fstype ext4
+device server:/export mounted on /mnt/nfs with fstype nfs4 statvers=1.1
+	opts:	rw,vers=4.2,rsize=1048576,wsize=1048576
+	RPC iostats version: 1.1  p/v: 100003/4 (nfs)
+	xprt:	tcp 0 1 2 0 11 27 27 0 27 0 2 0 0
+	per-op statistics
+	        NULL: 1 1 0 44 24 0 0 0 0
+	        READ: 100 102 0 15200 1654000 20 300 350 0
+	       WRITE: 50 50 0 1654000 8000 100 200 400 0
+device backup:/data mounted on /mnt/backup with fstype nfs statvers=1.1
+	per-op statistics
+	        READ: 1 1 0 10 10 0 1 1
+`)
+
+func TestParseMountstats(t *testing.T) {
+	mounts, err := parseMountstats(mountstatsSample)
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if len(mounts) != 2 {
+		t.Fatalf("only NFS mounts should be parsed: %+v", mounts)
+	}
+	m := mounts[0]
+	if m.device != "server:/export" || m.mountpoint != "/mnt/nfs" {
+		t.Errorf("unexpected mount: %+v", m)
+	}
+	expect := nfsOpStats{ops: 100, transmissions: 102, bytesSent: 15200, bytesReceived: 1654000, rtt: 300, exec: 350}
+	if !reflect.DeepEqual(*m.ops["READ"], expect) {
+		t.Errorf("READ should be %+v but %+v", expect, *m.ops["READ"])
+	}
+	if len(m.ops) != 3 {
+		t.Errorf("all operations should be parsed: %+v", m.ops)
+	}
+}
+
+func TestParseRPCStats(t *testing.T) {
+	stats := parseRPCStats([]byte("rc 0 12 34\nth 8 0 0.000 0.000\nio 1654000 8000\nrpc 100 0 0 0 0\n"))
+	expect := map[string][]uint64{
+		"rc":  {0, 12, 34},
+		"th":  {8, 0},
+		"io":  {1654000, 8000},
+		"rpc": {100, 0, 0, 0, 0},
+	}
+	if !reflect.DeepEqual(stats, expect) {
+		t.Errorf("stats should be %v but %v", expect, stats)
+	}
+}
+
+func TestDeriveNFSValues(t *testing.T) {
+	prev := &nfsStats{
+		mounts: map[string]*nfsMountStats{
+			"/mnt/nfs": {ops: map[string]*nfsOpStats{
+				"READ":    {ops: 100, transmissions: 100, bytesReceived: 1000, rtt: 300, exec: 350},
+				"WRITE":   {ops: 50, transmissions: 50, bytesSent: 500, rtt: 200, exec: 400},
+				"GETATTR": {ops: 10, transmissions: 10},
+			}},
+		},
+		client: map[string][]uint64{"rpc": {1000, 1, 0}},
+		server: map[string][]uint64{"io": {0, 0}, "rpc": {10, 0, 0, 0, 0}},
+	}
+	curr := &nfsStats{
+		mounts: map[string]*nfsMountStats{
+			"/mnt/nfs": {ops: map[string]*nfsOpStats{
+				"READ":    {ops: 120, transmissions: 122, bytesReceived: 21000, rtt: 400, exec: 470},
+				"WRITE":   {ops: 50, transmissions: 50, bytesSent: 500, rtt: 200, exec: 400},
+				"GETATTR": {ops: 30, transmissions: 30},
+			}},
+		},
+		client: map[string][]uint64{"rpc": {1100, 3, 0}},
+		server: map[string][]uint64{"io": {10000, 20000}, "rpc": {30, 10, 0, 0, 0}},
+	}
+	values := deriveNFSValues(prev, curr, 10)
+	expect := map[string]float64{
+		"custom.nfs.mount._mnt_nfs.ops.read":              2,
+		"custom.nfs.mount._mnt_nfs.ops.write":             0,
+		"custom.nfs.mount._mnt_nfs.ops.total":             4,
+		"custom.nfs.mount._mnt_nfs.bytes.read":            2000,
+		"custom.nfs.mount._mnt_nfs.bytes.written":         0,
+		"custom.nfs.mount._mnt_nfs.rtt.read":              5,
+		"custom.nfs.mount._mnt_nfs.exec.read":             6,
+		"custom.nfs.mount._mnt_nfs.retransmissions.total": 0.2,
+		"custom.nfs.client.rpc.calls":                     10,
+		"custom.nfs.client.rpc.retransmissions":           0.2,
+		"custom.nfs.server.rpc.calls":                     2,
+		"custom.nfs.server.rpc.bad_calls":                 1,
+		"custom.nfs.server.io.read":                       1000,
+		"custom.nfs.server.io.written":                    2000,
+	}
+	for name, v := range expect {
+		if value, ok := values[name]; !ok || value.Value != v {
+			t.Errorf("%s should be %f but %+v", name, v, value)
+		}
+	}
+	if _, ok := values["custom.nfs.mount._mnt_nfs.rtt.write"]; ok {
+		t.Errorf("rtt.write should not be collected without WRITE operations")
+	}
+}
+
+func TestNFSGenerator_IgnoreRegexp(t *testing.T) {
+	dir := t.TempDir()
+	writeProcFile(t, dir, "self/mountstats", string(mountstatsSample))
+	defer func(orig string) { procPath = orig }(procPath)
+	procPath = dir
+
+	g := &NFSGenerator{IgnoreRegexp: regexp.MustCompile(`^backup:`)}
+	stats, err := g.collect()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if _, ok := stats.mounts["/mnt/backup"]; ok {
+		t.Errorf("/mnt/backup should be ignored")
+	}
+	if _, ok := stats.mounts["/mnt/nfs"]; !ok {
+		t.Errorf("/mnt/nfs should be collected")
+	}
+	if stats.client != nil || stats.server != nil {
+		t.Errorf("rpc stats should be nil without the nfs modules")
+	}
+}
+
+func writeProcFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
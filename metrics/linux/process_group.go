@@ -0,0 +1,244 @@
+//go:build linux
+
+package linux
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/config"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+ProcessGroupGenerator collects resource usage of the configured process groups from /proc/{pid}
+
+`custom.process.{group}.cpu.{user,system}`: CPU time as percentage of a single core
+`custom.process.{group}.memory.rss`: resident set size in bytes
+`custom.process.{group}.fds.open`: the number of open file descriptors
+`custom.process.{group}.io.{read,write}`: bytes per second read from and written to the storage
+`custom.process.{group}.instances.count`: the number of the matched processes
+
+group = the key of [process_group.{group}] sanitized by util.SanitizeMetricKey
+*/
+type ProcessGroupGenerator struct {
+	Groups   map[string]*config.ProcessGroup
+	Interval time.Duration
+}
+
+var processGroupLogger = logging.GetLogger("metrics.processGroup")
+
+// USER_HZ, in which CPU times in /proc/{pid}/stat are expressed, is 100 on all supported architectures.
+const clockTicksPerSecond = 100
+
+var pageSize = os.Getpagesize()
+
+// processSample is the resource usage of a process at a time.
+type processSample struct {
+	cpuUser    uint64 // clock ticks
+	cpuSystem  uint64 // clock ticks
+	rss        uint64 // bytes
+	fds        int
+	readBytes  uint64
+	writeBytes uint64
+}
+
+// Generate process group metrics
+func (g *ProcessGroupGenerator) Generate() (metrics.Values, error) {
+	prev := g.sampleGroups()
+
+	time.Sleep(g.Interval)
+
+	curr := g.sampleGroups()
+
+	seconds := g.Interval.Seconds()
+	ret := make(metrics.Values)
+	for name, samples := range curr {
+		prefix := "custom.process." + util.SanitizeMetricKey(name) + "."
+		var cpuUser, cpuSystem, readBytes, writeBytes float64
+		var rss uint64
+		var fds int
+		for pid, s := range samples {
+			rss += s.rss
+			fds += s.fds
+			// The pid may be reused by another process between the samples, so ignore decreasing counters.
+			if p, ok := prev[name][pid]; ok && s.cpuUser >= p.cpuUser && s.cpuSystem >= p.cpuSystem {
+				cpuUser += float64(s.cpuUser - p.cpuUser)
+				cpuSystem += float64(s.cpuSystem - p.cpuSystem)
+				readBytes += float64(util.DiffResettableCounter(s.readBytes, p.readBytes))
+				writeBytes += float64(util.DiffResettableCounter(s.writeBytes, p.writeBytes))
+			}
+		}
+		ret[prefix+"cpu.user"] = metrics.NewValueAttribute(cpuUser * 100 / clockTicksPerSecond / seconds)
+		ret[prefix+"cpu.system"] = metrics.NewValueAttribute(cpuSystem * 100 / clockTicksPerSecond / seconds)
+		ret[prefix+"memory.rss"] = metrics.NewValueAttribute(float64(rss))
+		ret[prefix+"fds.open"] = metrics.NewValueAttribute(float64(fds))
+		ret[prefix+"io.read"] = metrics.NewValueAttribute(readBytes / seconds)
+		ret[prefix+"io.write"] = metrics.NewValueAttribute(writeBytes / seconds)
+		ret[prefix+"instances.count"] = metrics.NewValueAttribute(float64(len(samples)))
+	}
+	return ret, nil
+}
+
+// sampleGroups returns the samples of the processes keyed by the group names and the pids.
+func (g *ProcessGroupGenerator) sampleGroups() map[string]map[int]*processSample {
+	ret := make(map[string]map[int]*processSample, len(g.Groups))
+	matchers := make(map[string]*util.ProcessMatcher, len(g.Groups))
+	for name, group := range g.Groups {
+		ret[name] = make(map[int]*processSample)
+		matchers[name] = &util.ProcessMatcher{
+			Name:        group.Name,
+			Cmdline:     group.Cmdline.Regexp,
+			Pidfile:     group.Pidfile,
+			SystemdUnit: group.SystemdUnit,
+		}
+	}
+
+	procs, err := util.ListProcesses(procPath)
+	if err != nil {
+		processGroupLogger.Warningf("Failed to read %s: %s", procPath, err)
+		return ret
+	}
+	for _, p := range procs {
+		for name, m := range matchers {
+			if m.Match(p) {
+				// Processes which exit while walking are ignored.
+				if s, err := sampleProcess(p.Dir); err == nil {
+					ret[name][p.PID] = s
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func sampleProcess(dir string) (*processSample, error) {
+	out, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseProcessStatUsage(out)
+	if err != nil {
+		return nil, err
+	}
+	if fds, err := os.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		s.fds = len(fds)
+	}
+	// /proc/{pid}/io is readable only by the owner of the process or root.
+	if out, err := os.ReadFile(filepath.Join(dir, "io")); err == nil {
+		s.readBytes, s.writeBytes = parseProcessIO(out)
+	}
+	return s, nil
+}
+
+// parseProcessStatUsage parses utime (14th), stime (15th) and rss (24th) fields of /proc/{pid}/stat.
+func parseProcessStatUsage(out []byte) (*processSample, error) {
+	i := bytes.LastIndexByte(out, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid format: %q", out)
+	}
+	// fields[0] is the 3rd field "state"
+	fields := strings.Fields(string(out[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid format: %q", out)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &processSample{
+		cpuUser:   utime,
+		cpuSystem: stime,
+		rss:       uint64(max(rss, 0)) * uint64(pageSize),
+	}, nil
+}
+
+/*
+cat /proc/{pid}/io sample:
+
+	rchar: 323934931
+	wchar: 323929600
+	syscr: 632687
+	syscw: 632675
+	read_bytes: 0
+	write_bytes: 323932160
+	cancelled_write_bytes: 0
+*/
+func parseProcessIO(out []byte) (readBytes, writeBytes uint64) {
+	for line := range strings.SplitSeq(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "read_bytes":
+			readBytes = v
+		case "write_bytes":
+			writeBytes = v
+		}
+	}
+	return readBytes, writeBytes
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *ProcessGroupGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"process.#.cpu": {
+			Label: "Process Group CPU",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "user", Label: "User", Stacked: true},
+				{Name: "system", Label: "System", Stacked: true},
+			},
+		},
+		"process.#.memory": {
+			Label: "Process Group Memory",
+			Unit:  "bytes",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "rss", Label: "RSS"},
+			},
+		},
+		"process.#.fds": {
+			Label: "Process Group File Descriptors",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "open", Label: "Open"},
+			},
+		},
+		"process.#.io": {
+			Label: "Process Group I/O",
+			Unit:  "bytes/sec",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "read", Label: "Read"},
+				{Name: "write", Label: "Write"},
+			},
+		},
+		"process.#.instances": {
+			Label: "Process Group Instances",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Count"},
+			},
+		},
+	}), nil
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+func writeFakeProcess(t *testing.T, root string, pid string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, pid)
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessGroupGenerator(t *testing.T) {
+	root := t.TempDir()
+	defer func(p string) { procPath = p }(procPath)
+	procPath = root
+
+	writeFakeProcess(t, root, "100", map[string]string{
+		"comm":    "nginx\n",
+		"cmdline": "nginx: master process /usr/sbin/nginx\x00",
+		"cgroup":  "0::/system.slice/nginx.service\n",
+		"stat":    "100 (nginx) S 1 100 100 0 -1 4194560 100 0 0 0 10 20 0 0 20 0 1 0 100 1000 50 18446744073709551615",
+		"io":      "rchar: 1\nwchar: 2\nread_bytes: 4096\nwrite_bytes: 8192\n",
+	})
+	writeFakeProcess(t, root, "101", map[string]string{
+		"comm":    "nginx\n",
+		"cmdline": "nginx: worker process\x00",
+		"cgroup":  "0::/system.slice/nginx.service\n",
+		"stat":    "101 (nginx) S 100 100 100 0 -1 4194560 100 0 0 0 30 40 0 0 20 0 1 0 100 1000 25 18446744073709551615",
+	})
+	writeFakeProcess(t, root, "200", map[string]string{
+		"comm":    "ruby\n",
+		"cmdline": "ruby\x00app.rb\x00",
+		"cgroup":  "0::/system.slice/app.service\n",
+		"stat":    "200 (ruby) R 1 200 200 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 3 0 100 1000 10 18446744073709551615",
+	})
+	if err := os.WriteFile(filepath.Join(root, "101", "fd", "0"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pidfile := filepath.Join(root, "cron.pid")
+	if err := os.WriteFile(pidfile, []byte("200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &ProcessGroupGenerator{
+		Interval: 10 * time.Millisecond,
+		Groups: map[string]*config.ProcessGroup{
+			"web":   {Name: "nginx"},
+			"app":   {Cmdline: config.Regexpwrapper{Regexp: regexp.MustCompile(`\bapp\.rb\b`)}},
+			"unit":  {SystemdUnit: "nginx"},
+			"cron":  {Pidfile: pidfile},
+			"none":  {Name: "none"},
+			"v1.2":  {SystemdUnit: "app.service"},
+			"empty": {},
+		},
+	}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+
+	expect := map[string]float64{
+		"custom.process.web.instances.count":   2,
+		"custom.process.web.memory.rss":        float64(75 * pageSize),
+		"custom.process.web.fds.open":          1,
+		"custom.process.web.cpu.user":          0,
+		"custom.process.app.instances.count":   1,
+		"custom.process.unit.instances.count":  2,
+		"custom.process.cron.instances.count":  1,
+		"custom.process.none.instances.count":  0,
+		"custom.process.v1_2.instances.count":  1,
+		"custom.process.empty.instances.count": 0,
+	}
+	for name, value := range expect {
+		v, ok := values[name]
+		if !ok {
+			t.Errorf("%s should be collected", name)
+			continue
+		}
+		if v.Value != value {
+			t.Errorf("%s should be %f but %f", name, value, v.Value)
+		}
+	}
+}
+
+func TestParseProcessStatUsage(t *testing.T) {
+	s, err := parseProcessStatUsage([]byte("1234 (my (weird) cmd) R 1 1234 1234 0 -1 4194304 100 0 0 0 150 250 0 0 20 0 12 0 100 1000 300 18446744073709551615"))
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if s.cpuUser != 150 || s.cpuSystem != 250 || s.rss != uint64(300*pageSize) {
+		t.Errorf("unexpected sample: %+v", s)
+	}
+}
@@ -0,0 +1,303 @@
+//go:build linux
+
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+ProcessesGenerator collects the process table and file descriptor table statistics
+
+`custom.processes.state.{state}`: the number of processes in each state retrieved from /proc/{pid}/stat
+
+state = "running", "sleeping", "uninterruptible", "zombie", "stopped", "idle"
+
+`custom.processes.threads.total`: the number of threads of all processes
+`custom.processes.forks.rate`: forks per second retrieved from "processes" of /proc/stat
+`custom.kernel.events.{context_switches,interrupts}`: context switches and interrupts per second retrieved from /proc/stat
+`custom.filedescriptors.allocated`: allocated file handles retrieved from /proc/sys/fs/file-nr
+`custom.filedescriptors.limit.max`: the limit of the file handles retrieved from /proc/sys/fs/file-nr
+`custom.filedescriptors.usage.percent`: allocated file handles as percentage of the limit
+
+The max and the usage are not posted when the limit is practically unlimited (LONG_MAX by default on recent kernels).
+*/
+type ProcessesGenerator struct {
+	Interval time.Duration
+}
+
+var processesLogger = logging.GetLogger("metrics.processes")
+
+var procPath = "/proc"
+
+// process states in /proc/{pid}/stat, see proc(5)
+var processStateNames = map[byte]string{
+	'R': "running",
+	'S': "sleeping",
+	'D': "uninterruptible",
+	'Z': "zombie",
+	'T': "stopped",
+	't': "stopped",
+	'I': "idle",
+}
+
+// Generate process table metrics
+func (g *ProcessesGenerator) Generate() (metrics.Values, error) {
+	prev, err := readProcStatCounters()
+	if err != nil {
+		processesLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	time.Sleep(g.Interval)
+
+	curr, err := readProcStatCounters()
+	if err != nil {
+		processesLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+
+	seconds := g.Interval.Seconds()
+	ret := metrics.Values{
+		"custom.processes.forks.rate":           metrics.NewValueAttribute(float64(curr.forks-prev.forks) / seconds),
+		"custom.kernel.events.context_switches": metrics.NewValueAttribute(float64(curr.contextSwitches-prev.contextSwitches) / seconds),
+		"custom.kernel.events.interrupts":       metrics.NewValueAttribute(float64(curr.interrupts-prev.interrupts) / seconds),
+	}
+
+	states, threads := collectProcessStates()
+	// post zero for the states without processes so that the stacked graph does not have gaps
+	for _, state := range processStateNames {
+		ret["custom.processes.state."+state] = metrics.NewValueAttribute(float64(states[state]))
+	}
+	ret["custom.processes.threads.total"] = metrics.NewValueAttribute(float64(threads))
+
+	out, err := os.ReadFile(filepath.Join(procPath, "sys/fs/file-nr"))
+	if err != nil {
+		processesLogger.Warningf("Failed to read file-nr: %s", err)
+		return ret, nil
+	}
+	allocated, limit, err := parseFileNr(out)
+	if err != nil {
+		processesLogger.Warningf("Failed to parse file-nr: %s", err)
+		return ret, nil
+	}
+	maps.Copy(ret, fileDescriptorsValues(allocated, limit))
+	return ret, nil
+}
+
+// fileDescriptorsLimitBound is the bound of the meaningful file-max.
+// Recent kernels set file-max to LONG_MAX by default, which means unlimited.
+const fileDescriptorsLimitBound = 1 << 32
+
+func fileDescriptorsValues(allocated, limit uint64) metrics.Values {
+	ret := metrics.Values{
+		"custom.filedescriptors.allocated": metrics.NewValueAttribute(float64(allocated)),
+	}
+	if limit > 0 && limit < fileDescriptorsLimitBound {
+		ret["custom.filedescriptors.limit.max"] = metrics.NewValueAttribute(float64(limit))
+		ret["custom.filedescriptors.usage.percent"] = metrics.NewValueAttribute(float64(allocated) * 100 / float64(limit))
+	}
+	return ret
+}
+
+type procStatCounters struct {
+	forks           uint64
+	contextSwitches uint64
+	interrupts      uint64
+}
+
+func readProcStatCounters() (*procStatCounters, error) {
+	out, err := os.ReadFile(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStatCounters(out)
+}
+
+/*
+cat /proc/stat sample (cpu lines are omitted):
+
+	intr 114930548 113199788 3 0 5 263 0 4 [... lots more numbers ...]
+	ctxt 1990473
+	btime 1062191376
+	processes 2915
+	procs_running 1
+	procs_blocked 0
+*/
+func parseProcStatCounters(out []byte) (*procStatCounters, error) {
+	var counters procStatCounters
+	found := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	// The intr line may be longer than the default buffer size.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		var dest *uint64
+		switch fields[0] {
+		case "processes":
+			dest = &counters.forks
+		case "ctxt":
+			dest = &counters.contextSwitches
+		case "intr":
+			dest = &counters.interrupts
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s of /proc/stat: %s", fields[0], err)
+		}
+		*dest = v
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found < 3 {
+		return nil, fmt.Errorf("processes, ctxt or intr is not found in /proc/stat")
+	}
+	return &counters, nil
+}
+
+// collectProcessStates counts the processes by their states and the threads.
+// Processes which exit while walking are ignored.
+func collectProcessStates() (map[string]int, int) {
+	states := make(map[string]int)
+	threads := 0
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		processesLogger.Warningf("Failed to read %s: %s", procPath, err)
+		return states, threads
+	}
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		out, err := os.ReadFile(filepath.Join(procPath, e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		state, n, err := parseProcessStat(out)
+		if err != nil {
+			processesLogger.Debugf("Failed to parse stat of pid %s: %s", e.Name(), err)
+			continue
+		}
+		if name, ok := processStateNames[state]; ok {
+			states[name]++
+		}
+		threads += n
+	}
+	return states, threads
+}
+
+// parseProcessStat returns the state and the number of threads from /proc/{pid}/stat.
+// The command name in parentheses may contain spaces and parentheses,
+// so the fields are split after the last ')'.
+func parseProcessStat(out []byte) (byte, int, error) {
+	i := bytes.LastIndexByte(out, ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid format: %q", out)
+	}
+	// fields[0] is the 3rd field "state" and fields[17] is the 20th field "num_threads"
+	fields := strings.Fields(string(out[i+1:]))
+	if len(fields) < 18 || len(fields[0]) != 1 {
+		return 0, 0, fmt.Errorf("invalid format: %q", out)
+	}
+	threads, err := strconv.Atoi(fields[17])
+	if err != nil {
+		return 0, 0, err
+	}
+	return fields[0][0], threads, nil
+}
+
+// parseFileNr parses /proc/sys/fs/file-nr which contains
+// the number of allocated file handles, free file handles (always 0 since Linux 2.6) and the maximum.
+func parseFileNr(out []byte) (allocated, limit uint64, err error) {
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return 0, 0, fmt.Errorf("invalid format: %q", out)
+	}
+	if allocated, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return allocated, limit, nil
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *ProcessesGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"processes.state": {
+			Label: "Processes by State",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "running", Label: "Running", Stacked: true},
+				{Name: "sleeping", Label: "Sleeping", Stacked: true},
+				{Name: "uninterruptible", Label: "Uninterruptible (D)", Stacked: true},
+				{Name: "zombie", Label: "Zombie", Stacked: true},
+				{Name: "stopped", Label: "Stopped", Stacked: true},
+				{Name: "idle", Label: "Idle", Stacked: true},
+			},
+		},
+		"processes.threads": {
+			Label: "Threads",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "total", Label: "Total"},
+			},
+		},
+		"processes.forks": {
+			Label: "Forks",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "rate", Label: "Forks per second"},
+			},
+		},
+		"kernel.events": {
+			Label: "Context Switches and Interrupts",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "context_switches", Label: "Context switches per second"},
+				{Name: "interrupts", Label: "Interrupts per second"},
+			},
+		},
+		"filedescriptors": {
+			Label: "File Descriptors",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "allocated", Label: "Allocated"},
+			},
+		},
+		"filedescriptors.limit": {
+			Label: "File Descriptors Limit",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "max", Label: "Max"},
+			},
+		},
+		"filedescriptors.usage": {
+			Label: "File Descriptors Usage",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "percent", Label: "Usage"},
+			},
+		},
+	}), nil
+}
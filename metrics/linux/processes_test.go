@@ -0,0 +1,113 @@
+//go:build linux
+
+package linux
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProcessesGenerator(t *testing.T) {
+	g := &ProcessesGenerator{Interval: 1 * time.Second}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+
+	metricNames := []string{
+		"custom.processes.state.running",
+		"custom.processes.state.sleeping",
+		"custom.processes.state.zombie",
+		"custom.processes.state.uninterruptible",
+		"custom.processes.threads.total",
+		"custom.processes.forks.rate",
+		"custom.kernel.events.context_switches",
+		"custom.kernel.events.interrupts",
+		"custom.filedescriptors.allocated",
+	}
+	for _, name := range metricNames {
+		if _, ok := values[name]; !ok {
+			t.Errorf("processes should have %s", name)
+		}
+	}
+	// the state of this test process is not always "running" since it is the state of the main thread
+	var total float64
+	for _, state := range processStateNames {
+		total += values["custom.processes.state."+state].Value
+	}
+	if total < 1 {
+		t.Errorf("at least this test process should be counted: %+v", values)
+	}
+}
+
+func TestParseProcStatCounters(t *testing.T) {
+	out := []byte(`cpu  2255 34 2290 22625563 6290 127 456 0 0 0
+cpu0 1132 34 1441 11311718 3675 127 438 0 0 0
+intr 114930548 113199788 3 0 5 263 0 4
+ctxt 1990473
+btime 1062191376
+processes 2915
+procs_running 1
+procs_blocked 0
+`)
+	counters, err := parseProcStatCounters(out)
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := procStatCounters{forks: 2915, contextSwitches: 1990473, interrupts: 114930548}
+	if *counters != expect {
+		t.Errorf("counters should be %+v but %+v", expect, *counters)
+	}
+
+	if _, err := parseProcStatCounters([]byte("ctxt 1\n")); err == nil {
+		t.Errorf("error should be returned when counters are missing")
+	}
+}
+
+func TestParseProcessStat(t *testing.T) {
+	tests := []struct {
+		out     string
+		state   byte
+		threads int
+	}{
+		{"1 (systemd) S 0 1 1 0 -1 4194560 49380 1735428 101 1337 106 211 2962 2019 20 0 1 0 6 172621824 3281 18446744073709551615", 'S', 1},
+		{"1234 (my (weird) cmd) R 1 1234 1234 0 -1 4194304 100 0 0 0 1 2 0 0 20 0 12 0 100 1000 100 18446744073709551615", 'R', 12},
+		{"42 (defunct) Z 1 42 42 0 -1 4227084 0 0 0 0 0 0 0 0 20 0 1 0 100 0 0 18446744073709551615", 'Z', 1},
+	}
+	for _, tt := range tests {
+		state, threads, err := parseProcessStat([]byte(tt.out))
+		if err != nil {
+			t.Errorf("error should be nil but got: %s", err)
+			continue
+		}
+		if state != tt.state || threads != tt.threads {
+			t.Errorf("state and threads should be %c, %d but %c, %d", tt.state, tt.threads, state, threads)
+		}
+	}
+
+	if _, _, err := parseProcessStat([]byte("1 (broken")); err == nil {
+		t.Errorf("error should be returned for a broken stat")
+	}
+}
+
+func TestParseFileNr(t *testing.T) {
+	allocated, limit, err := parseFileNr([]byte("3360\t0\t9223372036854775807\n"))
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	if allocated != 3360 || limit != 9223372036854775807 {
+		t.Errorf("unexpected values: allocated=%d limit=%d", allocated, limit)
+	}
+}
+
+func TestFileDescriptorsValues(t *testing.T) {
+	values := fileDescriptorsValues(3360, 9223372036854775807)
+	if len(values) != 1 || values["custom.filedescriptors.allocated"].Value != 3360 {
+		t.Errorf("only the allocated should be posted for the unlimited file-max but %+v", values)
+	}
+
+	values = fileDescriptorsValues(3360, 336000)
+	if values["custom.filedescriptors.limit.max"].Value != 336000 || values["custom.filedescriptors.usage.percent"].Value != 1 {
+		t.Errorf("the max and the usage should be posted but %+v", values)
+	}
+}
@@ -0,0 +1,314 @@
+//go:build linux
+
+package linux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+SensorsGenerator collects hardware sensors from /sys/class/hwmon and /sys/class/thermal,
+and CPU frequency scaling from /sys/devices/system/cpu/cpu{N}/cpufreq
+
+`custom.sensor.{chip}.{label}.{reading}`: a sensor reading converted into the unit of its type
+
+chip = the name of the hwmon device (e.g. "coretemp"), or "thermal" for thermal zones
+label = the label of the sensor (e.g. "Package_id_0"), or the sensor name (e.g. "temp1") without label
+reading = "input", "min", "max", "crit"
+
+type = temperature in °C, voltage in V, fan speed in RPM, power in W, current in A
+
+`custom.thermal_throttle.{core,package}`: thermal throttling events of the CPUs during the interval
+`custom.cpufreq.current.{min,avg,max}`: the current frequencies of the CPUs in MHz
+`custom.cpufreq.ratio.percent`: the average current frequency as percentage of the average maximum frequency
+*/
+type SensorsGenerator struct {
+	Interval time.Duration
+}
+
+var sensorsLogger = logging.GetLogger("metrics.sensors")
+
+var sysPath = "/sys"
+
+type sensorType struct {
+	divisor   float64
+	unit      string
+	graphUnit string
+}
+
+// the prefixes of the hwmon sysfs attributes, see https://docs.kernel.org/hwmon/sysfs-interface.html
+var sensorTypes = map[string]sensorType{
+	"temp":  {divisor: 1000, unit: "°C", graphUnit: "float"},
+	"in":    {divisor: 1000, unit: "V", graphUnit: "float"},
+	"fan":   {divisor: 1, unit: "RPM", graphUnit: "integer"},
+	"power": {divisor: 1000000, unit: "W", graphUnit: "float"},
+	"curr":  {divisor: 1000, unit: "A", graphUnit: "float"},
+}
+
+var hwmonAttributeRegexp = regexp.MustCompile(`^(temp|in|fan|power|curr)(\d+)_(input|min|max|crit)$`)
+
+// sensorReading is a reading of a sensor
+type sensorReading struct {
+	chip    string
+	label   string
+	reading string
+	typ     string
+	value   float64
+}
+
+func (r *sensorReading) metricName() string {
+	return "custom.sensor." + r.chip + "." + r.label + "." + r.reading
+}
+
+// Generate sensor metrics
+func (g *SensorsGenerator) Generate() (metrics.Values, error) {
+	prev := collectThrottleCounts()
+
+	time.Sleep(g.Interval)
+
+	ret := make(metrics.Values)
+	for _, r := range collectSensorReadings() {
+		ret[r.metricName()] = metrics.NewValueAttribute(r.value)
+	}
+
+	curr := collectThrottleCounts()
+	if prev != nil && curr != nil {
+		ret["custom.thermal_throttle.core"] = metrics.NewValueAttribute(float64(util.DiffResettableCounter(curr.core, prev.core)))
+		ret["custom.thermal_throttle.package"] = metrics.NewValueAttribute(float64(util.DiffResettableCounter(curr.pkg, prev.pkg)))
+	}
+
+	for name, value := range collectCPUFreq() {
+		ret[name] = metrics.NewValueAttribute(value)
+	}
+	return ret, nil
+}
+
+func readSysfsString(path string) (string, error) {
+	out, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func readSysfsInt(path string) (int64, error) {
+	s, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// uniqueName returns the name with the suffix when the name is already used.
+func uniqueName(used map[string]bool, name, suffix string) string {
+	if used[name] {
+		name += "_" + suffix
+	}
+	used[name] = true
+	return name
+}
+
+// collectSensorReadings collects the readings of hwmon devices and thermal zones.
+// The devices without readable attributes are skipped.
+func collectSensorReadings() []*sensorReading {
+	var readings []*sensorReading
+	chips := make(map[string]bool)
+	devices, _ := filepath.Glob(filepath.Join(sysPath, "class/hwmon/hwmon*"))
+	slices.Sort(devices)
+	for _, dir := range devices {
+		name, err := readSysfsString(filepath.Join(dir, "name"))
+		if err != nil {
+			sensorsLogger.Debugf("Failed to read the name of %s: %s", dir, err)
+			continue
+		}
+		// multiple devices may have the same name such as "coretemp" for each socket
+		chip := uniqueName(chips, util.SanitizeMetricKey(name), strings.TrimPrefix(filepath.Base(dir), "hwmon"))
+		readings = append(readings, collectHwmonReadings(dir, chip)...)
+	}
+
+	zones, _ := filepath.Glob(filepath.Join(sysPath, "class/thermal/thermal_zone*"))
+	slices.Sort(zones)
+	labels := make(map[string]bool)
+	for _, dir := range zones {
+		temp, err := readSysfsInt(filepath.Join(dir, "temp"))
+		if err != nil {
+			sensorsLogger.Debugf("Failed to read the temperature of %s: %s", dir, err)
+			continue
+		}
+		index := strings.TrimPrefix(filepath.Base(dir), "thermal_zone")
+		typ, err := readSysfsString(filepath.Join(dir, "type"))
+		if err != nil {
+			typ = "zone" + index
+		}
+		readings = append(readings, &sensorReading{
+			chip:    "thermal",
+			label:   uniqueName(labels, util.SanitizeMetricKey(typ), index),
+			reading: "input",
+			typ:     "temp",
+			value:   float64(temp) / sensorTypes["temp"].divisor,
+		})
+	}
+	return readings
+}
+
+func collectHwmonReadings(dir, chip string) []*sensorReading {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var readings []*sensorReading
+	labels := make(map[string]string)
+	used := make(map[string]bool)
+	for _, e := range entries {
+		m := hwmonAttributeRegexp.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		typ, sensor, reading := m[1], m[1]+m[2], m[3]
+		v, err := readSysfsInt(filepath.Join(dir, e.Name()))
+		if err != nil {
+			// some attributes return errors when the sensor is not connected
+			continue
+		}
+		label, ok := labels[sensor]
+		if !ok {
+			label = sensor
+			if s, err := readSysfsString(filepath.Join(dir, sensor+"_label")); err == nil && s != "" {
+				label = util.SanitizeMetricKey(s)
+			}
+			// multiple sensors may have the same label such as "Vcore"
+			label = uniqueName(used, label, sensor)
+			labels[sensor] = label
+		}
+		readings = append(readings, &sensorReading{
+			chip:    chip,
+			label:   label,
+			reading: reading,
+			typ:     typ,
+			value:   float64(v) / sensorTypes[typ].divisor,
+		})
+	}
+	return readings
+}
+
+type throttleCounts struct {
+	core uint64
+	pkg  uint64
+}
+
+// collectThrottleCounts sums up the thermal throttling counts of the CPUs.
+// The package counts are shared by the CPUs in the same package, so they are counted once per package.
+// It returns nil when the CPUs do not support thermal throttling counts.
+func collectThrottleCounts() *throttleCounts {
+	dirs, _ := filepath.Glob(filepath.Join(sysPath, "devices/system/cpu/cpu[0-9]*"))
+	var counts *throttleCounts
+	packages := make(map[string]bool)
+	for _, dir := range dirs {
+		core, err := readSysfsInt(filepath.Join(dir, "thermal_throttle/core_throttle_count"))
+		if err != nil {
+			continue
+		}
+		if counts == nil {
+			counts = &throttleCounts{}
+		}
+		counts.core += uint64(core)
+		pkgID, err := readSysfsString(filepath.Join(dir, "topology/physical_package_id"))
+		if err != nil || packages[pkgID] {
+			continue
+		}
+		packages[pkgID] = true
+		if pkg, err := readSysfsInt(filepath.Join(dir, "thermal_throttle/package_throttle_count")); err == nil {
+			counts.pkg += uint64(pkg)
+		}
+	}
+	return counts
+}
+
+// collectCPUFreq collects the frequencies from scaling_cur_freq and cpuinfo_max_freq in kHz.
+func collectCPUFreq() map[string]float64 {
+	dirs, _ := filepath.Glob(filepath.Join(sysPath, "devices/system/cpu/cpu[0-9]*/cpufreq"))
+	var currents []float64
+	var sumCurrent, sumMax float64
+	for _, dir := range dirs {
+		cur, err := readSysfsInt(filepath.Join(dir, "scaling_cur_freq"))
+		if err != nil {
+			continue
+		}
+		limit, err := readSysfsInt(filepath.Join(dir, "cpuinfo_max_freq"))
+		if err != nil {
+			continue
+		}
+		currents = append(currents, float64(cur)/1000)
+		sumCurrent += float64(cur)
+		sumMax += float64(limit)
+	}
+	if len(currents) == 0 {
+		return nil
+	}
+	ret := map[string]float64{
+		"custom.cpufreq.current.min": slices.Min(currents),
+		"custom.cpufreq.current.avg": sumCurrent / 1000 / float64(len(currents)),
+		"custom.cpufreq.current.max": slices.Max(currents),
+	}
+	if sumMax > 0 {
+		ret["custom.cpufreq.ratio.percent"] = sumCurrent * 100 / sumMax
+	}
+	return ret
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+// A graph is defined for each sensor since the units differ by the sensor types.
+func (g *SensorsGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	graphs := map[string]metrics.CustomGraphDef{
+		"thermal_throttle": {
+			Label: "CPU Thermal Throttling",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "core", Label: "Core"},
+				{Name: "package", Label: "Package"},
+			},
+		},
+		"cpufreq.current": {
+			Label: "CPU Frequency (MHz)",
+			Unit:  "float",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "min", Label: "Min"},
+				{Name: "avg", Label: "Avg"},
+				{Name: "max", Label: "Max"},
+			},
+		},
+		"cpufreq.ratio": {
+			Label: "CPU Frequency Scaling",
+			Unit:  "percentage",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "percent", Label: "Current / Max"},
+			},
+		},
+	}
+	for _, r := range collectSensorReadings() {
+		key := "sensor." + r.chip + "." + r.label
+		graph, ok := graphs[key]
+		if !ok {
+			typ := sensorTypes[r.typ]
+			graph = metrics.CustomGraphDef{
+				Label: fmt.Sprintf("Sensor %s %s (%s)", r.chip, r.label, typ.unit),
+				Unit:  typ.graphUnit,
+			}
+		}
+		graph.Metrics = append(graph.Metrics, metrics.CustomGraphMetricDef{Name: r.reading, Label: r.reading})
+		graphs[key] = graph
+	}
+	return metrics.NewGraphDefsParams(graphs), nil
+}
@@ -0,0 +1,105 @@
+//go:build linux
+
+package linux
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSensorsGenerator(t *testing.T) {
+	defer func(orig string) { sysPath = orig }(sysPath)
+	sysPath = "testdata/sysfs"
+
+	g := &SensorsGenerator{Interval: 10 * time.Millisecond}
+	values, err := g.Generate()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	expect := map[string]float64{
+		"custom.sensor.coretemp.Package_id_0.input": 45,
+		"custom.sensor.coretemp.Package_id_0.max":   80,
+		"custom.sensor.coretemp.Package_id_0.crit":  100,
+		"custom.sensor.coretemp.Core_0.input":       43,
+		"custom.sensor.coretemp_1.temp1.input":      47,
+		"custom.sensor.nct6775.fan1.input":          1200,
+		"custom.sensor.nct6775.in0.input":           1.04,
+		"custom.sensor.nct6775.power1.input":        35,
+		"custom.sensor.thermal.x86_pkg_temp.input":  46,
+		"custom.sensor.thermal.acpitz.input":        27.8,
+		"custom.sensor.nct6775.Vcore.input":         1.2,
+		"custom.sensor.nct6775.Vcore_in2.input":     1.3,
+		"custom.sensor.cpu.temp1.input":             50,
+		"custom.thermal_throttle.core":              0,
+		"custom.thermal_throttle.package":           0,
+		"custom.cpufreq.current.min":                1200,
+		"custom.cpufreq.current.avg":                1800,
+		"custom.cpufreq.current.max":                2400,
+		"custom.cpufreq.ratio.percent":              50,
+	}
+	for name, v := range expect {
+		if value, ok := values[name]; !ok || value.Value != v {
+			t.Errorf("%s should be %f but %+v", name, v, value)
+		}
+	}
+	if len(values) != len(expect) {
+		t.Errorf("unexpected metrics are collected: %+v", values)
+	}
+}
+
+func TestCollectThrottleCounts(t *testing.T) {
+	defer func(orig string) { sysPath = orig }(sysPath)
+	sysPath = "testdata/sysfs"
+
+	// the package count is shared by cpu0 and cpu1
+	counts := collectThrottleCounts()
+	if counts == nil || *counts != (throttleCounts{core: 5, pkg: 5}) {
+		t.Errorf("unexpected throttle counts: %+v", counts)
+	}
+
+	sysPath = t.TempDir()
+	if counts := collectThrottleCounts(); counts != nil {
+		t.Errorf("throttle counts should be nil when not supported: %+v", counts)
+	}
+}
+
+func TestSensorsGenerator_PrepareGraphDefs(t *testing.T) {
+	defer func(orig string) { sysPath = orig }(sysPath)
+	sysPath = "testdata/sysfs"
+
+	g := &SensorsGenerator{}
+	defs, err := g.PrepareGraphDefs()
+	if err != nil {
+		t.Fatalf("error should be nil but got: %s", err)
+	}
+	graphs := make(map[string]int)
+	for _, def := range defs {
+		graphs[def.Name] = len(def.Metrics)
+	}
+	expect := map[string]int{
+		"custom.thermal_throttle":             2,
+		"custom.sensor.nct6775.Vcore":         1,
+		"custom.sensor.nct6775.Vcore_in2":     1,
+		"custom.sensor.cpu.temp1":             1,
+		"custom.cpufreq.current":              3,
+		"custom.cpufreq.ratio":                1,
+		"custom.sensor.coretemp.Package_id_0": 3,
+		"custom.sensor.coretemp.Core_0":       1,
+		"custom.sensor.coretemp_1.temp1":      1,
+		"custom.sensor.nct6775.fan1":          1,
+		"custom.sensor.nct6775.in0":           1,
+		"custom.sensor.nct6775.power1":        1,
+		"custom.sensor.thermal.x86_pkg_temp":  1,
+		"custom.sensor.thermal.acpitz":        1,
+	}
+	for name, n := range expect {
+		if graphs[name] != n {
+			t.Errorf("graph %s should have %d metrics but %d", name, n, graphs[name])
+		}
+	}
+	for _, def := range defs {
+		if def.Name == "custom.sensor.nct6775.fan1" && def.Unit != "integer" {
+			t.Errorf("unit of fan speed should be integer but %s", def.Unit)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package linux
+
+import (
+	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/metrics"
+	"github.com/mackerelio/mackerel-agent/util"
+	mkr "github.com/mackerelio/mackerel-client-go"
+)
+
+/*
+SystemdGenerator collects the states of systemd units by systemctl
+
+`custom.systemd.failed_units.count`: the number of the units in the failed state
+
+For each of the configured units:
+
+`custom.systemd.{unit}.state.{active_state}`: 1 for the current ActiveState and 0 for the others
+`custom.systemd.{unit}.substate.{sub_state}`: 1 for the current SubState and 0 for the others
+`custom.systemd.{unit}.restarts.count`: the number of automatic restarts by the service manager (NRestarts)
+
+active_state = "active", "activating", "deactivating", "inactive", "failed", "reloading"
+sub_state = "running", "exited", "dead", "failed", "auto-restart"
+
+unit = the unit name sanitized by util.SanitizeMetricKey (e.g. "nginx_service")
+*/
+type SystemdGenerator struct {
+	Units []string
+}
+
+var systemdLogger = logging.GetLogger("metrics.systemd")
+
+var systemdActiveStates = []string{"active", "activating", "deactivating", "inactive", "failed", "reloading"}
+
+var systemdSubStates = []string{"running", "exited", "dead", "failed", "auto-restart"}
+
+// Generate systemd unit metrics
+func (g *SystemdGenerator) Generate() (metrics.Values, error) {
+	failed, err := util.ListFailedSystemdUnits()
+	if err != nil {
+		systemdLogger.Errorf("Failed (skip these metrics): %s", err)
+		return nil, err
+	}
+	ret := metrics.Values{
+		"custom.systemd.failed_units.count": metrics.NewValueAttribute(float64(len(failed))),
+	}
+	if len(g.Units) == 0 {
+		return ret, nil
+	}
+
+	units, err := util.ShowSystemdUnits(g.Units)
+	if err != nil {
+		systemdLogger.Errorf("Failed to show units (skip these metrics): %s", err)
+		return ret, nil
+	}
+	for _, unit := range units {
+		if unit.LoadState == "not-found" {
+			systemdLogger.Warningf("Unit %s is not found", unit.ID)
+			continue
+		}
+		prefix := "custom.systemd." + util.SanitizeMetricKey(unit.ID) + "."
+		for _, state := range systemdActiveStates {
+			ret[prefix+"state."+state] = metrics.NewValueAttribute(boolToFloat(unit.ActiveState == state))
+		}
+		for _, state := range systemdSubStates {
+			ret[prefix+"substate."+state] = metrics.NewValueAttribute(boolToFloat(unit.SubState == state))
+		}
+		ret[prefix+"restarts.count"] = metrics.NewValueAttribute(float64(unit.NRestarts))
+	}
+	return ret, nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// PrepareGraphDefs for GraphDefsGenerator interface
+func (g *SystemdGenerator) PrepareGraphDefs() ([]*mkr.GraphDefsParam, error) {
+	stateMetrics := func(states []string) []metrics.CustomGraphMetricDef {
+		defs := make([]metrics.CustomGraphMetricDef, 0, len(states))
+		for _, state := range states {
+			defs = append(defs, metrics.CustomGraphMetricDef{Name: state, Label: state, Stacked: true})
+		}
+		return defs
+	}
+	return metrics.NewGraphDefsParams(map[string]metrics.CustomGraphDef{
+		"systemd.failed_units": {
+			Label: "systemd Failed Units",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Failed"},
+			},
+		},
+		"systemd.#.state": {
+			Label:   "systemd Unit Active State",
+			Unit:    "integer",
+			Metrics: stateMetrics(systemdActiveStates),
+		},
+		"systemd.#.substate": {
+			Label:   "systemd Unit Sub State",
+			Unit:    "integer",
+			Metrics: stateMetrics(systemdSubStates),
+		},
+		"systemd.#.restarts": {
+			Label: "systemd Unit Restarts",
+			Unit:  "integer",
+			Metrics: []metrics.CustomGraphMetricDef{
+				{Name: "count", Label: "Restarts"},
+			},
+		},
+	}), nil
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package linux
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestSystemdGenerator(t *testing.T) {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		t.Skip("systemctl is not available")
+	}
+	g := &SystemdGenerator{Units: []string{"dbus"}}
+	values, err := g.Generate()
+	if err != nil {
+		t.Skipf("systemd is not running: %s", err)
+	}
+	if _, ok := values["custom.systemd.failed_units.count"]; !ok {
+		t.Errorf("failed units should be counted: %+v", values)
+	}
+}
+
+func TestSystemdGenerator_PrepareGraphDefs(t *testing.T) {
+	g := &SystemdGenerator{}
+	defs, err := g.PrepareGraphDefs()
+	if err != nil {
+		t.Fatalf("PrepareGraphDefs() failed: %s", err)
+	}
+	if len(defs) != 4 {
+		t.Errorf("unexpected graph definitions: %v", defs)
+	}
+}
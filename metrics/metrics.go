@@ -23,18 +23,19 @@ func merge(v1, v2 Values) Values {
 	return v1
 }
 
-// ValuesCustomIdentifier holds the metric values with the optional custom identifier
+// ValuesCustomIdentifier holds the metric values with the optional custom identifier,
+// or the service name when the values are service metrics
 type ValuesCustomIdentifier struct {
 	Values           Values
 	CustomIdentifier *string
+	Service          *string `json:",omitempty"`
 }
 
 // MergeValuesCustomIdentifiers merges the metric values and custom identifiers
 func MergeValuesCustomIdentifiers(values []*ValuesCustomIdentifier, newValue *ValuesCustomIdentifier) []*ValuesCustomIdentifier {
 	for _, value := range values {
-		if value.CustomIdentifier == newValue.CustomIdentifier ||
-			(value.CustomIdentifier != nil && newValue.CustomIdentifier != nil &&
-				*value.CustomIdentifier == *newValue.CustomIdentifier) {
+		if equalStringPointers(value.CustomIdentifier, newValue.CustomIdentifier) &&
+			equalStringPointers(value.Service, newValue.Service) {
 			value.Values = merge(value.Values, newValue.Values)
 			return values
 		}
@@ -42,21 +43,38 @@ func MergeValuesCustomIdentifiers(values []*ValuesCustomIdentifier, newValue *Va
 	return append(values, newValue)
 }
 
+func equalStringPointers(s1, s2 *string) bool {
+	return s1 == s2 || (s1 != nil && s2 != nil && *s1 == *s2)
+}
+
 // Generator generates metrics
 type Generator interface {
 	Generate() (Values, error)
 }
 
-// PluginGenerator generates metrics of plugin
-type PluginGenerator interface {
+// GraphDefsGenerator generates metrics with their graph definitions.
+// Metrics whose graphs are not predefined by Mackerel should be named with "custom." prefix.
+type GraphDefsGenerator interface {
 	Generator
 	PrepareGraphDefs() ([]*mkr.GraphDefsParam, error)
+}
+
+// PluginGenerator generates metrics of plugin
+type PluginGenerator interface {
+	GraphDefsGenerator
 	CustomIdentifier() *string
 }
 
-// PluginFaultError may be returned by [PluginGenerator.PrepareGraphDefs].
+// ServicePluginGenerator generates metrics of plugin which may be posted as service metrics
+type ServicePluginGenerator interface {
+	PluginGenerator
+	// Service returns the service name when the metrics are service metrics, otherwise nil.
+	Service() *string
+}
+
+// PluginFaultError may be returned by [GraphDefsGenerator.PrepareGraphDefs].
 // This error indicates a bug in a plugin and should be logged for a user.
-// Note that [PluginGenerator.PrepareGraphDefs] can also return other error types.
+// Note that [GraphDefsGenerator.PrepareGraphDefs] can also return other error types.
 type PluginFaultError struct {
 	Err error
 }
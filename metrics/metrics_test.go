@@ -86,3 +86,28 @@ func TestMergeValuesCustomIdentifiers(t *testing.T) {
 		t.Errorf("somthing went wrong")
 	}
 }
+
+func TestMergeValuesCustomIdentifiers_Service(t *testing.T) {
+	service := "shop"
+	v := MergeValuesCustomIdentifiers([]*ValuesCustomIdentifier{
+		{Values: Values{"aa": NewValueAttribute(10)}},
+	}, &ValuesCustomIdentifier{Values: Values{"bb": NewValueAttribute(20)}, Service: &service})
+
+	sameService := "shop"
+	v = MergeValuesCustomIdentifiers(v, &ValuesCustomIdentifier{Values: Values{"cc": NewValueAttribute(30)}, Service: &sameService})
+
+	if !reflect.DeepEqual(v, []*ValuesCustomIdentifier{
+		{
+			Values: Values{"aa": NewValueAttribute(10)},
+		},
+		{
+			Values: Values{
+				"bb": NewValueAttribute(20),
+				"cc": NewValueAttribute(30),
+			},
+			Service: &service,
+		},
+	}) {
+		t.Errorf("service metrics should not be merged into host metrics: %+v", v)
+	}
+}
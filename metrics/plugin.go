@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent/cmdutil"
 	"github.com/mackerelio/mackerel-agent/config"
 	mkr "github.com/mackerelio/mackerel-client-go"
 )
@@ -20,20 +21,32 @@ import (
 type pluginGenerator struct {
 	Config *config.MetricPlugin
 	Meta   *pluginMeta
+
+	lastRun cmdutil.RunRecorder
+}
+
+// CommandPluginGenerator generates metrics of plugin by running the command of the plugin
+type CommandPluginGenerator interface {
+	PluginGenerator
+	PluginConfig() *config.MetricPlugin
+	// LastRun returns the result of the last run to collect the values, or nil if not run yet.
+	LastRun() *cmdutil.RunResult
 }
 
 // pluginMeta is generated from plugin command. (not the configuration file)
 type pluginMeta struct {
-	Graphs map[string]customGraphDef
+	Graphs map[string]CustomGraphDef
 }
 
-type customGraphDef struct {
+// CustomGraphDef is a graph definition of custom metrics.
+type CustomGraphDef struct {
 	Label   string
 	Unit    string
-	Metrics []customGraphMetricDef
+	Metrics []CustomGraphMetricDef
 }
 
-type customGraphMetricDef struct {
+// CustomGraphMetricDef is a metric definition in CustomGraphDef.
+type CustomGraphMetricDef struct {
 	Name    string
 	Label   string
 	Stacked bool
@@ -74,6 +87,30 @@ func (g *pluginGenerator) CustomIdentifier() *string {
 	return g.Config.CustomIdentifier
 }
 
+func (g *pluginGenerator) Service() *string {
+	if g.Config == nil {
+		return nil
+	}
+	return g.Config.Service
+}
+
+func (g *pluginGenerator) PluginConfig() *config.MetricPlugin {
+	return g.Config
+}
+
+func (g *pluginGenerator) LastRun() *cmdutil.RunResult {
+	return g.lastRun.Last()
+}
+
+// metricPrefix is the prefix of the names of the metrics and the graphs.
+// Only the host metrics of plugins are prefixed with "custom.".
+func (g *pluginGenerator) metricPrefix() string {
+	if g.Service() != nil {
+		return ""
+	}
+	return pluginPrefix
+}
+
 var pluginMetaHeadlineReg = regexp.MustCompile(`^#\s*mackerel-agent-plugin\b(.*)`)
 
 // loadPluginMeta obtains plugin information (e.g. graph visuals, metric
@@ -183,10 +220,18 @@ func (g *pluginGenerator) loadPluginMeta() error {
 }
 
 func (g *pluginGenerator) makeGraphDefsParam() []*mkr.GraphDefsParam {
-	return makeGraphDefsParam(g.Meta)
+	return makeGraphDefsParam(g.Meta, g.metricPrefix())
+}
+
+// NewGraphDefsParams converts graphs keyed by their names without "custom." prefix
+// into the payloads of graph definitions.
+func NewGraphDefsParams(graphs map[string]CustomGraphDef) []*mkr.GraphDefsParam {
+	return makeGraphDefsParam(&pluginMeta{Graphs: graphs}, pluginPrefix)
 }
 
-func makeGraphDefsParam(meta *pluginMeta) []*mkr.GraphDefsParam {
+// makeGraphDefsParam makes the payloads of graph definitions.
+// The graphs of service metrics are defined without prefix.
+func makeGraphDefsParam(meta *pluginMeta, prefix string) []*mkr.GraphDefsParam {
 	if meta == nil {
 		return nil
 	}
@@ -194,7 +239,7 @@ func makeGraphDefsParam(meta *pluginMeta) []*mkr.GraphDefsParam {
 	var payloads []*mkr.GraphDefsParam
 	for key, graph := range meta.Graphs {
 		payload := &mkr.GraphDefsParam{
-			Name:        pluginPrefix + key,
+			Name:        prefix + key,
 			DisplayName: graph.Label,
 			Unit:        graph.Unit,
 		}
@@ -204,7 +249,7 @@ func makeGraphDefsParam(meta *pluginMeta) []*mkr.GraphDefsParam {
 
 		for _, metric := range graph.Metrics {
 			metricPayload := &mkr.GraphDefsMetric{
-				Name:        pluginPrefix + key + "." + metric.Name,
+				Name:        prefix + key + "." + metric.Name,
 				DisplayName: metric.Label,
 				IsStacked:   metric.Stacked,
 			}
@@ -219,7 +264,9 @@ func makeGraphDefsParam(meta *pluginMeta) []*mkr.GraphDefsParam {
 
 func (g *pluginGenerator) collectValues() (Values, error) {
 	pluginMetaEnv := pluginConfigurationEnvName + "="
-	stdout, stderr, _, err := g.Config.Command.RunWithEnv([]string{pluginMetaEnv})
+	startedAt := time.Now()
+	stdout, stderr, exitCode, err := g.Config.Command.RunWithEnv([]string{pluginMetaEnv})
+	g.lastRun.Record(startedAt, stderr, exitCode, err)
 
 	if stderr != "" {
 		pluginLogger.Infof("command %s outputted to STDERR: %q", g.Config.Command.CommandString(), stderr)
@@ -229,29 +276,48 @@ func (g *pluginGenerator) collectValues() (Values, error) {
 		return nil, err
 	}
 
-	now := time.Now()
+	results, _ := g.parseValues(stdout, time.Now())
+	return results, nil
+}
+
+// RejectedLine is a line of the output of the plugin which is not collected.
+type RejectedLine struct {
+	Line   string
+	Reason string
+}
+
+// parseValues parses the output of the plugin, and returns the values and the rejected lines with the reasons.
+func (g *pluginGenerator) parseValues(stdout string, now time.Time) (Values, []RejectedLine) {
+	prefix := g.metricPrefix()
 	results := make(Values, 0)
+	var rejected []RejectedLine
 	for line := range strings.SplitSeq(stdout, "\n") {
 		// Key, value, timestamp
 		// ex.) tcp.CLOSING 0 1397031808
 		items := strings.Fields(line)
 		if len(items) < 3 {
+			if len(items) > 0 {
+				rejected = append(rejected, RejectedLine{line, "the line should consist of a name, a value and a timestamp"})
+			}
 			continue
 		}
 
 		key := items[0]
 
 		if g.Config.IncludePattern != nil && !g.Config.IncludePattern.MatchString(key) {
+			rejected = append(rejected, RejectedLine{line, "the name does not match include_pattern"})
 			continue
 		}
 
 		if g.Config.ExcludePattern != nil && g.Config.ExcludePattern.MatchString(key) {
+			rejected = append(rejected, RejectedLine{line, "the name matches exclude_pattern"})
 			continue
 		}
 
 		value, err := strconv.ParseFloat(items[1], 64)
 		if err != nil {
 			pluginLogger.Warningf("Failed to parse values (key=%s): %s", key, err)
+			rejected = append(rejected, RejectedLine{line, fmt.Sprintf("failed to parse the value: %s", err)})
 			continue
 		}
 
@@ -259,22 +325,56 @@ func (g *pluginGenerator) collectValues() (Values, error) {
 			metricTimestamp, err := strconv.ParseInt(items[2], 10, 64)
 			if err != nil {
 				pluginLogger.Warningf("Failed to parse time (key=%s): %s", key, err)
+				rejected = append(rejected, RejectedLine{line, fmt.Sprintf("failed to parse the timestamp: %s", err)})
 				continue
 			}
 
 			if mtsTime := time.Unix(metricTimestamp, 0); !validateActualTime(now, mtsTime) {
 				pluginLogger.Warningf("Rejected because it exceeds the acceptable time window. (key=%s): metric_timestamp=%d now=%d", key, mtsTime.Unix(), now.Unix())
+				rejected = append(rejected, RejectedLine{line, fmt.Sprintf("the timestamp exceeds the acceptable time window of %s", allowTimeWindow)})
 				continue
 			}
 
-			results[pluginPrefix+key] = ValueAttribute{Value: value, Time: &metricTimestamp}
+			results[prefix+key] = ValueAttribute{Value: value, Time: &metricTimestamp}
 			continue
 		}
 
-		results[pluginPrefix+key] = NewValueAttribute(value)
+		results[prefix+key] = NewValueAttribute(value)
 	}
 
-	return results, nil
+	return results, rejected
+}
+
+// PluginInspection is the result of running the plugin once as the agent does, for debugging the plugin.
+type PluginInspection struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Err      error // error on running the command
+
+	Values   Values
+	Rejected []RejectedLine
+
+	GraphDefs []*mkr.GraphDefsParam
+	MetaErr   error // error on loading the meta information, which is optional
+}
+
+// InspectPlugin runs the plugin to collect the values and the graph definitions.
+func InspectPlugin(conf *config.MetricPlugin) *PluginInspection {
+	g := &pluginGenerator{Config: conf}
+	var result PluginInspection
+
+	pluginMetaEnv := pluginConfigurationEnvName + "="
+	startedAt := time.Now()
+	result.Stdout, result.Stderr, result.ExitCode, result.Err = conf.Command.RunWithEnv([]string{pluginMetaEnv})
+	result.Duration = time.Since(startedAt)
+	if result.Err == nil {
+		result.Values, result.Rejected = g.parseValues(result.Stdout, time.Now())
+	}
+
+	result.GraphDefs, result.MetaErr = g.PrepareGraphDefs()
+	return &result
 }
 
 func validateActualTime(now, ts time.Time) bool {
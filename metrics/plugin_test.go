@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -99,15 +100,76 @@ func TestPluginCollectValuesWithBothPattern(t *testing.T) {
 	}
 }
 
+func TestPluginCollectValuesForService(t *testing.T) {
+	service := "shop"
+	g := &pluginGenerator{Config: &config.MetricPlugin{
+		Command: config.Command{Cmd: "echo 'orders.count 3 1397031808'"},
+		Service: &service,
+	}}
+	values, err := g.collectValues()
+	if err != nil {
+		t.Errorf("should not raise error: %v", err)
+	}
+	if v, ok := values["orders.count"]; !ok || v.Value != 3 {
+		t.Errorf("service metrics should be collected without prefix: %v", values)
+	}
+
+	g.Meta = &pluginMeta{
+		Graphs: map[string]CustomGraphDef{
+			"orders": {Label: "Orders", Unit: "integer", Metrics: []CustomGraphMetricDef{{Name: "count", Label: "Count"}}},
+		},
+	}
+	payloads := g.makeGraphDefsParam()
+	if len(payloads) != 1 || payloads[0].Name != "orders" || payloads[0].Metrics[0].Name != "orders.count" {
+		t.Errorf("graphs of service metrics should be defined without prefix: %+v", payloads)
+	}
+}
+
+func TestPluginParseValues(t *testing.T) {
+	g := &pluginGenerator{Config: &config.MetricPlugin{
+		ExcludePattern:     regexp.MustCompile(`^ignored\.`),
+		UsePluginTimestamp: true,
+	}}
+	now := time.Unix(1700000000, 0)
+	stdout := strings.Join([]string{
+		"foo.a\t1\t1700000000",
+		"ignored.a\t1\t1700000000",
+		"foo.b\tx\t1700000000",
+		"foo.c\t1\tnow",
+		"foo.d\t1\t1600000000",
+		"foo.e 1",
+		"",
+	}, "\n")
+	values, rejected := g.parseValues(stdout, now)
+	if len(values) != 1 || values["custom.foo.a"].Value != 1 {
+		t.Errorf("only custom.foo.a should be collected but %v", values)
+	}
+	expected := []string{
+		"the name matches exclude_pattern",
+		"failed to parse the value",
+		"failed to parse the timestamp",
+		"the timestamp exceeds the acceptable time window",
+		"the line should consist of a name, a value and a timestamp",
+	}
+	if len(rejected) != len(expected) {
+		t.Fatalf("%d lines should be rejected but %v", len(expected), rejected)
+	}
+	for i, r := range rejected {
+		if !strings.HasPrefix(r.Reason, expected[i]) {
+			t.Errorf("the reason of %q should be %q but %q", r.Line, expected[i], r.Reason)
+		}
+	}
+}
+
 func TestPluginMakeGraphDefsParam(t *testing.T) {
 	// this plugin emits "one.foo1", "one.foo2" and "two.bar1" metrics
 	g := &pluginGenerator{
 		Meta: &pluginMeta{
-			Graphs: map[string]customGraphDef{
+			Graphs: map[string]CustomGraphDef{
 				"one": {
 					Label: "My Graph One",
 					Unit:  "integer",
-					Metrics: []customGraphMetricDef{
+					Metrics: []CustomGraphMetricDef{
 						{
 							Name:    "foo1",
 							Label:   "Foo(1)",
@@ -122,7 +184,7 @@ func TestPluginMakeGraphDefsParam(t *testing.T) {
 				},
 				"two": {
 					Label: "My Graph Two",
-					Metrics: []customGraphMetricDef{
+					Metrics: []CustomGraphMetricDef{
 						{
 							Name:  "bar1",
 							Label: "Bar(1)",
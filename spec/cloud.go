@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -20,10 +22,14 @@ import (
 )
 
 // This Generator collects metadata about cloud instances.
-// Currently EC2, AzureVM and GCE are supported.
+// Currently EC2, AzureVM, GCE, OpenStack, Oracle Cloud, Alibaba Cloud and DigitalOcean are supported.
 // EC2: http://docs.aws.amazon.com/AWSEC2/latest/UserGuide/AESDG-chapter-instancedata.html
 // GCE: https://developers.google.com/compute/docs/metadata
 // AzureVM: https://docs.microsoft.com/azure/virtual-machines/virtual-machines-instancemetadataservice-overview
+// OpenStack: https://docs.openstack.org/nova/latest/user/metadata.html
+// Oracle Cloud: https://docs.oracle.com/iaas/Content/Compute/Tasks/gettingmetadata.htm
+// Alibaba Cloud: https://www.alibabacloud.com/help/ecs/user-guide/view-instance-metadata
+// DigitalOcean: https://docs.digitalocean.com/reference/api/metadata-api/
 
 // CloudGenerator definition
 type CloudGenerator struct {
@@ -56,14 +62,41 @@ type azureVMGenerator interface {
 	IsAzureVM(ctx context.Context) bool
 }
 
+type openStackGenerator interface {
+	CloudMetaGenerator
+	IsOpenStack(ctx context.Context) bool
+}
+
+type oracleGenerator interface {
+	CloudMetaGenerator
+	IsOracle(ctx context.Context) bool
+}
+
+type alibabaGenerator interface {
+	CloudMetaGenerator
+	IsAlibaba(ctx context.Context) bool
+}
+
+type digitalOceanGenerator interface {
+	CloudMetaGenerator
+	IsDigitalOcean(ctx context.Context) bool
+}
+
 var cloudLogger = logging.GetLogger("spec.cloud")
 
 var ec2BaseURL, gceMetaURL, azureVMBaseURL *url.URL
+var openStackBaseURL, oracleBaseURL, alibabaBaseURL, digitalOceanBaseURL *url.URL
 
 type cloudGeneratorSuggester struct {
 	ec2Generator     ec2Generator
 	gceGenerator     gceGenerator
 	azureVMGenerator azureVMGenerator
+
+	// the generators below may be nil
+	openStackGenerator    openStackGenerator
+	oracleGenerator       oracleGenerator
+	alibabaGenerator      alibabaGenerator
+	digitalOceanGenerator digitalOceanGenerator
 }
 
 // Suggest returns suitable CloudGenerator
@@ -78,39 +111,71 @@ func (s *cloudGeneratorSuggester) Suggest(conf *config.Config) *CloudGenerator {
 		return &CloudGenerator{s.gceGenerator}
 	case config.CloudPlatformAzureVM:
 		return &CloudGenerator{s.azureVMGenerator}
+	case config.CloudPlatformOpenStack:
+		return &CloudGenerator{s.openStackGenerator}
+	case config.CloudPlatformOracle:
+		return &CloudGenerator{s.oracleGenerator}
+	case config.CloudPlatformAlibaba:
+		return &CloudGenerator{s.alibabaGenerator}
+	case config.CloudPlatformDigitalOcean:
+		return &CloudGenerator{s.digitalOceanGenerator}
+	}
+
+	type candidate struct {
+		generator CloudMetaGenerator
+		detect    func(ctx context.Context) bool
+		// generic is true for the metadata service which other platforms may also serve
+		generic bool
+	}
+	candidates := []candidate{
+		{generator: s.ec2Generator, detect: s.ec2Generator.IsEC2},
+		{generator: s.gceGenerator, detect: s.gceGenerator.IsGCE},
+		{generator: s.azureVMGenerator, detect: s.azureVMGenerator.IsAzureVM},
+	}
+	if s.openStackGenerator != nil {
+		// Oracle Cloud also serves the OpenStack metadata service
+		candidates = append(candidates, candidate{generator: s.openStackGenerator, detect: s.openStackGenerator.IsOpenStack, generic: true})
+	}
+	if s.oracleGenerator != nil {
+		candidates = append(candidates, candidate{generator: s.oracleGenerator, detect: s.oracleGenerator.IsOracle})
+	}
+	if s.alibabaGenerator != nil {
+		candidates = append(candidates, candidate{generator: s.alibabaGenerator, detect: s.alibabaGenerator.IsAlibaba})
+	}
+	if s.digitalOceanGenerator != nil {
+		candidates = append(candidates, candidate{generator: s.digitalOceanGenerator, detect: s.digitalOceanGenerator.IsDigitalOcean})
 	}
 
 	var wg sync.WaitGroup
-	gCh := make(chan *CloudGenerator, 3)
+	gCh := make(chan *CloudGenerator, len(candidates))
+	// the generic one is suggested only if none of the others is detected
+	var generic *CloudGenerator
 
 	// cancelable context
 	ctx, cancel := context.WithCancel(context.Background())
 
-	wg.Add(3)
-	go func() {
-		if s.ec2Generator.IsEC2(ctx) {
-			gCh <- &CloudGenerator{s.ec2Generator}
-			cancel()
-		}
-		wg.Done()
-	}()
-	go func() {
-		if s.gceGenerator.IsGCE(ctx) {
-			gCh <- &CloudGenerator{s.gceGenerator}
-			cancel()
-		}
-		wg.Done()
-	}()
-	go func() {
-		if s.azureVMGenerator.IsAzureVM(ctx) {
-			gCh <- &CloudGenerator{s.azureVMGenerator}
+	wg.Add(len(candidates))
+	for _, c := range candidates {
+		go func() {
+			defer wg.Done()
+			if !c.detect(ctx) {
+				return
+			}
+			if c.generic {
+				generic = &CloudGenerator{c.generator}
+				return
+			}
+			gCh <- &CloudGenerator{c.generator}
 			cancel()
-		}
-		wg.Done()
-	}()
+		}()
+	}
 
 	go func() {
 		wg.Wait()
+		cancel()
+		if generic != nil {
+			gCh <- generic
+		}
 		// close so that `<-gCh` will receive nul
 		close(gCh)
 	}()
@@ -125,11 +190,19 @@ func init() {
 	ec2BaseURL, _ = url.Parse("http://169.254.169.254/latest")
 	gceMetaURL, _ = url.Parse("http://metadata.google.internal./computeMetadata/v1")
 	azureVMBaseURL, _ = url.Parse("http://169.254.169.254/metadata/instance")
+	openStackBaseURL, _ = url.Parse("http://169.254.169.254/openstack/latest")
+	oracleBaseURL, _ = url.Parse("http://169.254.169.254/opc/v2")
+	alibabaBaseURL, _ = url.Parse("http://100.100.100.200/latest/meta-data")
+	digitalOceanBaseURL, _ = url.Parse("http://169.254.169.254/metadata/v1")
 
 	CloudGeneratorSuggester = &cloudGeneratorSuggester{
-		ec2Generator:     &EC2Generator{baseURL: ec2BaseURL},
-		gceGenerator:     &GCEGenerator{gceMetaURL, gceMeta{}},
-		azureVMGenerator: &AzureVMGenerator{azureVMBaseURL},
+		ec2Generator:          &EC2Generator{baseURL: ec2BaseURL},
+		gceGenerator:          &GCEGenerator{gceMetaURL, gceMeta{}},
+		azureVMGenerator:      &AzureVMGenerator{azureVMBaseURL},
+		openStackGenerator:    &OpenStackGenerator{baseURL: openStackBaseURL},
+		oracleGenerator:       &OracleGenerator{baseURL: oracleBaseURL},
+		alibabaGenerator:      &AlibabaGenerator{baseURL: alibabaBaseURL},
+		digitalOceanGenerator: &DigitalOceanGenerator{baseURL: digitalOceanBaseURL},
 	}
 }
 
@@ -143,6 +216,34 @@ var httpCli = &http.Client{
 	},
 }
 
+// requestCloudMetadata requests the metadata service and returns the body.
+// The responses other than 200 are treated as errors.
+func requestCloudMetadata(ctx context.Context, u string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(req.Header, header)
+	resp, err := httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		_, err = io.Copy(io.Discard, resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == 404 {
+			return nil, fmt.Errorf("failed to request %s: %w", u, errCloudMetadataNotFound)
+		}
+		return nil, fmt.Errorf("failed to request %s. response code: %d", u, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+var errCloudMetadataNotFound = errors.New("not found")
+
 // EC2Generator meta generator for EC2
 type EC2Generator struct {
 	baseURL *url.URL
@@ -0,0 +1,71 @@
+package spec
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Songmu/retry"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// AlibabaGenerator meta generator for Alibaba Cloud ECS
+type AlibabaGenerator struct {
+	baseURL *url.URL
+}
+
+func (g *AlibabaGenerator) getMetadata(ctx context.Context, key string) (string, error) {
+	body, err := requestCloudMetadata(ctx, g.baseURL.String()+"/"+key, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// IsAlibaba checks current environment is Alibaba Cloud or not
+func (g *AlibabaGenerator) IsAlibaba(ctx context.Context) bool {
+	err := retry.WithContext(ctx, 2, 2*time.Second, func() error {
+		_, err := g.getMetadata(ctx, "instance-id")
+		return err
+	})
+	return err == nil
+}
+
+// Generate collects metadata from cloud platform.
+func (g *AlibabaGenerator) Generate() (*mackerel.Cloud, error) {
+	metadataKeys := map[string]string{
+		"instance-id":            "instance-id",
+		"instance/instance-type": "instance-type",
+		"region-id":              "region-id",
+		"zone-id":                "zone-id",
+		"hostname":               "hostname",
+		"private-ipv4":           "private-ipv4",
+		"eipv4":                  "eipv4",
+	}
+	metadata := make(map[string]string)
+	for key, name := range metadataKeys {
+		value, err := g.getMetadata(context.Background(), key)
+		if err != nil {
+			// eipv4 is not found without an elastic IP address
+			cloudLogger.Debugf("Error while reading '%s': %s", key, err)
+			continue
+		}
+		metadata[name] = value
+	}
+	return &mackerel.Cloud{Provider: "alibaba", MetaData: metadata}, nil
+}
+
+// SuggestCustomIdentifier suggests the identifier of the Alibaba Cloud ECS instance
+func (g *AlibabaGenerator) SuggestCustomIdentifier() (string, error) {
+	identifier := ""
+	err := retry.Retry(3, 2*time.Second, func() error {
+		instanceID, err := g.getMetadata(context.Background(), "instance-id")
+		if err != nil {
+			return err
+		}
+		identifier = instanceID + ".ecs.aliyun.com"
+		return nil
+	})
+	return identifier, err
+}
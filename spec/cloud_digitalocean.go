@@ -0,0 +1,87 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Songmu/retry"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// DigitalOceanGenerator meta generator for DigitalOcean Droplets
+type DigitalOceanGenerator struct {
+	baseURL *url.URL
+}
+
+type digitalOceanAddress struct {
+	IPv4 struct {
+		IPAddress string `json:"ip_address"`
+	} `json:"ipv4"`
+}
+
+type digitalOceanMeta struct {
+	DropletID  uint64 `json:"droplet_id"`
+	Hostname   string `json:"hostname"`
+	Region     string `json:"region"`
+	Interfaces struct {
+		Public  []digitalOceanAddress `json:"public"`
+		Private []digitalOceanAddress `json:"private"`
+	} `json:"interfaces"`
+}
+
+func (g *DigitalOceanGenerator) requestMeta(ctx context.Context) (*digitalOceanMeta, error) {
+	body, err := requestCloudMetadata(ctx, g.baseURL.String()+".json", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta digitalOceanMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, err
+	}
+	if meta.DropletID == 0 {
+		return nil, fmt.Errorf("invalid droplet id")
+	}
+	return &meta, nil
+}
+
+// IsDigitalOcean checks current environment is DigitalOcean or not
+func (g *DigitalOceanGenerator) IsDigitalOcean(ctx context.Context) bool {
+	err := retry.WithContext(ctx, 2, 2*time.Second, func() error {
+		_, err := g.requestMeta(ctx)
+		return err
+	})
+	return err == nil
+}
+
+// Generate collects metadata from cloud platform.
+func (g *DigitalOceanGenerator) Generate() (*mackerel.Cloud, error) {
+	meta, err := g.requestMeta(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{
+		"droplet-id": strconv.FormatUint(meta.DropletID, 10),
+		"hostname":   meta.Hostname,
+		"region":     meta.Region,
+	}
+	if len(meta.Interfaces.Public) > 0 {
+		metadata["public-ipv4"] = meta.Interfaces.Public[0].IPv4.IPAddress
+	}
+	if len(meta.Interfaces.Private) > 0 {
+		metadata["private-ipv4"] = meta.Interfaces.Private[0].IPv4.IPAddress
+	}
+	return &mackerel.Cloud{Provider: "digitalocean", MetaData: metadata}, nil
+}
+
+// SuggestCustomIdentifier suggests the identifier of the Droplet
+func (g *DigitalOceanGenerator) SuggestCustomIdentifier() (string, error) {
+	meta, err := g.requestMeta(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(meta.DropletID, 10) + ".droplet.digitalocean.com", nil
+}
@@ -0,0 +1,147 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CloudTagsGenerator is implemented by the CloudMetaGenerators which can retrieve the tags of the instance.
+type CloudTagsGenerator interface {
+	Tags(ctx context.Context) (map[string]string, error)
+}
+
+// SpotInstanceAction is the notice that the spot instance is going to be interrupted.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/spot-instance-termination-notices.html
+type SpotInstanceAction struct {
+	Action string    `json:"action"` // "terminate", "stop" or "hibernate"
+	Time   time.Time `json:"time"`
+}
+
+// SpotInterruptionNotifier is implemented by the CloudMetaGenerators which can retrieve the interruption notices of spot instances.
+type SpotInterruptionNotifier interface {
+	// SpotInstanceAction returns nil without error when no interruption is scheduled.
+	SpotInstanceAction(ctx context.Context) (*SpotInstanceAction, error)
+	// RebalanceRecommendation returns the zero time without error when not recommended.
+	RebalanceRecommendation(ctx context.Context) (time.Time, error)
+}
+
+// TerminationNotifier is implemented by the CloudMetaGenerators which can tell the instance is going to be terminated.
+type TerminationNotifier interface {
+	IsTerminating(ctx context.Context) (bool, error)
+}
+
+// LoadCloudTag loads the value of the tag of the instance for "cloud_tag" backend of the host id storage.
+// cGen is the generator of the cloud platform on which the host is running, or nil.
+func LoadCloudTag(cGen *CloudGenerator, key string) (string, error) {
+	if cGen == nil {
+		return "", errors.New("the host is not running on any cloud platform")
+	}
+	tagsGen, ok := cGen.CloudMetaGenerator.(CloudTagsGenerator)
+	if !ok {
+		return "", errors.New("the tags are not supported on this cloud platform")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tags, err := tagsGen.Tags(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(tags[key]), nil
+}
+
+func (g *EC2Generator) requestMetadata(ctx context.Context, key string) ([]byte, error) {
+	var header http.Header
+	if token := g.refreshToken(ctx); token != "" {
+		header = http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	}
+	return requestCloudMetadata(ctx, g.baseURL.String()+"/meta-data/"+key, header)
+}
+
+// Tags returns the tags of the instance.
+// They are available only when the access to the tags in the instance metadata is allowed.
+func (g *EC2Generator) Tags(ctx context.Context) (map[string]string, error) {
+	body, err := g.requestMetadata(ctx, "tags/instance")
+	if err != nil {
+		if errors.Is(err, errCloudMetadataNotFound) {
+			return nil, fmt.Errorf("the instance tags are not allowed to access in the instance metadata: %w", err)
+		}
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for key := range strings.SplitSeq(string(body), "\n") {
+		if key == "" {
+			continue
+		}
+		value, err := g.requestMetadata(ctx, "tags/instance/"+key)
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = string(value)
+	}
+	return tags, nil
+}
+
+/*
+SpotInstanceAction retrieves spot/instance-action.
+
+	{"action": "terminate", "time": "2017-09-18T08:22:00Z"}
+*/
+func (g *EC2Generator) SpotInstanceAction(ctx context.Context) (*SpotInstanceAction, error) {
+	body, err := g.requestMetadata(ctx, "spot/instance-action")
+	if err != nil {
+		if errors.Is(err, errCloudMetadataNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var action SpotInstanceAction
+	if err := json.Unmarshal(body, &action); err != nil {
+		return nil, fmt.Errorf("failed to parse spot/instance-action: %w", err)
+	}
+	return &action, nil
+}
+
+/*
+RebalanceRecommendation retrieves events/recommendations/rebalance.
+
+	{"noticeTime": "2020-10-27T08:22:00Z"}
+*/
+func (g *EC2Generator) RebalanceRecommendation(ctx context.Context) (time.Time, error) {
+	body, err := g.requestMetadata(ctx, "events/recommendations/rebalance")
+	if err != nil {
+		if errors.Is(err, errCloudMetadataNotFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	var recommendation struct {
+		NoticeTime time.Time `json:"noticeTime"`
+	}
+	if err := json.Unmarshal(body, &recommendation); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse events/recommendations/rebalance: %w", err)
+	}
+	return recommendation.NoticeTime, nil
+}
+
+// IsTerminating checks whether the instance is going to be terminated by the Auto Scaling group or the spot interruption.
+// https://docs.aws.amazon.com/autoscaling/ec2/userguide/retrieving-target-lifecycle-state-through-imds.html
+func (g *EC2Generator) IsTerminating(ctx context.Context) (bool, error) {
+	body, err := g.requestMetadata(ctx, "autoscaling/target-lifecycle-state")
+	if err == nil && string(body) == "Terminated" {
+		return true, nil
+	}
+	// not found when the instance does not belong to any Auto Scaling group
+	if err != nil && !errors.Is(err, errCloudMetadataNotFound) {
+		return false, err
+	}
+	action, err := g.SpotInstanceAction(ctx)
+	if err != nil {
+		return false, err
+	}
+	return action != nil && action.Action == "terminate", nil
+}
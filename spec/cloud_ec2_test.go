@@ -0,0 +1,123 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newEC2MetadataServer(t *testing.T, metadata map[string]string) *EC2Generator {
+	const token = "very-secret"
+	u := newCloudMetadataServer(t, func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == "PUT" && req.URL.Path == "/api/token" {
+			fmt.Fprint(res, token)
+			return
+		}
+		if req.Header.Get("X-aws-ec2-metadata-token") != token {
+			http.Error(res, "Unauthorized", 401)
+			return
+		}
+		value, ok := metadata[req.URL.Path]
+		if !ok {
+			http.Error(res, "not found", 404)
+			return
+		}
+		fmt.Fprint(res, value)
+	})
+	return &EC2Generator{baseURL: u}
+}
+
+func TestEC2GeneratorTags(t *testing.T) {
+	g := newEC2MetadataServer(t, map[string]string{
+		"/meta-data/tags/instance":                "Name\nmackerel-roles",
+		"/meta-data/tags/instance/Name":           "web-1",
+		"/meta-data/tags/instance/mackerel-roles": "web:app,web:batch",
+	})
+	tags, err := g.Tags(context.Background())
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expect := map[string]string{"Name": "web-1", "mackerel-roles": "web:app,web:batch"}
+	if !reflect.DeepEqual(tags, expect) {
+		t.Errorf("tags should be %v but %v", expect, tags)
+	}
+
+	// the instance tags are not allowed in the instance metadata
+	g = newEC2MetadataServer(t, map[string]string{})
+	if _, err := g.Tags(context.Background()); err == nil {
+		t.Errorf("should raise error")
+	}
+}
+
+func TestEC2GeneratorSpotInstanceAction(t *testing.T) {
+	g := newEC2MetadataServer(t, map[string]string{})
+	action, err := g.SpotInstanceAction(context.Background())
+	if err != nil || action != nil {
+		t.Errorf("action should be nil but %v (%v)", action, err)
+	}
+	noticeTime, err := g.RebalanceRecommendation(context.Background())
+	if err != nil || !noticeTime.IsZero() {
+		t.Errorf("noticeTime should be zero but %v (%v)", noticeTime, err)
+	}
+
+	g = newEC2MetadataServer(t, map[string]string{
+		"/meta-data/spot/instance-action":             `{"action": "terminate", "time": "2017-09-18T08:22:00Z"}`,
+		"/meta-data/events/recommendations/rebalance": `{"noticeTime": "2017-09-18T08:20:00Z"}`,
+	})
+	action, err = g.SpotInstanceAction(context.Background())
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expect := &SpotInstanceAction{Action: "terminate", Time: time.Date(2017, 9, 18, 8, 22, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(action, expect) {
+		t.Errorf("action should be %v but %v", expect, action)
+	}
+	noticeTime, err = g.RebalanceRecommendation(context.Background())
+	if err != nil || !noticeTime.Equal(time.Date(2017, 9, 18, 8, 20, 0, 0, time.UTC)) {
+		t.Errorf("unexpected noticeTime: %v (%v)", noticeTime, err)
+	}
+}
+
+func TestEC2GeneratorIsTerminating(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		expect   bool
+	}{
+		{"not in any group", map[string]string{}, false},
+		{"in service", map[string]string{"/meta-data/autoscaling/target-lifecycle-state": "InService"}, false},
+		{"scaled in", map[string]string{"/meta-data/autoscaling/target-lifecycle-state": "Terminated"}, true},
+		{"spot stopped", map[string]string{"/meta-data/spot/instance-action": `{"action": "stop", "time": "2017-09-18T08:22:00Z"}`}, false},
+		{"spot terminated", map[string]string{"/meta-data/spot/instance-action": `{"action": "terminate", "time": "2017-09-18T08:22:00Z"}`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newEC2MetadataServer(t, tt.metadata)
+			terminating, err := g.IsTerminating(context.Background())
+			if err != nil {
+				t.Fatalf("should not raise error: %s", err)
+			}
+			if terminating != tt.expect {
+				t.Errorf("IsTerminating should be %t but %t", tt.expect, terminating)
+			}
+		})
+	}
+}
+
+func TestLoadCloudTag(t *testing.T) {
+	cGen := &CloudGenerator{newEC2MetadataServer(t, map[string]string{
+		"/meta-data/tags/instance":                  "mackerel-host-id",
+		"/meta-data/tags/instance/mackerel-host-id": "3Vbw4Czg5Gy",
+	})}
+	hostID, err := LoadCloudTag(cGen, "mackerel-host-id")
+	if err != nil || hostID != "3Vbw4Czg5Gy" {
+		t.Errorf("hostID should be 3Vbw4Czg5Gy but %q (%v)", hostID, err)
+	}
+
+	if _, err := LoadCloudTag(nil, "mackerel-host-id"); err == nil {
+		t.Errorf("should raise error when not running on any cloud platform")
+	}
+}
@@ -0,0 +1,73 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Songmu/retry"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// OpenStackGenerator meta generator for OpenStack
+type OpenStackGenerator struct {
+	baseURL *url.URL
+}
+
+type openStackMeta struct {
+	UUID             string `json:"uuid"`
+	Name             string `json:"name"`
+	Hostname         string `json:"hostname"`
+	AvailabilityZone string `json:"availability_zone"`
+	ProjectID        string `json:"project_id"`
+}
+
+func (g *OpenStackGenerator) requestMeta(ctx context.Context) (*openStackMeta, error) {
+	body, err := requestCloudMetadata(ctx, g.baseURL.String()+"/meta_data.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta openStackMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, err
+	}
+	if meta.UUID == "" {
+		return nil, fmt.Errorf("invalid instance id")
+	}
+	return &meta, nil
+}
+
+// IsOpenStack checks current environment is OpenStack or not
+func (g *OpenStackGenerator) IsOpenStack(ctx context.Context) bool {
+	err := retry.WithContext(ctx, 2, 2*time.Second, func() error {
+		_, err := g.requestMeta(ctx)
+		return err
+	})
+	return err == nil
+}
+
+// Generate collects metadata from cloud platform.
+func (g *OpenStackGenerator) Generate() (*mackerel.Cloud, error) {
+	meta, err := g.requestMeta(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &mackerel.Cloud{Provider: "openstack", MetaData: map[string]string{
+		"instance-id":       meta.UUID,
+		"name":              meta.Name,
+		"hostname":          meta.Hostname,
+		"availability-zone": meta.AvailabilityZone,
+		"project-id":        meta.ProjectID,
+	}}, nil
+}
+
+// SuggestCustomIdentifier suggests the identifier of the OpenStack instance
+func (g *OpenStackGenerator) SuggestCustomIdentifier() (string, error) {
+	meta, err := g.requestMeta(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return meta.UUID + ".nova.openstack", nil
+}
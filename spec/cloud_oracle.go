@@ -0,0 +1,84 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Songmu/retry"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// OracleGenerator meta generator for Oracle Cloud Infrastructure
+type OracleGenerator struct {
+	baseURL *url.URL
+}
+
+type oracleMeta struct {
+	ID                 string `json:"id"`
+	DisplayName        string `json:"displayName"`
+	Hostname           string `json:"hostname"`
+	Shape              string `json:"shape"`
+	Region             string `json:"canonicalRegionName"`
+	AvailabilityDomain string `json:"availabilityDomain"`
+	FaultDomain        string `json:"faultDomain"`
+	CompartmentID      string `json:"compartmentId"`
+}
+
+// IMDSv2 of Oracle Cloud requires this header
+var oracleHeader = http.Header{"Authorization": {"Bearer Oracle"}}
+
+func (g *OracleGenerator) requestMeta(ctx context.Context) (*oracleMeta, error) {
+	body, err := requestCloudMetadata(ctx, g.baseURL.String()+"/instance/", oracleHeader)
+	if err != nil {
+		return nil, err
+	}
+	var meta oracleMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, err
+	}
+	if meta.ID == "" {
+		return nil, fmt.Errorf("invalid instance id")
+	}
+	return &meta, nil
+}
+
+// IsOracle checks current environment is Oracle Cloud or not
+func (g *OracleGenerator) IsOracle(ctx context.Context) bool {
+	err := retry.WithContext(ctx, 2, 2*time.Second, func() error {
+		_, err := g.requestMeta(ctx)
+		return err
+	})
+	return err == nil
+}
+
+// Generate collects metadata from cloud platform.
+func (g *OracleGenerator) Generate() (*mackerel.Cloud, error) {
+	meta, err := g.requestMeta(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &mackerel.Cloud{Provider: "oracle", MetaData: map[string]string{
+		"instance-id":         meta.ID,
+		"display-name":        meta.DisplayName,
+		"hostname":            meta.Hostname,
+		"shape":               meta.Shape,
+		"region":              meta.Region,
+		"availability-domain": meta.AvailabilityDomain,
+		"fault-domain":        meta.FaultDomain,
+		"compartment-id":      meta.CompartmentID,
+	}}, nil
+}
+
+// SuggestCustomIdentifier suggests the identifier of the Oracle Cloud instance
+func (g *OracleGenerator) SuggestCustomIdentifier() (string, error) {
+	meta, err := g.requestMeta(context.Background())
+	if err != nil {
+		return "", err
+	}
+	// OCID is unique across all tenancies and regions
+	return meta.ID + ".compute.oraclecloud.com", nil
+}
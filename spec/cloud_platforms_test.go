@@ -0,0 +1,304 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent/config"
+)
+
+func newCloudMetadataServer(t *testing.T, handler http.HandlerFunc) *url.URL {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// notFoundContext is used for the probes against the other platforms not to wait for the retries
+func notFoundContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestOpenStackGenerator(t *testing.T) {
+	u := newCloudMetadataServer(t, func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/openstack/latest/meta_data.json" {
+			http.Error(res, "not found", 404)
+			return
+		}
+		fmt.Fprint(res, `{"uuid": "d8e02d56-2648-49a3-bf97-6be8f1204f38", "name": "web-1", "hostname": "web-1.novalocal",
+  "availability_zone": "nova", "project_id": "f7ac731cc11f40efbc03a9f9e1d1d21f", "launch_index": 0}`)
+	})
+	g := &OpenStackGenerator{baseURL: u.JoinPath("/openstack/latest")}
+	if !g.IsOpenStack(context.Background()) {
+		t.Errorf("IsOpenStack should be true")
+	}
+	cloud, err := g.Generate()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expect := map[string]string{
+		"instance-id":       "d8e02d56-2648-49a3-bf97-6be8f1204f38",
+		"name":              "web-1",
+		"hostname":          "web-1.novalocal",
+		"availability-zone": "nova",
+		"project-id":        "f7ac731cc11f40efbc03a9f9e1d1d21f",
+	}
+	if cloud.Provider != "openstack" || !reflect.DeepEqual(cloud.MetaData, expect) {
+		t.Errorf("unexpected metadata: %+v", cloud)
+	}
+	customIdentifier, err := (&OpenStackGenerator{baseURL: g.baseURL}).SuggestCustomIdentifier()
+	if err != nil || customIdentifier != "d8e02d56-2648-49a3-bf97-6be8f1204f38.nova.openstack" {
+		t.Errorf("unexpected customIdentifier: %s (%v)", customIdentifier, err)
+	}
+
+	// EC2 also serves the metadata service on the same address
+	g = &OpenStackGenerator{baseURL: u.JoinPath("/latest")}
+	if g.IsOpenStack(notFoundContext(t)) {
+		t.Errorf("IsOpenStack should be false")
+	}
+}
+
+func TestOracleGenerator(t *testing.T) {
+	u := newCloudMetadataServer(t, func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/opc/v2/instance/" {
+			http.Error(res, "not found", 404)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer Oracle" {
+			http.Error(res, "unauthorized", 401)
+			return
+		}
+		fmt.Fprint(res, `{"id": "ocid1.instance.oc1.phx.abyhqljt", "displayName": "web-1", "hostname": "web-1",
+  "shape": "VM.Standard.E4.Flex", "region": "phx", "canonicalRegionName": "us-phoenix-1",
+  "availabilityDomain": "EMIr:PHX-AD-1", "faultDomain": "FAULT-DOMAIN-3", "compartmentId": "ocid1.tenancy.oc1..aaaa"}`)
+	})
+	g := &OracleGenerator{baseURL: u.JoinPath("/opc/v2")}
+	if !g.IsOracle(context.Background()) {
+		t.Errorf("IsOracle should be true")
+	}
+	cloud, err := g.Generate()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expect := map[string]string{
+		"instance-id":         "ocid1.instance.oc1.phx.abyhqljt",
+		"display-name":        "web-1",
+		"hostname":            "web-1",
+		"shape":               "VM.Standard.E4.Flex",
+		"region":              "us-phoenix-1",
+		"availability-domain": "EMIr:PHX-AD-1",
+		"fault-domain":        "FAULT-DOMAIN-3",
+		"compartment-id":      "ocid1.tenancy.oc1..aaaa",
+	}
+	if cloud.Provider != "oracle" || !reflect.DeepEqual(cloud.MetaData, expect) {
+		t.Errorf("unexpected metadata: %+v", cloud)
+	}
+	customIdentifier, err := (&OracleGenerator{baseURL: g.baseURL}).SuggestCustomIdentifier()
+	if err != nil || customIdentifier != "ocid1.instance.oc1.phx.abyhqljt.compute.oraclecloud.com" {
+		t.Errorf("unexpected customIdentifier: %s (%v)", customIdentifier, err)
+	}
+
+	g = &OracleGenerator{baseURL: u.JoinPath("/opc/v1")}
+	if g.IsOracle(notFoundContext(t)) {
+		t.Errorf("IsOracle should be false")
+	}
+}
+
+func TestAlibabaGenerator(t *testing.T) {
+	metadata := map[string]string{
+		"/latest/meta-data/instance-id":            "i-bp67acfmxazb4p****",
+		"/latest/meta-data/instance/instance-type": "ecs.g6.large",
+		"/latest/meta-data/region-id":              "cn-hangzhou",
+		"/latest/meta-data/zone-id":                "cn-hangzhou-i",
+		"/latest/meta-data/hostname":               "iZbp67acfmxazb4p****Z",
+		"/latest/meta-data/private-ipv4":           "192.168.0.10",
+	}
+	u := newCloudMetadataServer(t, func(res http.ResponseWriter, req *http.Request) {
+		value, ok := metadata[req.URL.Path]
+		if !ok {
+			http.Error(res, "not found", 404)
+			return
+		}
+		fmt.Fprint(res, value)
+	})
+	g := &AlibabaGenerator{baseURL: u.JoinPath("/latest/meta-data")}
+	if !g.IsAlibaba(context.Background()) {
+		t.Errorf("IsAlibaba should be true")
+	}
+	cloud, err := g.Generate()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	// eipv4 is omitted without an elastic IP address
+	expect := map[string]string{
+		"instance-id":   "i-bp67acfmxazb4p****",
+		"instance-type": "ecs.g6.large",
+		"region-id":     "cn-hangzhou",
+		"zone-id":       "cn-hangzhou-i",
+		"hostname":      "iZbp67acfmxazb4p****Z",
+		"private-ipv4":  "192.168.0.10",
+	}
+	if cloud.Provider != "alibaba" || !reflect.DeepEqual(cloud.MetaData, expect) {
+		t.Errorf("unexpected metadata: %+v", cloud)
+	}
+	customIdentifier, err := g.SuggestCustomIdentifier()
+	if err != nil || customIdentifier != "i-bp67acfmxazb4p****.ecs.aliyun.com" {
+		t.Errorf("unexpected customIdentifier: %s (%v)", customIdentifier, err)
+	}
+
+	g = &AlibabaGenerator{baseURL: u.JoinPath("/meta-data")}
+	if g.IsAlibaba(notFoundContext(t)) {
+		t.Errorf("IsAlibaba should be false")
+	}
+}
+
+func TestDigitalOceanGenerator(t *testing.T) {
+	u := newCloudMetadataServer(t, func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/metadata/v1.json" {
+			http.Error(res, "not found", 404)
+			return
+		}
+		fmt.Fprint(res, `{"droplet_id": 2756294, "hostname": "sample-droplet", "region": "nyc3",
+  "interfaces": {
+    "public": [{"ipv4": {"ip_address": "203.0.113.10", "netmask": "255.255.240.0"}, "type": "public"}],
+    "private": [{"ipv4": {"ip_address": "10.132.255.113", "netmask": "255.255.0.0"}, "type": "private"}]
+  }}`)
+	})
+	g := &DigitalOceanGenerator{baseURL: u.JoinPath("/metadata/v1")}
+	if !g.IsDigitalOcean(context.Background()) {
+		t.Errorf("IsDigitalOcean should be true")
+	}
+	cloud, err := g.Generate()
+	if err != nil {
+		t.Fatalf("should not raise error: %s", err)
+	}
+	expect := map[string]string{
+		"droplet-id":   "2756294",
+		"hostname":     "sample-droplet",
+		"region":       "nyc3",
+		"public-ipv4":  "203.0.113.10",
+		"private-ipv4": "10.132.255.113",
+	}
+	if cloud.Provider != "digitalocean" || !reflect.DeepEqual(cloud.MetaData, expect) {
+		t.Errorf("unexpected metadata: %+v", cloud)
+	}
+	customIdentifier, err := (&DigitalOceanGenerator{baseURL: g.baseURL}).SuggestCustomIdentifier()
+	if err != nil || customIdentifier != "2756294.droplet.digitalocean.com" {
+		t.Errorf("unexpected customIdentifier: %s (%v)", customIdentifier, err)
+	}
+
+	// Azure serves the metadata service on /metadata/instance
+	g = &DigitalOceanGenerator{baseURL: u.JoinPath("/metadata/instance")}
+	if g.IsDigitalOcean(notFoundContext(t)) {
+		t.Errorf("IsDigitalOcean should be false")
+	}
+}
+
+type mockOpenStackCloudMetaGenerator struct {
+	mockCloudMetaGenerator
+	isOpenStack bool
+}
+
+func (g *mockOpenStackCloudMetaGenerator) IsOpenStack(ctx context.Context) bool {
+	return g.isOpenStack
+}
+
+type mockOracleCloudMetaGenerator struct {
+	mockCloudMetaGenerator
+	isOracle bool
+}
+
+func (g *mockOracleCloudMetaGenerator) IsOracle(ctx context.Context) bool {
+	return g.isOracle
+}
+
+type mockAlibabaCloudMetaGenerator struct {
+	mockCloudMetaGenerator
+	isAlibaba bool
+}
+
+func (g *mockAlibabaCloudMetaGenerator) IsAlibaba(ctx context.Context) bool {
+	return g.isAlibaba
+}
+
+type mockDigitalOceanCloudMetaGenerator struct {
+	mockCloudMetaGenerator
+	isDigitalOcean bool
+}
+
+func (g *mockDigitalOceanCloudMetaGenerator) IsDigitalOcean(ctx context.Context) bool {
+	return g.isDigitalOcean
+}
+
+func TestCloudGeneratorSuggester_OtherPlatforms(t *testing.T) {
+	newSuggester := func(detected string) *cloudGeneratorSuggester {
+		return &cloudGeneratorSuggester{
+			ec2Generator:          &mockEC2CloudMetaGenerator{isEC2: false},
+			gceGenerator:          &mockGCECloudMetaGenerator{isGCE: false},
+			azureVMGenerator:      &mockAzureCloudMetaGenerator{isAzureVM: false},
+			openStackGenerator:    &mockOpenStackCloudMetaGenerator{isOpenStack: detected == "openstack"},
+			oracleGenerator:       &mockOracleCloudMetaGenerator{isOracle: detected == "oracle"},
+			alibabaGenerator:      &mockAlibabaCloudMetaGenerator{isAlibaba: detected == "alibaba"},
+			digitalOceanGenerator: &mockDigitalOceanCloudMetaGenerator{isDigitalOcean: detected == "digitalocean"},
+		}
+	}
+	tests := []struct {
+		platform config.CloudPlatform
+		is       func(CloudMetaGenerator) bool
+	}{
+		{config.CloudPlatformOpenStack, func(g CloudMetaGenerator) bool { _, ok := g.(openStackGenerator); return ok }},
+		{config.CloudPlatformOracle, func(g CloudMetaGenerator) bool { _, ok := g.(oracleGenerator); return ok }},
+		{config.CloudPlatformAlibaba, func(g CloudMetaGenerator) bool { _, ok := g.(alibabaGenerator); return ok }},
+		{config.CloudPlatformDigitalOcean, func(g CloudMetaGenerator) bool { _, ok := g.(digitalOceanGenerator); return ok }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform.String(), func(t *testing.T) {
+			// detected automatically
+			cGen := newSuggester(tt.platform.String()).Suggest(&config.Config{})
+			if cGen == nil || !tt.is(cGen.CloudMetaGenerator) {
+				t.Errorf("%s should be suggested but %v", tt.platform, cGen)
+			}
+			// specified by cloud_platform
+			cGen = newSuggester("").Suggest(&config.Config{CloudPlatform: tt.platform})
+			if cGen == nil || !tt.is(cGen.CloudMetaGenerator) {
+				t.Errorf("%s should be suggested but %v", tt.platform, cGen)
+			}
+		})
+	}
+
+	if cGen := newSuggester("").Suggest(&config.Config{}); cGen != nil {
+		t.Errorf("cGen should be nil but %v", cGen)
+	}
+
+	// Oracle Cloud also serves the OpenStack metadata service
+	suggester := newSuggester("openstack")
+	suggester.oracleGenerator = &slowOracleCloudMetaGenerator{}
+	cGen := suggester.Suggest(&config.Config{})
+	if cGen == nil {
+		t.Fatal("cGen should not be nil.")
+	}
+	if _, ok := cGen.CloudMetaGenerator.(oracleGenerator); !ok {
+		t.Errorf("oracle should be suggested prior to openstack but %v", cGen)
+	}
+}
+
+type slowOracleCloudMetaGenerator struct {
+	mockCloudMetaGenerator
+}
+
+func (g *slowOracleCloudMetaGenerator) IsOracle(ctx context.Context) bool {
+	time.Sleep(100 * time.Millisecond)
+	return true
+}
@@ -4,6 +4,7 @@ package spec
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/mackerelio/mackerel-client-go"
 
@@ -20,15 +21,29 @@ func (g *FilesystemGenerator) Generate() (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Mount options are optional, so failing to collect them is not an error.
+	mountOptions := map[string]string{}
+	mounts, err := util.CollectMounts()
+	if err != nil {
+		logger.Warningf("Failed to collect mount options: %s", err)
+	}
+	for _, m := range mounts {
+		mountOptions[m.Mountpoint] = strings.Join(m.Options, ",")
+	}
+
 	ret := make(mackerel.FileSystem)
 	for _, v := range filesystems {
-		ret[v.Name] = map[string]any{
+		fs := map[string]any{
 			"kb_size":      v.Blocks,
 			"kb_used":      v.Used,
 			"kb_available": v.Available,
 			"percent_used": fmt.Sprintf("%d%%", v.Capacity),
 			"mount":        v.Mounted,
 		}
+		if opts, ok := mountOptions[v.Mounted]; ok {
+			fs["mount_options"] = opts
+		}
+		ret[v.Name] = fs
 	}
 	return ret, nil
 }
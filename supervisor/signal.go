@@ -0,0 +1,12 @@
+//go:build !windows
+
+package supervisor
+
+import (
+	"os"
+	"syscall"
+)
+
+// ReloadSignal is sent to the child process to stop it for reloading the configuration,
+// so that the child can tell the reload from the stop of the agent.
+var ReloadSignal os.Signal = syscall.SIGUSR2
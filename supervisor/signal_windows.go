@@ -0,0 +1,8 @@
+//go:build windows
+
+package supervisor
+
+import "os"
+
+// ReloadSignal is nil on Windows, where the agent does not run in the supervisor mode.
+var ReloadSignal os.Signal
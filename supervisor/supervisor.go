@@ -84,19 +84,26 @@ func (sv *supervisor) launched() bool {
 	return sv.getCmd().Process != nil && time.Now().After(sv.getStartAt().Add(spawnInterval))
 }
 
-func (sv *supervisor) buildCmd() *exec.Cmd {
+// ReloadedEnv is set to the child process spawned again to reload the configuration.
+const ReloadedEnv = "MACKEREL_AGENT_RELOADED"
+
+func (sv *supervisor) buildCmd(reloaded bool) *exec.Cmd {
 	argv := append(sv.argv, "-child")
 	cmd := exec.Command(sv.prog, argv...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
+	if reloaded {
+		cmd.Env = append(os.Environ(), ReloadedEnv+"=1")
+	}
 	return cmd
 }
 
 func (sv *supervisor) start() error {
 	sv.mu.Lock()
+	reloaded := sv.getHupped()
 	sv.setHupped(false)
 	defer sv.mu.Unlock()
-	sv.cmd = sv.buildCmd()
+	sv.cmd = sv.buildCmd(reloaded)
 	sv.startAt = time.Now()
 	return sv.cmd.Start()
 }
@@ -124,7 +131,7 @@ func (sv *supervisor) reload() error {
 		return err
 	}
 	sv.setHupped(true)
-	return sv.getCmd().Process.Signal(syscall.SIGTERM)
+	return sv.getCmd().Process.Signal(ReloadSignal)
 }
 
 func (sv *supervisor) wait() (err error) {
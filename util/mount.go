@@ -0,0 +1,60 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package util
+
+import (
+	"slices"
+
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// MountStat is a mounted filesystem and its mount options.
+type MountStat struct {
+	Device     string
+	Mountpoint string
+	Fstype     string
+	Options    []string
+}
+
+// ReadOnly returns true if the filesystem is mounted read-only.
+func (m *MountStat) ReadOnly() bool {
+	return slices.Contains(m.Options, "ro")
+}
+
+// CollectMounts collects local filesystems with their mount options.
+func CollectMounts() ([]*MountStat, error) {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+	mounts := make([]*MountStat, 0, len(partitions))
+	for _, p := range partitions {
+		mounts = append(mounts, &MountStat{
+			Device:     p.Device,
+			Mountpoint: p.Mountpoint,
+			Fstype:     p.Fstype,
+			Options:    p.Opts,
+		})
+	}
+	return mounts, nil
+}
+
+// InodeStat is inode statistics of a filesystem retrieved by statfs(2).
+type InodeStat struct {
+	Total uint64
+	Used  uint64
+	Free  uint64
+}
+
+// CollectInodeStat collects inode statistics of the filesystem mounted on mountpoint.
+func CollectInodeStat(mountpoint string) (*InodeStat, error) {
+	usage, err := disk.Usage(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &InodeStat{
+		Total: usage.InodesTotal,
+		Used:  usage.InodesUsed,
+		Free:  usage.InodesFree,
+	}, nil
+}
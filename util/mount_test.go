@@ -0,0 +1,47 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package util
+
+import (
+	"testing"
+)
+
+func TestCollectMounts(t *testing.T) {
+	mounts, err := CollectMounts()
+	if err != nil {
+		t.Skipf("CollectMounts() failed: %s", err)
+	}
+	for _, m := range mounts {
+		if m.Mountpoint == "" {
+			t.Errorf("mountpoint should not be empty: %+v", m)
+		}
+	}
+}
+
+func TestMountStat_ReadOnly(t *testing.T) {
+	tests := []struct {
+		options []string
+		expect  bool
+	}{
+		{[]string{"rw", "relatime"}, false},
+		{[]string{"ro", "relatime"}, true},
+		{[]string{"errors=remount-ro"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		m := &MountStat{Options: tt.options}
+		if got := m.ReadOnly(); got != tt.expect {
+			t.Errorf("ReadOnly() with %v should be %t but %t", tt.options, tt.expect, got)
+		}
+	}
+}
+
+func TestCollectInodeStat(t *testing.T) {
+	stat, err := CollectInodeStat("/")
+	if err != nil {
+		t.Skipf("CollectInodeStat() failed: %s", err)
+	}
+	if stat.Total != 0 && stat.Used > stat.Total {
+		t.Errorf("used inodes should not exceed total: %+v", stat)
+	}
+}
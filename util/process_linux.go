@@ -0,0 +1,149 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/golib/logging"
+)
+
+var processLogger = logging.GetLogger("util.process")
+
+// Process is a process in the proc filesystem. The files of the process are read lazily.
+type Process struct {
+	PID int
+	Dir string
+
+	comm    *string
+	cmdline *string
+	cgroup  *string
+}
+
+// ListProcesses lists the processes in procPath, e.g. "/proc".
+func ListProcesses(procPath string) ([]*Process, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	var procs []*Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		procs = append(procs, &Process{PID: pid, Dir: filepath.Join(procPath, e.Name())})
+	}
+	return procs, nil
+}
+
+func (p *Process) read(name string, cache **string, convert func([]byte) string) string {
+	if *cache == nil {
+		out, _ := os.ReadFile(filepath.Join(p.Dir, name))
+		s := convert(out)
+		*cache = &s
+	}
+	return **cache
+}
+
+// Comm returns the command name in /proc/{pid}/comm.
+func (p *Process) Comm() string {
+	return p.read("comm", &p.comm, func(b []byte) string {
+		return strings.TrimRight(string(b), "\n")
+	})
+}
+
+// Cmdline returns the command line joined with spaces, which is empty for the kernel threads.
+func (p *Process) Cmdline() string {
+	return p.read("cmdline", &p.cmdline, func(b []byte) string {
+		return strings.TrimSpace(string(bytes.ReplaceAll(b, []byte{0}, []byte{' '})))
+	})
+}
+
+// Cgroup returns the content of /proc/{pid}/cgroup.
+func (p *Process) Cgroup() string {
+	return p.read("cgroup", &p.cgroup, func(b []byte) string { return string(b) })
+}
+
+// ProcessMatcher matches the processes by any of the command name, the command line,
+// the pid in the pidfile and the systemd unit.
+// The pidfile is read once at the first match, so create a matcher for each scan of the processes.
+type ProcessMatcher struct {
+	Name        string
+	Cmdline     *regexp.Regexp
+	Pidfile     string
+	SystemdUnit string
+
+	pidfileRead bool
+	pid         int
+}
+
+// Match reports whether the process matches.
+func (m *ProcessMatcher) Match(p *Process) bool {
+	if m.Pidfile != "" {
+		if !m.pidfileRead {
+			m.pidfileRead = true
+			pid, err := ReadPidfile(m.Pidfile)
+			if err != nil {
+				processLogger.Debugf("Failed to read the pidfile %s: %s", m.Pidfile, err)
+			}
+			m.pid = pid
+		}
+		if m.pid != 0 && m.pid == p.PID {
+			return true
+		}
+	}
+	if m.Name != "" && p.Comm() == m.Name {
+		return true
+	}
+	if m.Cmdline != nil {
+		// kernel threads do not have command lines
+		if cmdline := p.Cmdline(); cmdline != "" && m.Cmdline.MatchString(cmdline) {
+			return true
+		}
+	}
+	if m.SystemdUnit != "" && cgroupContainsUnit(p.Cgroup(), m.SystemdUnit) {
+		return true
+	}
+	return false
+}
+
+// ReadPidfile reads the pid in the pidfile.
+func ReadPidfile(file string) (int, error) {
+	out, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+/*
+cgroupContainsUnit checks /proc/{pid}/cgroup of the process contains the systemd unit.
+
+cat /proc/{pid}/cgroup sample (cgroup v2):
+
+	0::/system.slice/nginx.service
+
+cgroup v1:
+
+	12:pids:/system.slice/nginx.service
+	1:name=systemd:/system.slice/nginx.service
+*/
+func cgroupContainsUnit(cgroup, unit string) bool {
+	unit = NormalizeSystemdUnitName(unit)
+	for line := range strings.SplitSeq(cgroup, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for elem := range strings.SplitSeq(fields[2], "/") {
+			if elem == unit {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestProcessMatcher(t *testing.T) {
+	root := t.TempDir()
+	procs := map[string]map[string]string{
+		"100": {"comm": "nginx\n", "cmdline": "nginx: master process\x00", "cgroup": "0::/system.slice/nginx.service\n"},
+		"200": {"comm": "ruby\n", "cmdline": "ruby\x00app.rb\x00", "cgroup": "0::/system.slice/app.service\n"},
+		"2":   {"comm": "kthreadd\n", "cmdline": "", "cgroup": "0::/\n"},
+	}
+	for pid, files := range procs {
+		if err := os.Mkdir(filepath.Join(root, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(root, pid, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pidfile := filepath.Join(root, "app.pid")
+	if err := os.WriteFile(pidfile, []byte("200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ListProcesses(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("3 processes should be listed but %+v", list)
+	}
+
+	tests := []struct {
+		name    string
+		matcher ProcessMatcher
+		pids    []int
+	}{
+		{"name", ProcessMatcher{Name: "nginx"}, []int{100}},
+		{"cmdline", ProcessMatcher{Cmdline: regexp.MustCompile(`.*`)}, []int{100, 200}},
+		{"pidfile", ProcessMatcher{Pidfile: pidfile}, []int{200}},
+		{"missing pidfile", ProcessMatcher{Pidfile: filepath.Join(root, "none.pid")}, nil},
+		{"systemd unit", ProcessMatcher{SystemdUnit: "app"}, []int{200}},
+		{"empty", ProcessMatcher{}, nil},
+	}
+	for _, tt := range tests {
+		var pids []int
+		for _, p := range list {
+			if tt.matcher.Match(p) {
+				pids = append(pids, p.PID)
+			}
+		}
+		if len(pids) != len(tt.pids) {
+			t.Errorf("%s: matched pids should be %v but %v", tt.name, tt.pids, pids)
+			continue
+		}
+		for i := range pids {
+			if pids[i] != tt.pids[i] {
+				t.Errorf("%s: matched pids should be %v but %v", tt.name, tt.pids, pids)
+			}
+		}
+	}
+}
+
+func TestCgroupContainsUnit(t *testing.T) {
+	tests := []struct {
+		cgroup string
+		unit   string
+		expect bool
+	}{
+		{"0::/system.slice/nginx.service\n", "nginx", true},
+		{"0::/system.slice/nginx.service\n", "nginx.service", true},
+		{"0::/system.slice/nginx-exporter.service\n", "nginx", false},
+		{"12:pids:/system.slice/cron.service\n1:name=systemd:/system.slice/cron.service\n", "cron.service", true},
+		{"0::/user.slice/user-1000.slice/session-1.scope\n", "session-1.scope", true},
+		{"", "nginx", false},
+	}
+	for _, tt := range tests {
+		if got := cgroupContainsUnit(tt.cgroup, tt.unit); got != tt.expect {
+			t.Errorf("cgroupContainsUnit(%q, %q) should be %t", tt.cgroup, tt.unit, tt.expect)
+		}
+	}
+}
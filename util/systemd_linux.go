@@ -0,0 +1,187 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Songmu/timeout"
+	"github.com/mackerelio/golib/logging"
+)
+
+// SystemdUnit is the state of a systemd unit.
+// Field names are taken from the properties of `systemctl show`.
+type SystemdUnit struct {
+	ID          string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	NRestarts   int
+}
+
+var systemdLogger = logging.GetLogger("util.systemd")
+
+func runSystemctl(args ...string) (string, error) {
+	tio := &timeout.Timeout{
+		Cmd:       exec.Command("systemctl", args...),
+		Duration:  15 * time.Second,
+		KillAfter: 5 * time.Second,
+	}
+	exitSt, stdout, stderr, err := tio.Run()
+	if err != nil {
+		return "", fmt.Errorf("failed to invoke systemctl: %w", err)
+	}
+	if exitSt.Code != 0 {
+		return "", fmt.Errorf("systemctl exited with a non-zero status: %d: %q", exitSt.Code, stderr)
+	}
+	return stdout, nil
+}
+
+// ShowSystemdUnits shows the states of the units by `systemctl show`.
+// ".service" is assumed for the unit names without suffix.
+func ShowSystemdUnits(units []string) ([]*SystemdUnit, error) {
+	args := []string{"show", "--property=Id,LoadState,ActiveState,SubState,NRestarts", "--"}
+	for _, unit := range units {
+		args = append(args, NormalizeSystemdUnitName(unit))
+	}
+	out, err := runSystemctl(args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseSystemctlShow(out), nil
+}
+
+// NormalizeSystemdUnitName appends ".service" to the unit name without suffix like systemctl does.
+func NormalizeSystemdUnitName(unit string) string {
+	if !strings.Contains(unit, ".") {
+		return unit + ".service"
+	}
+	return unit
+}
+
+/*
+`systemctl show --property=Id,LoadState,ActiveState,SubState,NRestarts -- nginx.service cron.service` sample:
+
+	NRestarts=0
+	Id=nginx.service
+	LoadState=loaded
+	ActiveState=active
+	SubState=running
+
+	NRestarts=2
+	Id=cron.service
+	LoadState=loaded
+	ActiveState=failed
+	SubState=failed
+*/
+func parseSystemctlShow(out string) []*SystemdUnit {
+	var units []*SystemdUnit
+	var unit *SystemdUnit
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			unit = nil
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unit == nil {
+			unit = &SystemdUnit{}
+			units = append(units, unit)
+		}
+		switch key {
+		case "Id":
+			unit.ID = value
+		case "LoadState":
+			unit.LoadState = value
+		case "ActiveState":
+			unit.ActiveState = value
+		case "SubState":
+			unit.SubState = value
+		case "NRestarts":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				systemdLogger.Debugf("Failed to parse NRestarts of %s: %s", unit.ID, err)
+				continue
+			}
+			unit.NRestarts = n
+		}
+	}
+	return units
+}
+
+// ListFailedSystemdUnits lists the names of the units in the failed state.
+func ListFailedSystemdUnits() ([]string, error) {
+	out, err := runSystemctl("list-units", "--state=failed", "--all", "--no-legend", "--plain", "--no-pager")
+	if err != nil {
+		return nil, err
+	}
+	return parseSystemctlListUnits(out), nil
+}
+
+/*
+`systemctl list-units --state=failed --all --no-legend --plain` sample:
+
+	cron.service   loaded failed failed Regular background program processing daemon
+	● mnt-data.mount loaded failed failed /mnt/data
+
+Some versions of systemctl prefix the failed units with "●" even with --plain.
+*/
+func parseSystemctlListUnits(out string) []string {
+	var units []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && (fields[0] == "●" || fields[0] == "*") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		units = append(units, fields[0])
+	}
+	return units
+}
+
+// IsSystemPoweringOff checks whether the system is going to be powered off or halted.
+// The system going to be rebooted is not the case.
+func IsSystemPoweringOff() (bool, error) {
+	out, err := runSystemctl("list-jobs", "--no-legend", "--no-pager")
+	if err != nil {
+		return false, err
+	}
+	for _, unit := range parseSystemctlListJobs(out) {
+		if unit == "poweroff.target" || unit == "halt.target" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+`systemctl list-jobs --no-legend` sample:
+
+	881 poweroff.target                      start waiting
+	883 systemd-poweroff.service             start waiting
+	701 mackerel-agent.service               stop  running
+
+parseSystemctlListJobs returns the units of the start jobs.
+*/
+func parseSystemctlListJobs(out string) []string {
+	var units []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "start" {
+			continue
+		}
+		units = append(units, fields[1])
+	}
+	return units
+}
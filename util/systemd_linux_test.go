@@ -0,0 +1,74 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSystemctlShow(t *testing.T) {
+	out := `NRestarts=0
+Id=nginx.service
+LoadState=loaded
+ActiveState=active
+SubState=running
+
+NRestarts=2
+Id=cron.service
+LoadState=loaded
+ActiveState=failed
+SubState=failed
+
+Id=missing.service
+LoadState=not-found
+ActiveState=inactive
+SubState=dead
+`
+	expect := []*SystemdUnit{
+		{ID: "nginx.service", LoadState: "loaded", ActiveState: "active", SubState: "running", NRestarts: 0},
+		{ID: "cron.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed", NRestarts: 2},
+		{ID: "missing.service", LoadState: "not-found", ActiveState: "inactive", SubState: "dead"},
+	}
+	if got := parseSystemctlShow(out); !reflect.DeepEqual(got, expect) {
+		t.Errorf("parseSystemctlShow() should be %+v but %+v", expect, got)
+	}
+}
+
+func TestParseSystemctlListUnits(t *testing.T) {
+	out := `cron.service   loaded failed failed Regular background program processing daemon
+● mnt-data.mount loaded failed failed /mnt/data
+
+`
+	expect := []string{"cron.service", "mnt-data.mount"}
+	if got := parseSystemctlListUnits(out); !reflect.DeepEqual(got, expect) {
+		t.Errorf("parseSystemctlListUnits() should be %v but %v", expect, got)
+	}
+	if got := parseSystemctlListUnits(""); len(got) != 0 {
+		t.Errorf("no units should be listed: %v", got)
+	}
+}
+
+func TestParseSystemctlListJobs(t *testing.T) {
+	out := `881 poweroff.target                      start waiting
+883 systemd-poweroff.service             start waiting
+701 mackerel-agent.service               stop  running
+`
+	expect := []string{"poweroff.target", "systemd-poweroff.service"}
+	if got := parseSystemctlListJobs(out); !reflect.DeepEqual(got, expect) {
+		t.Errorf("parseSystemctlListJobs() should be %v but %v", expect, got)
+	}
+	if got := parseSystemctlListJobs(""); len(got) != 0 {
+		t.Errorf("no jobs should be listed: %v", got)
+	}
+}
+
+func TestNormalizeSystemdUnitName(t *testing.T) {
+	for unit, expect := range map[string]string{
+		"nginx":          "nginx.service",
+		"nginx.service":  "nginx.service",
+		"mnt-data.mount": "mnt-data.mount",
+	} {
+		if got := NormalizeSystemdUnitName(unit); got != expect {
+			t.Errorf("NormalizeSystemdUnitName(%q) should be %q but %q", unit, expect, got)
+		}
+	}
+}